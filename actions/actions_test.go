@@ -5,6 +5,9 @@ import (
 
 	"github.com/gobuffalo/packr/v2"
 	"github.com/gobuffalo/suite"
+	"github.com/tcarreira/roaw2020/cache"
+	"github.com/tcarreira/roaw2020/models"
+	"github.com/tcarreira/roaw2020/testdb"
 )
 
 type ActionSuite struct {
@@ -12,6 +15,10 @@ type ActionSuite struct {
 }
 
 func Test_ActionSuite(t *testing.T) {
+	if err := testdb.Reset(models.DB, "../migrations"); err != nil {
+		t.Fatal(err)
+	}
+
 	action, err := suite.NewActionWithFixtures(App(), packr.New("Test_ActionSuite", "../fixtures"))
 	if err != nil {
 		t.Fatal(err)
@@ -22,3 +29,9 @@ func Test_ActionSuite(t *testing.T) {
 	}
 	suite.Run(t, as)
 }
+
+// SetupTest resets the database and the cached stats
+func (as *ActionSuite) SetupTest() {
+	as.Action.SetupTest()
+	cache.Invalidate()
+}
@@ -9,8 +9,6 @@ import (
 	"github.com/gobuffalo/x/responder"
 	"github.com/gofrs/uuid"
 	"github.com/tcarreira/roaw2020/models"
-	stravaclient "github.com/tcarreira/roaw2020/strava_client"
-	"github.com/tcarreira/roaw2020/strava_client/swagger"
 )
 
 // This file is generated by Buffalo. It offers a basic structure for
@@ -131,6 +129,15 @@ func (v ActivitiesResource) Create(c buffalo.Context) error {
 		return err
 	}
 
+	if !verrs.HasAny() {
+		if err := models.RefreshWeeklyUserStats(tx, activity.UserID, activity.Datetime); err != nil {
+			return err
+		}
+		if err := invalidateCache(c); err != nil {
+			return err
+		}
+	}
+
 	if verrs.HasAny() {
 		return responder.Wants("html", func(c buffalo.Context) error {
 			// Make the errors available inside the html template
@@ -190,6 +197,7 @@ func (v ActivitiesResource) Update(c buffalo.Context) error {
 	if err := v.scope(c).Find(activity, c.Param("activity_id")); err != nil {
 		return c.Error(http.StatusNotFound, err)
 	}
+	previousDatetime := activity.Datetime
 
 	// Bind Activity to the html form elements
 	if err := c.Bind(activity); err != nil {
@@ -201,6 +209,15 @@ func (v ActivitiesResource) Update(c buffalo.Context) error {
 		return err
 	}
 
+	if !verrs.HasAny() {
+		if err := models.RefreshWeeklyUserStats(tx, activity.UserID, activity.Datetime, previousDatetime); err != nil {
+			return err
+		}
+		if err := invalidateCache(c); err != nil {
+			return err
+		}
+	}
+
 	if verrs.HasAny() {
 		return responder.Wants("html", func(c buffalo.Context) error {
 			// Make the errors available inside the html template
@@ -252,6 +269,13 @@ func (v ActivitiesResource) Destroy(c buffalo.Context) error {
 		return err
 	}
 
+	if err := models.RefreshWeeklyUserStats(tx, activity.UserID, activity.Datetime); err != nil {
+		return err
+	}
+	if err := invalidateCache(c); err != nil {
+		return err
+	}
+
 	return responder.Wants("html", func(c buffalo.Context) error {
 		// If there are no errors set a flash message
 		c.Flash().Add("success", T.Translate(c, "activity.destroyed.success"))
@@ -265,17 +289,17 @@ func (v ActivitiesResource) Destroy(c buffalo.Context) error {
 	}).Respond(c)
 }
 
-// SyncLastActivitiesHandler will import all users' latest activities from the provider and populate the database
+// SyncLastActivitiesHandler will enqueue a job (per user) importing all users' latest activities from the provider
 func SyncLastActivitiesHandler(c buffalo.Context) error {
-	return syncAllUsersActivitiesHandler(c, stravaclient.FetchLatestActivities)
+	return syncAllUsersActivitiesHandler(c, models.SyncModeLatest)
 }
 
-// SyncAllActivitiesHandler will import all users' all activities from the provider and populate the database
+// SyncAllActivitiesHandler will enqueue a job (per user) importing all users' all activities from the provider
 func SyncAllActivitiesHandler(c buffalo.Context) error {
-	return syncAllUsersActivitiesHandler(c, stravaclient.FetchAllActivities)
+	return syncAllUsersActivitiesHandler(c, models.SyncModeAll)
 }
 
-func syncAllUsersActivitiesHandler(c buffalo.Context, syncFunction func(stravaAccessToken string) ([]swagger.SummaryActivity, error)) error {
+func syncAllUsersActivitiesHandler(c buffalo.Context, mode string) error {
 	// Get the DB connection from the context
 	tx, ok := c.Value("tx").(*pop.Connection)
 	if !ok {
@@ -288,25 +312,32 @@ func syncAllUsersActivitiesHandler(c buffalo.Context, syncFunction func(stravaAc
 		return c.Error(http.StatusNotFound, err)
 	}
 
+	syncJobs := models.SyncJobs{}
 	errorsSlice := []string{}
-	for _, user := range *users {
-		if err := user.SyncActivities(tx, syncFunction); err != nil {
+	for i := range *users {
+		job, err := enqueueSyncJob(c, &(*users)[i], mode)
+		if err != nil {
 			c.Logger().Error(err)
 			c.Flash().Add("warning", err.Error())
 			errorsSlice = append(errorsSlice, err.Error())
 			continue
 		}
+		syncJobs = append(syncJobs, *job)
 	}
 
 	return responder.Wants("html", func(c buffalo.Context) error {
+		c.Flash().Add("success", fmt.Sprintf("Syncing %d users in background", len(syncJobs)))
 		return c.Redirect(http.StatusFound, "/")
 	}).Wants("json", func(c buffalo.Context) error {
 		if len(errorsSlice) > 0 {
 			return c.Render(http.StatusInternalServerError, r.JSON(errorsSlice))
 		}
-		return c.Render(http.StatusOK, r.String("ok"))
+		return c.Render(http.StatusAccepted, r.JSON(syncJobs))
 	}).Wants("xml", func(c buffalo.Context) error {
-		return c.Render(http.StatusOK, r.String("OK"))
+		if len(errorsSlice) > 0 {
+			return c.Render(http.StatusInternalServerError, r.XML(errorsSlice))
+		}
+		return c.Render(http.StatusAccepted, r.XML(syncJobs))
 	}).Respond(c)
 
 }
@@ -0,0 +1,25 @@
+package actions
+
+import (
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/x/responder"
+	stravaclient "github.com/tcarreira/roaw2020/strava_client"
+)
+
+// AdminRateLimitHandler shows how much of Strava's API budget is being used
+func AdminRateLimitHandler(c buffalo.Context) error {
+	usage := stravaclient.DefaultTransport.Usage()
+
+	return responder.Wants("html", func(c buffalo.Context) error {
+		c.Set("usage", usage)
+		c.Set("shortResetAt", usage.ShortResetAt())
+		c.Set("dailyResetAt", usage.DailyResetAt())
+		return c.Render(http.StatusOK, r.HTML("/admin/rate_limit.plush.html"))
+	}).Wants("json", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.JSON(usage))
+	}).Wants("xml", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.XML(usage))
+	}).Respond(c)
+}
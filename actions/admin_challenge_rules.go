@@ -0,0 +1,133 @@
+package actions
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/x/responder"
+	"github.com/tcarreira/roaw2020/models"
+)
+
+// AdminListChallengeRulesHandler lists the challenge rules (which activities count).
+// The oldest one is the current rule, used by seasons without their own rule.
+func AdminListChallengeRulesHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	challengeRules := &models.ChallengeRules{}
+	if err := tx.Order("created_at asc").All(challengeRules); err != nil {
+		return err
+	}
+
+	return responder.Wants("html", func(c buffalo.Context) error {
+		c.Set("challengeRules", *challengeRules)
+		c.Set("defaultChallengeRule", models.DefaultChallengeRule())
+		return c.Render(http.StatusOK, r.HTML("/admin/challenge_rules/index.plush.html"))
+	}).Wants("json", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.JSON(challengeRules))
+	}).Wants("xml", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.XML(challengeRules))
+	}).Respond(c)
+}
+
+// AdminNewChallengeRuleHandler renders the form for a new challenge rule (with the default values)
+func AdminNewChallengeRuleHandler(c buffalo.Context) error {
+	c.Set("challengeRule", models.DefaultChallengeRule())
+	return c.Render(http.StatusOK, r.HTML("/admin/challenge_rules/new.plush.html"))
+}
+
+// AdminCreateChallengeRuleHandler stores a new challenge rule
+func AdminCreateChallengeRuleHandler(c buffalo.Context) error {
+	return saveChallengeRule(c, &models.ChallengeRule{}, "/admin/challenge_rules/new.plush.html")
+}
+
+// AdminEditChallengeRuleHandler renders the form to edit a challenge rule
+func AdminEditChallengeRuleHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	challengeRule := &models.ChallengeRule{}
+	if err := tx.Find(challengeRule, c.Param("challenge_rule_id")); err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+
+	return responder.Wants("html", func(c buffalo.Context) error {
+		c.Set("challengeRule", challengeRule)
+		return c.Render(http.StatusOK, r.HTML("/admin/challenge_rules/edit.plush.html"))
+	}).Wants("json", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.JSON(challengeRule))
+	}).Wants("xml", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.XML(challengeRule))
+	}).Respond(c)
+}
+
+// AdminUpdateChallengeRuleHandler changes a challenge rule
+func AdminUpdateChallengeRuleHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	challengeRule := &models.ChallengeRule{}
+	if err := tx.Find(challengeRule, c.Param("challenge_rule_id")); err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+
+	return saveChallengeRule(c, challengeRule, "/admin/challenge_rules/edit.plush.html")
+}
+
+// saveChallengeRule binds the form to challengeRule and saves it (rendering the form again on errors)
+func saveChallengeRule(c buffalo.Context, challengeRule *models.ChallengeRule, formTemplate string) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	if err := c.Bind(challengeRule); err != nil {
+		return err
+	}
+
+	verrs, err := tx.ValidateAndSave(challengeRule)
+	if err != nil {
+		return err
+	}
+
+	if verrs.HasAny() {
+		return responder.Wants("html", func(c buffalo.Context) error {
+			c.Set("errors", verrs)
+			c.Set("challengeRule", challengeRule)
+			return c.Render(http.StatusUnprocessableEntity, r.HTML(formTemplate))
+		}).Wants("json", func(c buffalo.Context) error {
+			return c.Render(http.StatusUnprocessableEntity, r.JSON(verrs))
+		}).Wants("xml", func(c buffalo.Context) error {
+			return c.Render(http.StatusUnprocessableEntity, r.XML(verrs))
+		}).Respond(c)
+	}
+
+	// the seasons' valid activities (or weeks) may have changed
+	if _, err := models.RebuildWeeklyUserStats(tx); err != nil {
+		return err
+	}
+	if err := invalidateCache(c); err != nil {
+		return err
+	}
+
+	return responder.Wants("html", func(c buffalo.Context) error {
+		c.Flash().Add("success", "Challenge rule was successfully saved.")
+		return c.Redirect(http.StatusSeeOther, "/admin/challenge-rules")
+	}).Wants("json", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.JSON(challengeRule))
+	}).Wants("xml", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.XML(challengeRule))
+	}).Respond(c)
+}
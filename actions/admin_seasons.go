@@ -0,0 +1,169 @@
+package actions
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/x/responder"
+	"github.com/tcarreira/roaw2020/models"
+)
+
+// AdminListSeasonsHandler lists the seasons
+func AdminListSeasonsHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	seasons := &models.Seasons{}
+	if err := tx.Order("starts_on desc").All(seasons); err != nil {
+		return err
+	}
+
+	return responder.Wants("html", func(c buffalo.Context) error {
+		c.Set("seasons", *seasons)
+		c.Set("defaultSeason", models.DefaultSeason())
+		return c.Render(http.StatusOK, r.HTML("/admin/seasons/index.plush.html"))
+	}).Wants("json", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.JSON(seasons))
+	}).Wants("xml", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.XML(seasons))
+	}).Respond(c)
+}
+
+// AdminNewSeasonHandler renders the form for a new season (the ROAW_YEAR's, by default)
+func AdminNewSeasonHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	if err := setChallengeRules(c, tx); err != nil {
+		return err
+	}
+
+	c.Set("season", models.DefaultSeason())
+	return c.Render(http.StatusOK, r.HTML("/admin/seasons/new.plush.html"))
+}
+
+// AdminCreateSeasonHandler stores a new season
+func AdminCreateSeasonHandler(c buffalo.Context) error {
+	return saveSeason(c, &models.Season{}, "/admin/seasons/new.plush.html")
+}
+
+// AdminEditSeasonHandler renders the form to edit a season
+func AdminEditSeasonHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	season := &models.Season{}
+	if err := tx.Find(season, c.Param("season_id")); err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+
+	return responder.Wants("html", func(c buffalo.Context) error {
+		if err := setChallengeRules(c, tx); err != nil {
+			return err
+		}
+		c.Set("season", season)
+		return c.Render(http.StatusOK, r.HTML("/admin/seasons/edit.plush.html"))
+	}).Wants("json", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.JSON(season))
+	}).Wants("xml", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.XML(season))
+	}).Respond(c)
+}
+
+// AdminUpdateSeasonHandler changes a season
+func AdminUpdateSeasonHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	season := &models.Season{}
+	if err := tx.Find(season, c.Param("season_id")); err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+
+	return saveSeason(c, season, "/admin/seasons/edit.plush.html")
+}
+
+// saveSeason binds the form to season and saves it (rendering the form again on errors).
+// The season's rule comes from the "challenge_rule" param (empty for the current rule).
+func saveSeason(c buffalo.Context, season *models.Season, formTemplate string) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	if err := c.Bind(season); err != nil {
+		return err
+	}
+
+	season.ChallengeRuleID = nulls.UUID{}
+	if c.Param("challenge_rule") != "" {
+		challengeRule := &models.ChallengeRule{}
+		if err := tx.Find(challengeRule, c.Param("challenge_rule")); err != nil {
+			return c.Error(http.StatusUnprocessableEntity, err)
+		}
+		season.ChallengeRuleID = nulls.NewUUID(challengeRule.ID)
+	}
+
+	verrs, err := tx.ValidateAndSave(season)
+	if err != nil {
+		return err
+	}
+
+	if verrs.HasAny() {
+		return responder.Wants("html", func(c buffalo.Context) error {
+			if err := setChallengeRules(c, tx); err != nil {
+				return err
+			}
+			c.Set("errors", verrs)
+			c.Set("season", season)
+			return c.Render(http.StatusUnprocessableEntity, r.HTML(formTemplate))
+		}).Wants("json", func(c buffalo.Context) error {
+			return c.Render(http.StatusUnprocessableEntity, r.JSON(verrs))
+		}).Wants("xml", func(c buffalo.Context) error {
+			return c.Render(http.StatusUnprocessableEntity, r.XML(verrs))
+		}).Respond(c)
+	}
+
+	// the seasons' valid activities (or weeks) may have changed
+	if _, err := models.RebuildWeeklyUserStats(tx); err != nil {
+		return err
+	}
+	if err := invalidateCache(c); err != nil {
+		return err
+	}
+
+	return responder.Wants("html", func(c buffalo.Context) error {
+		c.Flash().Add("success", "Season was successfully saved.")
+		return c.Redirect(http.StatusSeeOther, "/admin/seasons")
+	}).Wants("json", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.JSON(season))
+	}).Wants("xml", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.XML(season))
+	}).Respond(c)
+}
+
+// setChallengeRules makes the challenge rules available to the season's form
+func setChallengeRules(c buffalo.Context, tx *pop.Connection) error {
+	challengeRules := &models.ChallengeRules{}
+	if err := tx.Order("created_at asc").All(challengeRules); err != nil {
+		return err
+	}
+	c.Set("challengeRules", challengeRules)
+	return nil
+}
@@ -0,0 +1,187 @@
+package actions
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gobuffalo/nulls"
+	"github.com/tcarreira/roaw2020/models"
+)
+
+// loginAdmin logs in as a new admin user
+func (as *ActionSuite) loginAdmin() *models.User {
+	admin := &models.User{Name: "Admin", Provider: "strava", ProviderID: "1", AccessToken: "access", RefreshToken: "refresh", Role: models.UserRoleAdmin}
+	as.NoError(as.DB.Create(admin))
+	as.Session.Set("current_user_id", admin.ID)
+	return admin
+}
+
+func (as *ActionSuite) Test_Admin_RequiresAdmin() {
+	// anonymous: must log in
+	for _, path := range []string{"/admin/seasons", "/activities/sync-all", "/activities/sync"} {
+		res := as.HTML(path).Get()
+		as.Equal(http.StatusFound, res.Code, path)
+	}
+
+	// members: forbidden
+	user := as.createStravaUser()
+	as.Session.Set("current_user_id", user.ID)
+	for _, path := range []string{"/admin/seasons", "/admin/challenge-rules", "/admin/rate-limit", "/activities/sync-all", "/activities/sync"} {
+		res := as.HTML(path).Get()
+		as.Equal(http.StatusForbidden, res.Code, path)
+	}
+	count, err := as.DB.Count(&models.SyncJob{})
+	as.NoError(err)
+	as.Equal(0, count)
+
+	// admins
+	as.loginAdmin()
+	res := as.HTML("/admin/seasons").Get()
+	as.Equal(http.StatusOK, res.Code)
+	jres := as.JSON("/activities/sync-all").Get()
+	as.Equal(http.StatusAccepted, jres.Code)
+	count, err = as.DB.Count(&models.SyncJob{})
+	as.NoError(err)
+	as.Equal(2, count)
+}
+
+func (as *ActionSuite) Test_AdminCreateChallengeRule() {
+	as.loginAdmin()
+
+	res := as.HTML("/admin/challenge-rules").Post(map[string]interface{}{
+		"Name":           "Long runs",
+		"ActivityTypes":  "Run,VirtualRun",
+		"MinDistance":    "10000",
+		"MinElapsedTime": "0",
+		"AllowManual":    "false",
+		"AllowTrainer":   "true",
+	})
+	as.Equal(http.StatusSeeOther, res.Code)
+
+	rule, err := models.CurrentChallengeRule(models.DB)
+	as.NoError(err)
+	as.Equal("Run,VirtualRun", rule.ActivityTypes)
+	as.Equal(10000, rule.MinDistance)
+	as.False(rule.AllowManual)
+	as.True(rule.AllowTrainer)
+
+	res = as.HTML("/admin/challenge-rules/%s", rule.ID).Get()
+	as.Equal(http.StatusOK, res.Code)
+
+	// invalid: no activity types
+	res = as.HTML("/admin/challenge-rules/%s", rule.ID).Post(map[string]interface{}{"Name": "Nothing", "ActivityTypes": " , "})
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+}
+
+func (as *ActionSuite) Test_AdminCreateSeason() {
+	user := as.loginAdmin()
+	rule := &models.ChallengeRule{Name: "Long runs", ActivityTypes: "Run", MinDistance: 10000}
+	as.NoError(as.DB.Create(rule))
+
+	res := as.HTML("/admin/seasons").Post(map[string]interface{}{
+		"Name":           "2019",
+		"StartsOn":       "2019-01-01",
+		"EndsOn":         "2020-01-01",
+		"challenge_rule": rule.ID.String(),
+	})
+	as.Equal(http.StatusSeeOther, res.Code)
+
+	season := &models.Season{}
+	as.NoError(as.DB.Where("name = ?", "2019").First(season))
+	as.Equal(time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), season.StartsOn.UTC())
+	as.Equal(rule.ID, season.ChallengeRuleID.UUID)
+
+	res = as.HTML("/admin/seasons/%s", season.ID).Get()
+	as.Equal(http.StatusOK, res.Code)
+
+	// the season is browsable
+	res = as.HTML("/users/%s?season=%s", user.ID, season.ID).Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "of at least 10.0 km")
+
+	// invalid: ends before it starts
+	res = as.HTML("/admin/seasons").Post(map[string]interface{}{"Name": "Wrong", "StartsOn": "2019-01-01", "EndsOn": "2018-01-01"})
+	as.Equal(http.StatusUnprocessableEntity, res.Code)
+}
+
+func (as *ActionSuite) Test_AdminUsers() {
+	admin := as.loginAdmin()
+	user := as.createStravaUser()
+	user.NeedsReauth = true
+	as.NoError(as.DB.Update(user))
+	as.NoError((&models.Activity{UserID: user.ID, Provider: "strava", ProviderID: "1", Name: "Run", Type: "Run", Datetime: time.Now(), Distance: 5000, ElapsedTime: 1800}).CreateOrUpdate(models.DB))
+	as.NoError(as.DB.Create(&models.SyncJob{UserID: user.ID, Mode: models.SyncModeAll, Status: models.SyncJobFailed, LastError: nulls.NewString("strava is down"), MaxAttempts: 5}))
+
+	res := as.HTML("/admin").Get()
+	as.Equal(http.StatusFound, res.Code)
+
+	res = as.HTML("/admin/users").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "strava is down")
+	as.Contains(res.Body.String(), "needs re-auth")
+
+	rows := []adminUser{}
+	jres := as.JSON("/admin/users").Get()
+	as.Equal(http.StatusOK, jres.Code)
+	jres.Bind(&rows)
+	as.Len(rows, 2)
+	for _, row := range rows {
+		if row.ID == user.ID {
+			as.Equal(1, row.ActivitiesCount)
+			as.Equal("strava is down", row.LastSyncError())
+		}
+	}
+
+	// resync
+	res = as.HTML("/admin/users/%s/sync", user.ID).Post(nil)
+	as.Equal(http.StatusSeeOther, res.Code)
+	count, err := as.DB.Where("user_id = ? AND status = ?", user.ID, models.SyncJobQueued).Count(&models.SyncJob{})
+	as.NoError(err)
+	as.Equal(1, count)
+
+	// hidden from the leaderboards
+	res = as.HTML("/admin/users/%s", user.ID).Put(map[string]interface{}{"hidden_from_leaderboards": "true"})
+	as.Equal(http.StatusSeeOther, res.Code)
+	as.NoError(as.DB.Reload(user))
+	as.True(user.HiddenFromLeaderboards)
+
+	leaderboard := struct {
+		Data apiV1Leaderboard `json:"data"`
+	}{}
+	jres = as.JSON("/api/v1/leaderboards/activities").Get()
+	as.Equal(http.StatusOK, jres.Code)
+	as.decodeAPI(jres.Body.String(), &leaderboard)
+	as.Len(leaderboard.Data.Entries, 1)
+	as.Equal(admin.ID.String(), leaderboard.Data.Entries[0].UserID)
+
+	// roles: the last admin stays one
+	res = as.HTML("/admin/users/%s", admin.ID).Put(map[string]interface{}{"role": models.UserRoleMember})
+	as.Equal(http.StatusSeeOther, res.Code)
+	as.NoError(as.DB.Reload(admin))
+	as.True(admin.IsAdmin())
+
+	res = as.HTML("/admin/users/%s", user.ID).Put(map[string]interface{}{"role": models.UserRoleAdmin})
+	as.Equal(http.StatusSeeOther, res.Code)
+	as.NoError(as.DB.Reload(user))
+	as.True(user.IsAdmin())
+
+	// delete, with the activities
+	res = as.HTML("/admin/users/%s", user.ID).Delete()
+	as.Equal(http.StatusSeeOther, res.Code)
+	count, err = as.DB.Where("user_id = ?", user.ID).Count(&models.Activity{})
+	as.NoError(err)
+	as.Equal(0, count)
+	exists, err := as.DB.Where("id = ?", user.ID).Exists(&models.User{})
+	as.NoError(err)
+	as.False(exists)
+
+	// audited (the user's Strava access was already revoked)
+	audit := &models.AuditLog{}
+	as.NoError(as.DB.Where("user_id = ?", user.ID).First(audit))
+	as.Equal(admin.ID, audit.ActorID.UUID)
+	as.Contains(audit.Details, "Strava access already revoked")
+
+	res = as.HTML("/admin/audit-logs").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), user.ID.String())
+}
@@ -0,0 +1,295 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/validate/v3"
+	"github.com/gobuffalo/x/responder"
+	"github.com/gofrs/uuid"
+	"github.com/tcarreira/roaw2020/models"
+	stravaclient "github.com/tcarreira/roaw2020/strava_client"
+)
+
+// adminRecentSyncJobs is how many sync jobs are shown on the admin console
+const adminRecentSyncJobs = 20
+
+// adminUser is a user, as seen by the admins: with their sync health and activities count
+type adminUser struct {
+	models.User
+	ActivitiesCount int             `json:"activities_count" xml:"activities_count"`
+	LastSyncJob     *models.SyncJob `json:"last_sync_job" xml:"last_sync_job"`
+}
+
+// TokenStatus describes the user's Strava token
+func (u adminUser) TokenStatus() string {
+	switch {
+	case u.NeedsReauth:
+		return "needs re-auth"
+	case !u.TokenExpiresAt.Valid:
+		return "unknown expiry"
+	case u.AccessTokenExpired():
+		return "expired (refreshed on next sync)"
+	}
+	return "valid until " + u.TokenExpiresAt.Time.Format("2006-01-02 15:04")
+}
+
+// LastSyncStatus is the status of the user's last sync job (empty if never synced)
+func (u adminUser) LastSyncStatus() string {
+	if u.LastSyncJob == nil {
+		return ""
+	}
+	return u.LastSyncJob.Status
+}
+
+// LastSyncError is the error of the user's last sync job, unless it succeeded
+func (u adminUser) LastSyncError() string {
+	if u.LastSyncJob == nil || u.LastSyncJob.Status == models.SyncJobSucceeded {
+		return ""
+	}
+	return u.LastSyncJob.LastError.String
+}
+
+// adminUsers adds their activities count and last sync job to the users
+func adminUsers(tx *pop.Connection, users models.Users) ([]adminUser, error) {
+	adminUsers := make([]adminUser, len(users))
+	if len(users) == 0 {
+		return adminUsers, nil
+	}
+
+	userIDs := make([]interface{}, len(users))
+	for i, user := range users {
+		userIDs[i] = user.ID
+	}
+
+	counts := []struct {
+		UserID uuid.UUID `db:"user_id"`
+		Count  int       `db:"count"`
+	}{}
+	query := "SELECT user_id, COUNT(*) AS count FROM activities WHERE deleted_at IS NULL AND user_id IN (" +
+		strings.TrimSuffix(strings.Repeat("?, ", len(userIDs)), ", ") + ") GROUP BY user_id"
+	if err := tx.RawQuery(query, userIDs...).All(&counts); err != nil {
+		return nil, err
+	}
+	activitiesCount := map[uuid.UUID]int{}
+	for _, count := range counts {
+		activitiesCount[count.UserID] = count.Count
+	}
+
+	syncJobs := models.SyncJobs{}
+	q := tx.Where("user_id in (?)", userIDs...).
+		Where("created_at = (SELECT MAX(j.created_at) FROM sync_jobs j WHERE j.user_id = sync_jobs.user_id)")
+	if err := q.All(&syncJobs); err != nil {
+		return nil, err
+	}
+	lastSyncJobs := map[uuid.UUID]*models.SyncJob{}
+	for i := range syncJobs {
+		lastSyncJobs[syncJobs[i].UserID] = &syncJobs[i]
+	}
+
+	for i, user := range users {
+		adminUsers[i] = adminUser{User: user, ActivitiesCount: activitiesCount[user.ID], LastSyncJob: lastSyncJobs[user.ID]}
+	}
+	return adminUsers, nil
+}
+
+// AdminHandler is the admin console's home: the users
+func AdminHandler(c buffalo.Context) error {
+	return c.Redirect(http.StatusFound, "/admin/users")
+}
+
+// AdminListUsersHandler lists the users with their sync health (last sync and its error, token status) and activities count,
+// and the recent sync jobs
+func AdminListUsersHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	users := models.Users{}
+
+	// Paginate results. Params "page" and "per_page" control pagination.
+	// Default values are "page=1" and "per_page=20".
+	q := tx.PaginateFromParams(c.Params())
+	if err := q.Order("name asc").All(&users); err != nil {
+		return err
+	}
+
+	rows, err := adminUsers(tx, users)
+	if err != nil {
+		return err
+	}
+
+	return responder.Wants("html", func(c buffalo.Context) error {
+		syncJobs := models.SyncJobs{}
+		if err := tx.Order("created_at desc").Limit(adminRecentSyncJobs).All(&syncJobs); err != nil {
+			return err
+		}
+		userNames, err := usersNames(tx, syncJobs)
+		if err != nil {
+			return err
+		}
+
+		c.Set("pagination", q.Paginator)
+		c.Set("users", rows)
+		c.Set("syncJobs", syncJobs)
+		c.Set("userName", func(userID uuid.UUID) string { return userNames[userID] })
+		return c.Render(http.StatusOK, r.HTML("/admin/users/index.plush.html"))
+	}).Wants("json", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.JSON(rows))
+	}).Wants("xml", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.XML(rows))
+	}).Respond(c)
+}
+
+// adminFindUser finds the user_id param's user
+func adminFindUser(c buffalo.Context, tx *pop.Connection) (*models.User, error) {
+	user := &models.User{}
+	if err := tx.Find(user, c.Param("user_id")); err != nil {
+		return nil, c.Error(http.StatusNotFound, err)
+	}
+	return user, nil
+}
+
+// AdminSyncUserHandler enqueues a job importing all the user's activities again
+func AdminSyncUserHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	user, err := adminFindUser(c, tx)
+	if err != nil {
+		return err
+	}
+
+	syncJob, err := enqueueSyncJob(c, user, models.SyncModeAll)
+	if err != nil {
+		return err
+	}
+
+	return responder.Wants("html", func(c buffalo.Context) error {
+		c.Flash().Add("success", fmt.Sprintf("Syncing %s in background", user.Name))
+		return c.Redirect(http.StatusSeeOther, "/admin/users")
+	}).Wants("json", func(c buffalo.Context) error {
+		return c.Render(http.StatusAccepted, r.JSON(syncJob))
+	}).Wants("xml", func(c buffalo.Context) error {
+		return c.Render(http.StatusAccepted, r.XML(syncJob))
+	}).Respond(c)
+}
+
+// AdminUpdateUserHandler changes the user's role ("role" param) or hides it from the leaderboards ("hidden_from_leaderboards" param).
+// The last admin can not stop being one
+func AdminUpdateUserHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	user, err := adminFindUser(c, tx)
+	if err != nil {
+		return err
+	}
+
+	if role := c.Param("role"); role != "" {
+		if err := user.SetRole(tx, role); err != nil {
+			return adminUserChanged(c, user, err)
+		}
+	}
+	if hidden := c.Param("hidden_from_leaderboards"); hidden != "" {
+		value, err := strconv.ParseBool(hidden)
+		if err != nil {
+			return c.Error(http.StatusBadRequest, fmt.Errorf("invalid hidden_from_leaderboards %q", hidden))
+		}
+		if err := user.SetHiddenFromLeaderboards(tx, value); err != nil {
+			return err
+		}
+		if err := invalidateCache(c); err != nil {
+			return err
+		}
+	}
+
+	return adminUserChanged(c, user, nil)
+}
+
+// adminUserChanged redirects to the users, with the outcome of a change (on the flash)
+func adminUserChanged(c buffalo.Context, user *models.User, err error) error {
+	var verrs *validate.Errors
+	switch {
+	case errors.Is(err, models.ErrLastAdmin):
+		c.Flash().Add("danger", "There must be at least one admin")
+	case errors.As(err, &verrs):
+		c.Flash().Add("danger", verrs.Error())
+	case err != nil:
+		return err
+	default:
+		c.Flash().Add("success", fmt.Sprintf("%s was successfully updated", user.Name))
+	}
+	return c.Redirect(http.StatusSeeOther, "/admin/users")
+}
+
+// AdminDeleteUserHandler deletes a user, with their activities (and stats, tokens, sync jobs and memberships),
+// revoking the app's access on Strava (see models.User.DeleteAccount). Admins can not delete themselves here
+func AdminDeleteUserHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	user, err := adminFindUser(c, tx)
+	if err != nil {
+		return err
+	}
+	current := c.Value("current_user").(*models.User)
+	if current.ID == user.ID {
+		c.Flash().Add("danger", "Admins can not delete themselves here")
+		return c.Redirect(http.StatusSeeOther, "/admin/users")
+	}
+
+	if err := user.DeleteAccount(tx, current, stravaclient.Deauthorize); err != nil {
+		return err
+	}
+	if err := invalidateCache(c); err != nil {
+		return err
+	}
+
+	c.Flash().Add("success", fmt.Sprintf("%s was deleted, with their activities", user.Name))
+	return c.Redirect(http.StatusSeeOther, "/admin/users")
+}
+
+// AdminListAuditLogsHandler lists the audit log (eg: users' deletions), latest first
+func AdminListAuditLogsHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	auditLogs := models.AuditLogs{}
+
+	// Paginate results. Params "page" and "per_page" control pagination.
+	// Default values are "page=1" and "per_page=20".
+	q := tx.PaginateFromParams(c.Params())
+	if err := q.Order("created_at desc").All(&auditLogs); err != nil {
+		return err
+	}
+
+	return responder.Wants("html", func(c buffalo.Context) error {
+		c.Set("pagination", q.Paginator)
+		c.Set("auditLogs", auditLogs)
+		return c.Render(http.StatusOK, r.HTML("/admin/audit_logs/index.plush.html"))
+	}).Wants("json", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.JSON(auditLogs))
+	}).Wants("xml", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.XML(auditLogs))
+	}).Respond(c)
+}
@@ -0,0 +1,134 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/packr/v2"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/tcarreira/roaw2020/models"
+)
+
+// apiBox holds the API documents (eg: v1/openapi.json)
+var apiBox = packr.New("app:api", "../api")
+
+// apiMaxPerPage bounds the "per_page" param of the API's paginated lists
+const apiMaxPerPage = 100
+
+// apiEnvelope wraps every successful API response
+type apiEnvelope struct {
+	Data interface{} `json:"data"`
+	Meta *apiMeta    `json:"meta,omitempty"`
+}
+
+// apiMeta is the pagination of a list
+type apiMeta struct {
+	Page         int `json:"page"`
+	PerPage      int `json:"per_page"`
+	TotalEntries int `json:"total_entries"`
+	TotalPages   int `json:"total_pages"`
+}
+
+// apiErrorEnvelope wraps every API error
+type apiErrorEnvelope struct {
+	Error apiError `json:"error"`
+}
+
+// apiError describes an API error: its HTTP status, a stable code (eg: "not_found") and a message
+type apiError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// apiErrorCode returns the snake cased status text (eg: "unprocessable_entity")
+func apiErrorCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// apiRender renders data, wrapped in the API's envelope
+func apiRender(c buffalo.Context, status int, data interface{}) error {
+	return c.Render(status, r.JSON(apiEnvelope{Data: data}))
+}
+
+// apiRenderPage renders a page of a list, with its pagination
+func apiRenderPage(c buffalo.Context, data interface{}, paginator *pop.Paginator) error {
+	return c.Render(http.StatusOK, r.JSON(apiEnvelope{
+		Data: data,
+		Meta: &apiMeta{
+			Page:         paginator.Page,
+			PerPage:      paginator.PerPage,
+			TotalEntries: paginator.TotalEntriesSize,
+			TotalPages:   paginator.TotalPages,
+		},
+	}))
+}
+
+// apiPaginate paginates the query with the "page" and "per_page" params (default: 1 and 20, at most apiMaxPerPage)
+func apiPaginate(c buffalo.Context, tx *pop.Connection) *pop.Query {
+	paginator := pop.NewPaginatorFromParams(c.Params())
+	if paginator.PerPage > apiMaxPerPage {
+		paginator.PerPage = apiMaxPerPage
+	}
+	return tx.Paginate(paginator.Page, paginator.PerPage)
+}
+
+// APIErrors renders the errors of the API's handlers in the API's error envelope.
+// Internal errors are logged, and their details are not rendered
+func APIErrors(next buffalo.Handler) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		err := next(c)
+		if err == nil {
+			return nil
+		}
+
+		status := http.StatusInternalServerError
+		var httpError buffalo.HTTPError
+		if errors.As(err, &httpError) {
+			status = httpError.Status
+			if httpError.Cause != nil {
+				err = httpError.Cause
+			}
+		}
+
+		message := err.Error()
+		if status >= http.StatusInternalServerError {
+			c.Logger().Error(err)
+			message = http.StatusText(status)
+		}
+
+		return c.Render(status, r.JSON(apiErrorEnvelope{Error: apiError{
+			Status:  status,
+			Code:    apiErrorCode(status),
+			Message: message,
+		}}))
+	}
+}
+
+// APIAuthorize enforces a logged in user on the API (401 Unauthorized, instead of Authorize's redirect)
+func APIAuthorize(next buffalo.Handler) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		if _, ok := c.Value("current_user").(*models.User); !ok {
+			return c.Error(http.StatusUnauthorized, errors.New("you must be authenticated"))
+		}
+		return next(c)
+	}
+}
+
+// APIOpenAPIHandler serves the OpenAPI document of an API version (maintained on api/<version>/openapi.json)
+func APIOpenAPIHandler(version string) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		document, err := apiBox.Find(version + "/openapi.json")
+		if err != nil {
+			return c.Error(http.StatusNotFound, fmt.Errorf("no OpenAPI document for %s", version))
+		}
+
+		c.Response().Header().Set("Content-Type", "application/json")
+		c.Response().WriteHeader(http.StatusOK)
+		_, err = c.Response().Write(document)
+		return err
+	}
+}
@@ -0,0 +1,111 @@
+package actions
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/tcarreira/roaw2020/models"
+)
+
+// currentUserTokens checks the user_id param is the current user (tokens are personal) and sets the user's tokens
+func currentUserTokens(c buffalo.Context, tx *pop.Connection) (*models.User, error) {
+	user, ok := c.Value("current_user").(*models.User)
+	if !ok || user.ID.String() != c.Param("user_id") {
+		return nil, c.Error(http.StatusForbidden, fmt.Errorf("API tokens are personal"))
+	}
+
+	apiTokens, err := models.UserAPITokens(tx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Set("user", user)
+	c.Set("apiTokens", apiTokens)
+	c.Set("apiTokenScopes", []string{models.APITokenScopeRead, models.APITokenScopeReadWrite})
+	return user, nil
+}
+
+// ListAPITokensHandler lists the current user's API tokens. This function is mapped to the path
+// GET /users/{user_id}/tokens
+func ListAPITokensHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	if _, err := currentUserTokens(c, tx); err != nil {
+		return err
+	}
+
+	c.Set("apiToken", &models.APIToken{Scope: models.APITokenScopeRead})
+	c.Set("newToken", "")
+	return c.Render(http.StatusOK, r.HTML("/users/tokens.plush.html"))
+}
+
+// CreateAPITokensHandler creates an API token, shown (once) on the tokens list. This function is mapped to the path
+// POST /users/{user_id}/tokens
+func CreateAPITokensHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	user, err := currentUserTokens(c, tx)
+	if err != nil {
+		return err
+	}
+
+	apiToken := &models.APIToken{}
+	if err := c.Bind(apiToken); err != nil {
+		return err
+	}
+
+	token, verrs, err := models.CreateAPIToken(tx, apiToken, user)
+	if err != nil {
+		return err
+	}
+	if verrs.HasAny() {
+		c.Set("apiToken", apiToken)
+		c.Set("newToken", "")
+		c.Set("errors", verrs)
+		return c.Render(http.StatusUnprocessableEntity, r.HTML("/users/tokens.plush.html"))
+	}
+
+	// rendered (not redirected): the token must not be kept on the session's flash
+	if _, err := currentUserTokens(c, tx); err != nil {
+		return err
+	}
+	c.Set("apiToken", &models.APIToken{Scope: models.APITokenScopeRead})
+	c.Set("newToken", token)
+	return c.Render(http.StatusCreated, r.HTML("/users/tokens.plush.html"))
+}
+
+// DeleteAPITokensHandler revokes an API token of the current user. This function is mapped to the path
+// DELETE /users/{user_id}/tokens/{api_token_id}
+func DeleteAPITokensHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	user, err := currentUserTokens(c, tx)
+	if err != nil {
+		return err
+	}
+
+	apiToken := &models.APIToken{}
+	if err := tx.Where("user_id = ?", user.ID).Find(apiToken, c.Param("api_token_id")); err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+	if err := tx.Destroy(apiToken); err != nil {
+		return err
+	}
+
+	c.Flash().Add("success", fmt.Sprintf("API token %q was revoked", apiToken.Name))
+	return c.Redirect(http.StatusSeeOther, "/users/%s/tokens", user.ID)
+}
@@ -0,0 +1,97 @@
+package actions
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/tcarreira/roaw2020/models"
+)
+
+func (as *ActionSuite) Test_APITokens_CreateUseAndRevoke() {
+	user := as.createStravaUser()
+	as.Session.Set("current_user_id", user.ID)
+
+	res := as.HTML("/users/%s/tokens", user.ID).Post(map[string]interface{}{"Name": "Slack bot", "Scope": models.APITokenScopeRead})
+	as.Equal(http.StatusCreated, res.Code)
+	token := regexp.MustCompile(`roaw_[0-9a-f]{64}`).FindString(res.Body.String())
+	as.NotEmpty(token)
+
+	res = as.HTML("/users/%s/tokens", user.ID).Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Slack bot")
+	as.NotContains(res.Body.String(), token) // shown once
+
+	// tokens are personal
+	other := &models.User{Name: "Friend", Provider: "strava", ProviderID: "43"}
+	as.NoError(as.DB.Create(other))
+	as.Equal(http.StatusForbidden, as.HTML("/users/%s/tokens", other.ID).Get().Code)
+
+	as.Session.Clear()
+	req := as.JSON("/api/v1/users/%s", user.ID)
+	req.Headers["Authorization"] = "Bearer " + token
+	as.Equal(http.StatusOK, req.Get().Code)
+
+	// read tokens only allow GET requests
+	req = as.JSON("/api/v1/users/%s/sync", user.ID)
+	req.Headers["Authorization"] = "Bearer " + token
+	as.Equal(http.StatusForbidden, req.Post(nil).Code)
+
+	apiToken, err := models.FindAPIToken(models.DB, token)
+	as.NoError(err)
+	as.True(apiToken.LastUsedAt.Valid)
+
+	as.Session.Set("current_user_id", user.ID)
+	res = as.HTML("/users/%s/tokens/%s", user.ID, apiToken.ID).Delete()
+	as.Equal(http.StatusSeeOther, res.Code)
+
+	as.Session.Clear()
+	req = as.JSON("/api/v1/users/%s", user.ID)
+	req.Headers["Authorization"] = "Bearer " + token
+	as.Equal(http.StatusUnauthorized, req.Get().Code)
+}
+
+func (as *ActionSuite) Test_APITokens_ReadWriteSync() {
+	user := as.createStravaUser()
+	apiToken := &models.APIToken{Name: "Cron", Scope: models.APITokenScopeReadWrite}
+	token, _, err := models.CreateAPIToken(models.DB, apiToken, user)
+	as.NoError(err)
+
+	req := as.JSON("/api/v1/users/%s/sync?mode=all", user.ID)
+	req.Headers["Authorization"] = "Bearer " + token
+	res := req.Post(nil)
+	as.Equal(http.StatusAccepted, res.Code)
+	as.Contains(res.Body.String(), `"mode":"all"`)
+}
+
+func (as *ActionSuite) Test_APITokens_SkipCSRFOnlyOnTheAPI() {
+	protect := csrfProtect
+	defer func() { csrfProtect = protect }()
+	csrfProtect = func(next buffalo.Handler) buffalo.Handler {
+		return func(c buffalo.Context) error {
+			return c.Error(http.StatusForbidden, errors.New("CSRF token invalid"))
+		}
+	}
+
+	user := as.createStravaUser()
+	apiToken := &models.APIToken{Name: "Cron", Scope: models.APITokenScopeReadWrite}
+	token, _, err := models.CreateAPIToken(models.DB, apiToken, user)
+	as.NoError(err)
+
+	req := as.JSON("/api/v1/users/%s/sync", user.ID)
+	req.Headers["Authorization"] = "Bearer " + token
+	as.Equal(http.StatusAccepted, req.Post(nil).Code)
+
+	// an invalid token is not let through
+	req = as.JSON("/api/v1/users/%s/sync", user.ID)
+	req.Headers["Authorization"] = "Bearer roaw_invalid"
+	as.Equal(http.StatusUnauthorized, req.Post(nil).Code)
+
+	// the session is still checked, on the API and elsewhere (even with a bearer token)
+	as.Session.Set("current_user_id", user.ID)
+	as.Equal(http.StatusForbidden, as.JSON("/api/v1/users/%s/sync", user.ID).Post(nil).Code)
+	req = as.JSON("/users/%s/tokens", user.ID)
+	req.Headers["Authorization"] = "Bearer " + token
+	as.Equal(http.StatusForbidden, req.Post(map[string]interface{}{"Name": "Forged", "Scope": models.APITokenScopeRead}).Code)
+}
@@ -0,0 +1,502 @@
+package actions
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gofrs/uuid"
+	"github.com/tcarreira/roaw2020/models"
+	"github.com/tcarreira/roaw2020/stats"
+)
+
+// The /api/v1 DTOs: the stable representation of the models, documented on api/v1/openapi.json.
+// Fields may be added, but not renamed or removed (that needs a new API version)
+
+// apiV1User is a user (without its provider's tokens)
+type apiV1User struct {
+	ID           uuid.UUID  `json:"id"`
+	Name         string     `json:"name"`
+	AvatarURL    string     `json:"avatar_url"`
+	Provider     string     `json:"provider"`
+	ProviderID   string     `json:"provider_id"`
+	LastSyncedAt *time.Time `json:"last_synced_at"`
+	NeedsReauth  bool       `json:"needs_reauth"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+func newAPIV1User(u models.User) apiV1User {
+	user := apiV1User{
+		ID:          u.ID,
+		Name:        u.Name,
+		AvatarURL:   u.AvatarURL,
+		Provider:    u.Provider,
+		ProviderID:  u.ProviderID,
+		NeedsReauth: u.NeedsReauth,
+		CreatedAt:   u.CreatedAt,
+	}
+	if u.LastSyncedAt.Valid {
+		user.LastSyncedAt = &u.LastSyncedAt.Time
+	}
+	return user
+}
+
+// apiV1Activity is an activity (distance in meters, times in seconds, elevation in meters)
+type apiV1Activity struct {
+	ID                 uuid.UUID `json:"id"`
+	UserID             uuid.UUID `json:"user_id"`
+	Provider           string    `json:"provider"`
+	ProviderID         string    `json:"provider_id"`
+	Name               string    `json:"name"`
+	Type               string    `json:"type"`
+	Datetime           time.Time `json:"datetime"`
+	Timezone           string    `json:"timezone"`
+	Distance           int       `json:"distance"`
+	MovingTime         int       `json:"moving_time"`
+	ElapsedTime        int       `json:"elapsed_time"`
+	TotalElevationGain float64   `json:"total_elevation_gain"`
+	Manual             bool      `json:"manual"`
+	Trainer            bool      `json:"trainer"`
+	Commute            bool      `json:"commute"`
+}
+
+func newAPIV1Activity(a models.Activity) apiV1Activity {
+	return apiV1Activity{
+		ID:                 a.ID,
+		UserID:             a.UserID,
+		Provider:           a.Provider,
+		ProviderID:         a.ProviderID,
+		Name:               a.Name,
+		Type:               a.Type,
+		Datetime:           a.Datetime,
+		Timezone:           a.Timezone,
+		Distance:           a.Distance,
+		MovingTime:         a.MovingTime,
+		ElapsedTime:        a.ElapsedTime,
+		TotalElevationGain: a.TotalElevationGain,
+		Manual:             a.Manual,
+		Trainer:            a.Trainer,
+		Commute:            a.Commute,
+	}
+}
+
+// apiV1SyncJob is a background sync of a user's activities (see models.SyncJob)
+type apiV1SyncJob struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Mode      string    `json:"mode"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newAPIV1SyncJob(j *models.SyncJob) apiV1SyncJob {
+	return apiV1SyncJob{
+		ID:        j.ID,
+		UserID:    j.UserID,
+		Mode:      j.Mode,
+		Status:    j.Status,
+		CreatedAt: j.CreatedAt,
+	}
+}
+
+// apiV1Season is a season and its weeks. The DefaultSeason (used while there is none) has a nil id
+type apiV1Season struct {
+	ID       uuid.UUID          `json:"id"`
+	Name     string             `json:"name"`
+	StartsOn time.Time          `json:"starts_on"`
+	EndsOn   time.Time          `json:"ends_on"`
+	Weeks    []models.WeekLabel `json:"weeks"`
+}
+
+func newAPIV1Season(s *models.Season) apiV1Season {
+	return apiV1Season{
+		ID:       s.ID,
+		Name:     s.Name,
+		StartsOn: s.StartsOn,
+		EndsOn:   s.EndsOn,
+		Weeks:    s.Weeks().Labels(),
+	}
+}
+
+// apiV1Metric is a ranked metric (eg: /api/v1/leaderboards/distance) and its unit
+type apiV1Metric struct {
+	metric stats.Metric
+	unit   string
+}
+
+// apiV1Metrics are the metrics of the leaderboards and the weekly series, by name
+var apiV1Metrics = map[string]apiV1Metric{
+	"distance":             {stats.TotalDistance, "m"},
+	"activities":           {stats.ActivityCount, "activities"},
+	"elapsed_time":         {stats.TotalElapsedTime, "s"},
+	"moving_time":          {stats.TotalMovingTime, "s"},
+	"elevation_gain":       {stats.TotalElevation, "m"},
+	"longest_distance":     {stats.MostDistance, "m"},
+	"longest_elapsed_time": {stats.MostElapsedTime, "s"},
+}
+
+// apiV1LeaderboardEntry is a user's rank on a leaderboard
+type apiV1LeaderboardEntry struct {
+	Rank     int    `json:"rank"`
+	UserID   string `json:"user_id"`
+	UserName string `json:"user_name"`
+	Value    int    `json:"value"`
+}
+
+// apiV1Leaderboard ranks the users in scope by a metric
+type apiV1Leaderboard struct {
+	Metric  string                  `json:"metric"`
+	Unit    string                  `json:"unit"`
+	Season  apiV1Season             `json:"season"`
+	Entries []apiV1LeaderboardEntry `json:"entries"`
+}
+
+// apiV1UserSeries is a user's value on every week of the season
+type apiV1UserSeries struct {
+	UserID   string `json:"user_id"`
+	UserName string `json:"user_name"`
+	Values   []int  `json:"values"` // values[i] is week i+1
+}
+
+// apiV1Weekly are the weekly (or cumulative) series of a metric of the users in scope
+type apiV1Weekly struct {
+	Metric     string             `json:"metric"`
+	Unit       string             `json:"unit"`
+	Cumulative bool               `json:"cumulative"`
+	Season     apiV1Season        `json:"season"`
+	Series     []apiV1UserSeries  `json:"series"`
+	Weeks      []models.WeekLabel `json:"weeks"`
+}
+
+// apiV1Streak is a user's streak calendar (see computeStreak)
+type apiV1Streak struct {
+	UserID      string            `json:"user_id"`
+	UserName    string            `json:"user_name"`
+	Current     int               `json:"current"`
+	Longest     int               `json:"longest"`
+	MissedWeeks []int             `json:"missed_weeks"`
+	Weeks       []apiV1StreakWeek `json:"weeks"`
+}
+
+// apiV1StreakWeek is a week of a streak calendar. Status is one of: ran, missed, pending, future
+type apiV1StreakWeek struct {
+	Week     int       `json:"week"`
+	StartsOn time.Time `json:"starts_on"`
+	Count    int       `json:"count"`
+	Status   string    `json:"status"`
+}
+
+func newAPIV1Streak(s userStreak) apiV1Streak {
+	streak := apiV1Streak{
+		UserID:      s.UserID,
+		UserName:    s.User,
+		Current:     s.Current,
+		Longest:     s.Longest,
+		MissedWeeks: s.MissedWeeks,
+		Weeks:       []apiV1StreakWeek{},
+	}
+	for _, week := range s.Weeks {
+		streak.Weeks = append(streak.Weeks, apiV1StreakWeek(week))
+	}
+	return streak
+}
+
+// getAPIScope returns the season and group of the "season" and "group" params.
+// Unlike getDashboardScope, the selected group is not kept on the session
+func getAPIScope(c buffalo.Context, tx *pop.Connection) (dashboardScope, error) {
+	season, err := models.FindSeason(tx, c.Param("season"))
+	if err != nil {
+		return dashboardScope{}, c.Error(http.StatusNotFound, fmt.Errorf("season %q not found", c.Param("season")))
+	}
+	scope := dashboardScope{Season: season}
+
+	groupID := c.Param("group")
+	if groupID == "" {
+		return scope, nil
+	}
+
+	user, ok := c.Value("current_user").(*models.User)
+	if !ok {
+		return dashboardScope{}, c.Error(http.StatusUnauthorized, fmt.Errorf("groups are private: you must be authenticated"))
+	}
+	groups, err := models.UserGroups(tx, user.ID)
+	if err != nil {
+		return dashboardScope{}, err
+	}
+	for i := range groups {
+		if groups[i].ID.String() == groupID {
+			scope.Group = &groups[i]
+			return scope, nil
+		}
+	}
+	return dashboardScope{}, c.Error(http.StatusForbidden, fmt.Errorf("not a member of group %s", groupID))
+}
+
+// getAPIV1Metric returns the metric of the "metric" param (404 for an unknown one)
+func getAPIV1Metric(c buffalo.Context) (apiV1Metric, error) {
+	metric, ok := apiV1Metrics[c.Param("metric")]
+	if !ok {
+		return apiV1Metric{}, c.Error(http.StatusNotFound, fmt.Errorf("unknown metric %q", c.Param("metric")))
+	}
+	return metric, nil
+}
+
+// APIV1ListSeasonsHandler lists every season (latest first).
+// GET /api/v1/seasons
+func APIV1ListSeasonsHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	seasons, err := models.AllSeasons(tx)
+	if err != nil {
+		return err
+	}
+
+	data := []apiV1Season{}
+	for i := range seasons {
+		data = append(data, newAPIV1Season(&seasons[i]))
+	}
+	return apiRender(c, http.StatusOK, data)
+}
+
+// APIV1ListUsersHandler lists the users (by name), paginated.
+// GET /api/v1/users
+func APIV1ListUsersHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	users := models.Users{}
+	q := apiPaginate(c, tx)
+	if err := q.Order("name asc").All(&users); err != nil {
+		return err
+	}
+
+	data := []apiV1User{}
+	for _, user := range users {
+		data = append(data, newAPIV1User(user))
+	}
+	return apiRenderPage(c, data, q.Paginator)
+}
+
+// APIV1ShowUserHandler shows a user.
+// GET /api/v1/users/{user_id}
+func APIV1ShowUserHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	user := models.User{}
+	if err := tx.Find(&user, c.Param("user_id")); err != nil {
+		return c.Error(http.StatusNotFound, fmt.Errorf("user %q not found", c.Param("user_id")))
+	}
+
+	return apiRender(c, http.StatusOK, newAPIV1User(user))
+}
+
+// APIV1ListUserActivitiesHandler lists a user's activities (latest first), paginated.
+// GET /api/v1/users/{user_id}/activities
+func APIV1ListUserActivitiesHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	user := models.User{}
+	if err := tx.Find(&user, c.Param("user_id")); err != nil {
+		return c.Error(http.StatusNotFound, fmt.Errorf("user %q not found", c.Param("user_id")))
+	}
+
+	// Private activities are only listed to their owner
+	current, _ := c.Value("current_user").(*models.User)
+	activities := models.Activities{}
+	q := apiPaginate(c, tx)
+	if err := q.Scope(models.VisibleActivities(user, current)).Order("datetime DESC").All(&activities); err != nil {
+		return err
+	}
+
+	data := []apiV1Activity{}
+	for _, activity := range activities {
+		data = append(data, newAPIV1Activity(activity))
+	}
+	return apiRenderPage(c, data, q.Paginator)
+}
+
+// APIV1SyncUserHandler enqueues a sync of a user's activities: the latest ones, or all with ?mode=all.
+// POST /api/v1/users/{user_id}/sync
+func APIV1SyncUserHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	mode := c.Param("mode")
+	switch mode {
+	case "":
+		mode = models.SyncModeLatest
+	case models.SyncModeLatest, models.SyncModeAll:
+	default:
+		return c.Error(http.StatusBadRequest, fmt.Errorf("unknown mode %q (expected %s or %s)", mode, models.SyncModeLatest, models.SyncModeAll))
+	}
+
+	user := models.User{}
+	if err := tx.Find(&user, c.Param("user_id")); err != nil {
+		return c.Error(http.StatusNotFound, fmt.Errorf("user %q not found", c.Param("user_id")))
+	}
+	if err := authorizeUser(c, &user); err != nil {
+		return err
+	}
+
+	syncJob, err := enqueueSyncJob(c, &user, mode)
+	if err != nil {
+		return err
+	}
+	return apiRender(c, http.StatusAccepted, newAPIV1SyncJob(syncJob))
+}
+
+// APIV1LeaderboardHandler ranks the users in scope by a metric (see apiV1Metrics).
+// GET /api/v1/leaderboards/{metric}
+func APIV1LeaderboardHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	metric, err := getAPIV1Metric(c)
+	if err != nil {
+		return err
+	}
+	scope, err := getAPIScope(c, tx)
+	if err != nil {
+		return err
+	}
+
+	totals := []stats.UserTotal{}
+	version, err := cachedStats(c, scope, &totals, func() error {
+		totals, err = getTotals(tx, scope, metric.metric)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	leaderboard := apiV1Leaderboard{
+		Metric:  c.Param("metric"),
+		Unit:    metric.unit,
+		Season:  newAPIV1Season(scope.Season),
+		Entries: []apiV1LeaderboardEntry{},
+	}
+	for i, total := range totals {
+		leaderboard.Entries = append(leaderboard.Entries, apiV1LeaderboardEntry{
+			Rank:     i + 1,
+			UserID:   total.UserID,
+			UserName: total.User,
+			Value:    total.Value,
+		})
+	}
+
+	return withETag(version, "json", func(c buffalo.Context) error {
+		return apiRender(c, http.StatusOK, leaderboard)
+	})(c)
+}
+
+// APIV1WeeklyHandler returns the weekly series of a metric (see apiV1Metrics) of the users in scope:
+// a value for every week of the season, cumulative with ?cumulative=true.
+// GET /api/v1/weekly/{metric}
+func APIV1WeeklyHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	metric, err := getAPIV1Metric(c)
+	if err != nil {
+		return err
+	}
+	cumulative := false
+	if value := c.Param("cumulative"); value != "" {
+		if cumulative, err = strconv.ParseBool(value); err != nil {
+			return c.Error(http.StatusBadRequest, fmt.Errorf("invalid cumulative %q (expected true or false)", value))
+		}
+	}
+	scope, err := getAPIScope(c, tx)
+	if err != nil {
+		return err
+	}
+
+	weekly := []stats.UserWeeks{}
+	version, err := cachedStats(c, scope, &weekly, func() error {
+		weekly, err = stats.New(tx).Weekly(metric.metric, scope.filter())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	weeks := scope.Season.Weeks()
+	data := apiV1Weekly{
+		Metric:     c.Param("metric"),
+		Unit:       metric.unit,
+		Cumulative: cumulative,
+		Season:     newAPIV1Season(scope.Season),
+		Series:     []apiV1UserSeries{},
+		Weeks:      weeks.Labels(),
+	}
+	for _, userWeeks := range weekly {
+		series := apiV1UserSeries{UserID: userWeeks.UserID, UserName: userWeeks.User, Values: make([]int, weeks.Count())}
+		total := 0
+		for week := 1; week <= weeks.Count(); week++ {
+			value := userWeeks.Weeks[week]
+			if cumulative {
+				total += value
+				value = total
+			}
+			series.Values[week-1] = value
+		}
+		data.Series = append(data.Series, series)
+	}
+
+	return withETag(version, "json", func(c buffalo.Context) error {
+		return apiRender(c, http.StatusOK, data)
+	})(c)
+}
+
+// APIV1StreaksHandler ranks the users in scope by their current streak (see getStreaks).
+// GET /api/v1/streaks
+func APIV1StreaksHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	scope, err := getAPIScope(c, tx)
+	if err != nil {
+		return err
+	}
+
+	streaks, err := getStreaks(tx, scope, time.Now())
+	if err != nil {
+		return err
+	}
+
+	data := []apiV1Streak{}
+	for _, streak := range streaks {
+		data = append(data, newAPIV1Streak(streak))
+	}
+	return apiRender(c, http.StatusOK, data)
+}
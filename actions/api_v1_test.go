@@ -0,0 +1,132 @@
+package actions
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tcarreira/roaw2020/models"
+)
+
+// decodeAPI decodes an API response body into value (a pointer)
+func (as *ActionSuite) decodeAPI(body string, value interface{}) {
+	as.NoError(json.Unmarshal([]byte(body), value))
+}
+
+func (as *ActionSuite) Test_APIV1_ErrorEnvelope() {
+	res := as.JSON("/api/v1/users").Get()
+	as.Equal(http.StatusUnauthorized, res.Code)
+
+	envelope := apiErrorEnvelope{}
+	as.decodeAPI(res.Body.String(), &envelope)
+	as.Equal(apiError{Status: http.StatusUnauthorized, Code: "unauthorized", Message: "you must be authenticated"}, envelope.Error)
+
+	res = as.JSON("/api/v1/leaderboards/speed").Get()
+	as.Equal(http.StatusNotFound, res.Code)
+	as.decodeAPI(res.Body.String(), &envelope)
+	as.Equal("not_found", envelope.Error.Code)
+	as.Contains(envelope.Error.Message, "speed")
+}
+
+func (as *ActionSuite) Test_APIV1_Users() {
+	user := as.createStravaUser()
+	as.NoError(as.DB.Create(&models.User{Name: "Friend", Provider: "strava", ProviderID: "43"}))
+	as.Session.Set("current_user_id", user.ID)
+
+	res := as.JSON("/api/v1/users?per_page=1").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.NotContains(res.Body.String(), "access")
+	as.NotContains(res.Body.String(), "refresh")
+
+	page := struct {
+		Data []apiV1User `json:"data"`
+		Meta apiMeta     `json:"meta"`
+	}{}
+	as.decodeAPI(res.Body.String(), &page)
+	as.Len(page.Data, 1)
+	as.Equal(user.ID, page.Data[0].ID)
+	as.Equal(apiMeta{Page: 1, PerPage: 1, TotalEntries: 2, TotalPages: 2}, page.Meta)
+
+	activity := &models.Activity{
+		UserID: user.ID, Provider: "strava", ProviderID: "1", Name: "Morning Run", Type: "Run",
+		Datetime: models.DefaultSeason().StartsOn.AddDate(0, 0, 10), Distance: 5000, MovingTime: 1500, ElapsedTime: 1800,
+	}
+	as.NoError(activity.CreateOrUpdate(models.DB))
+
+	res = as.JSON("/api/v1/users/%s/activities", user.ID).Get()
+	as.Equal(http.StatusOK, res.Code)
+	activities := struct {
+		Data []apiV1Activity `json:"data"`
+		Meta apiMeta         `json:"meta"`
+	}{}
+	as.decodeAPI(res.Body.String(), &activities)
+	as.Len(activities.Data, 1)
+	as.Equal("Morning Run", activities.Data[0].Name)
+	as.Equal(1, activities.Meta.TotalEntries)
+
+	as.Equal(http.StatusNotFound, as.JSON("/api/v1/users/%s", activity.ID).Get().Code)
+}
+
+func (as *ActionSuite) Test_APIV1_Stats() {
+	user := as.createStravaUser()
+	weeks := models.DefaultSeason().Weeks()
+	activity := &models.Activity{
+		UserID: user.ID, Provider: "strava", ProviderID: "1", Name: "Run", Type: "Run",
+		Datetime: weeks.StartsOn(2).Add(8 * time.Hour), Distance: 5000, MovingTime: 1500, ElapsedTime: 1800,
+	}
+	as.NoError(activity.CreateOrUpdate(models.DB))
+
+	res := as.JSON("/api/v1/leaderboards/distance").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.NotEmpty(res.Header().Get("ETag"))
+	leaderboard := struct {
+		Data apiV1Leaderboard `json:"data"`
+	}{}
+	as.decodeAPI(res.Body.String(), &leaderboard)
+	as.Equal("m", leaderboard.Data.Unit)
+	as.Equal([]apiV1LeaderboardEntry{{Rank: 1, UserID: user.ID.String(), UserName: user.Name, Value: 5000}}, leaderboard.Data.Entries)
+
+	res = as.JSON("/api/v1/weekly/activities?cumulative=true").Get()
+	as.Equal(http.StatusOK, res.Code)
+	weekly := struct {
+		Data apiV1Weekly `json:"data"`
+	}{}
+	as.decodeAPI(res.Body.String(), &weekly)
+	as.True(weekly.Data.Cumulative)
+	as.Len(weekly.Data.Weeks, weeks.Count())
+	as.Len(weekly.Data.Series, 1)
+	as.Len(weekly.Data.Series[0].Values, weeks.Count())
+	as.Equal([]int{0, 1, 1}, weekly.Data.Series[0].Values[:3])
+	as.Equal(1, weekly.Data.Series[0].Values[weeks.Count()-1])
+
+	as.Equal(http.StatusBadRequest, as.JSON("/api/v1/weekly/activities?cumulative=maybe").Get().Code)
+
+	res = as.JSON("/api/v1/streaks").Get()
+	as.Equal(http.StatusOK, res.Code)
+	streaks := struct {
+		Data []apiV1Streak `json:"data"`
+	}{}
+	as.decodeAPI(res.Body.String(), &streaks)
+	as.Len(streaks.Data, 1)
+	as.Equal(1, streaks.Data[0].Longest)
+	as.Equal(1, streaks.Data[0].Weeks[1].Count)
+}
+
+func (as *ActionSuite) Test_APIV1_OpenAPIDocumentsEveryRoute() {
+	res := as.JSON("/api/v1/openapi.json").Get()
+	as.Equal(http.StatusOK, res.Code)
+
+	document := struct {
+		Paths map[string]map[string]interface{} `json:"paths"`
+	}{}
+	as.decodeAPI(res.Body.String(), &document)
+
+	for _, route := range App().Routes() {
+		if !strings.HasPrefix(route.Path, "/api/v1/") {
+			continue
+		}
+		path := strings.TrimSuffix(strings.TrimPrefix(route.Path, "/api/v1"), "/")
+		as.Containsf(document.Paths[path], strings.ToLower(route.Method), "%s %s is not documented", route.Method, route.Path)
+	}
+}
@@ -9,7 +9,6 @@ import (
 	"github.com/unrolled/secure"
 
 	"github.com/gobuffalo/buffalo-pop/v2/pop/popmw"
-	csrf "github.com/gobuffalo/mw-csrf"
 	i18n "github.com/gobuffalo/mw-i18n"
 	"github.com/gobuffalo/packr/v2"
 	"github.com/tcarreira/roaw2020/models"
@@ -52,7 +51,13 @@ func App() *buffalo.App {
 
 		// Protect against CSRF attacks. https://www.owasp.org/index.php/Cross-Site_Request_Forgery_(CSRF)
 		// Remove to disable this.
-		app.Use(csrf.New)
+		// The API's requests are checked once authenticated (see CSRFUnlessAPIToken).
+		app.Use(CSRF)
+		// Strava's webhook events are not sent with a CSRF token
+		app.Middleware.Skip(CSRF, StravaWebhookEventHandler)
+
+		// Runs what must wait for the request's transaction to be committed (see onCommit)
+		app.Use(afterCommit)
 
 		// Wraps each request in a transaction.
 		//  c.Value("tx").(*pop.Connection)
@@ -65,6 +70,23 @@ func App() *buffalo.App {
 		// Setup Authorization
 		app.Use(SetCurrentUser)
 
+		// Background jobs
+		if err := app.Worker.Register(syncJobHandlerName, syncJobWorker); err != nil {
+			app.Stop(err)
+		}
+		if err := app.Worker.Register(webhookEventHandlerName, webhookEventWorker); err != nil {
+			app.Stop(err)
+		}
+		if err := listenSyncScheduler(); err != nil {
+			app.Stop(err)
+		}
+		if err := listenSyncJobsResume(); err != nil {
+			app.Stop(err)
+		}
+		if err := listenWeeklyStatsBackfill(); err != nil {
+			app.Stop(err)
+		}
+
 		// app.GET("/", HomeHandler)
 		app.GET("/", DashboardHandler)
 
@@ -75,28 +97,97 @@ func App() *buffalo.App {
 		auth.GET("/{provider}", buffalo.WrapHandlerFunc(gothic.BeginAuthHandler))
 		auth.GET("/{provider}/callback", AuthCallback)
 
+		// syncing everyone is for admins
 		activities := app.Group("/activities")
+		activities.Use(Authorize)
+		activities.Use(AdminAuthorize)
 		activities.GET("/sync-all", SyncAllActivitiesHandler)
 		activities.GET("/sync", SyncLastActivitiesHandler)
 
+		webhooks := app.Group("/webhooks")
+		webhooks.GET("/strava", StravaWebhookVerifyHandler)
+		webhooks.POST("/strava", StravaWebhookEventHandler)
+
 		users := app.Group("/users")
 		users.Use(Authorize)
 		users.GET("", ListUsersHandler)
 		users.GET("/{user_id}", ShowUsersHandler)
-		// users.DELETE("/{user_id}", DeleteUsersHandler)
+		users.DELETE("/{user_id}", DeleteUsersHandler)
 		users.GET("/{user_id}/activities", ListUserActivitiesHandler)
 		users.GET("/{user_id}/sync", SyncUserLatestActivitiesHandler)
 		users.GET("/{user_id}/sync-all", SyncUserAllActivitiesHandler)
+		users.GET("/{user_id}/tokens", ListAPITokensHandler)
+		users.POST("/{user_id}/tokens", CreateAPITokensHandler)
+		users.DELETE("/{user_id}/tokens/{api_token_id}", DeleteAPITokensHandler)
+
+		groups := app.Group("/groups")
+		groups.Use(Authorize)
+		groups.GET("", ListGroupsHandler)
+		groups.POST("", CreateGroupsHandler)
+		groups.GET("/join/{invite_code}", JoinGroupsHandler)
+		groups.GET("/{group_id}", ShowGroupsHandler)
+		groups.POST("/{group_id}/invite-code", RegenerateGroupInviteCodeHandler)
+		groups.PUT("/{group_id}/members/{user_id}", UpdateGroupMembersHandler)
+		groups.DELETE("/{group_id}/members/{user_id}", DeleteGroupMembersHandler)
+
+		syncJobs := app.Group("/sync-jobs")
+		syncJobs.Use(Authorize)
+		syncJobs.GET("", ListSyncJobsHandler)
+		syncJobs.GET("/{sync_job_id}", ShowSyncJobsHandler)
+
+		admin := app.Group("/admin")
+		admin.Use(Authorize)
+		admin.Use(AdminAuthorize)
+		admin.GET("", AdminHandler)
+		admin.GET("/users", AdminListUsersHandler)
+		admin.POST("/users/{user_id}/sync", AdminSyncUserHandler)
+		admin.PUT("/users/{user_id}", AdminUpdateUserHandler)
+		admin.DELETE("/users/{user_id}", AdminDeleteUserHandler)
+		admin.GET("/audit-logs", AdminListAuditLogsHandler)
+		admin.GET("/rate-limit", AdminRateLimitHandler)
+		admin.GET("/challenge-rules", AdminListChallengeRulesHandler)
+		admin.GET("/challenge-rules/new", AdminNewChallengeRuleHandler)
+		admin.POST("/challenge-rules", AdminCreateChallengeRuleHandler)
+		admin.GET("/challenge-rules/{challenge_rule_id}", AdminEditChallengeRuleHandler)
+		admin.POST("/challenge-rules/{challenge_rule_id}", AdminUpdateChallengeRuleHandler)
+		admin.GET("/seasons", AdminListSeasonsHandler)
+		admin.GET("/seasons/new", AdminNewSeasonHandler)
+		admin.POST("/seasons", AdminCreateSeasonHandler)
+		admin.GET("/seasons/{season_id}", AdminEditSeasonHandler)
+		admin.POST("/seasons/{season_id}", AdminUpdateSeasonHandler)
 
 		dashboard := app.Group("/dashboard")
 		dashboard.GET("", DashboardHandler)
 		dashboard.GET("/other-tops", DashboardOtherTopsHandler)
+		dashboard.GET("/streaks", DashboardStreaksHandler)
 		dashboardWeekly := dashboard.Group("/weekly")
+		dashboardWeekly.GET("/weeks", WeeklyLabelsHandler)
 		dashboardWeekly.GET("/distances", WeeklyDistanceStatsHandler)
 		dashboardWeekly.GET("/cumulative-distances", WeeklyCumulativeDistanceStatsHandler)
 		dashboardWeekly.GET("/counts", WeeklyCountStatsHandler)
 		dashboardWeekly.GET("/cumulative-counts", WeeklyCumulativeCountStatsHandler)
-		dashboardWeekly.GET("/duration", WeeklyDistanceStatsHandler)
+		dashboardWeekly.GET("/elevation", WeeklyElevationStatsHandler)
+		dashboardWeekly.GET("/cumulative-elevation", WeeklyCumulativeElevationStatsHandler)
+		dashboardWeekly.GET("/duration", WeeklyDurationStatsHandler)
+		dashboardWeekly.GET("/cumulative-duration", WeeklyCumulativeDurationStatsHandler)
+
+		// /api/v1/ endpoints: JSON only, documented on api/v1/openapi.json
+		apiV1 := app.Group(apiPath)
+		apiV1.Use(APIErrors)
+		apiV1.Use(SetCurrentUserFromAPIToken)
+		// requests authenticated by an API token (Authorization: Bearer) are not checked
+		apiV1.Use(CSRFUnlessAPIToken)
+		apiV1.GET("/openapi.json", APIOpenAPIHandler("v1"))
+		apiV1.GET("/seasons", APIV1ListSeasonsHandler)
+		apiV1.GET("/leaderboards/{metric}", APIV1LeaderboardHandler)
+		apiV1.GET("/weekly/{metric}", APIV1WeeklyHandler)
+		apiV1.GET("/streaks", APIV1StreaksHandler)
+		apiV1Users := apiV1.Group("/users")
+		apiV1Users.Use(APIAuthorize)
+		apiV1Users.GET("", APIV1ListUsersHandler)
+		apiV1Users.GET("/{user_id}", APIV1ShowUserHandler)
+		apiV1Users.GET("/{user_id}/activities", APIV1ListUserActivitiesHandler)
+		apiV1Users.POST("/{user_id}/sync", APIV1SyncUserHandler)
 
 		app.ServeFiles("/", assetsBox) // serve files from the public directory
 	}
@@ -127,3 +218,37 @@ func forceSSL() buffalo.MiddlewareFunc {
 		SSLProxyHeaders: map[string]string{"X-Forwarded-Proto": "https"},
 	})
 }
+
+// afterCommit runs the functions queued with onCommit, once the request's transaction (see popmw.Transaction)
+// is committed: when the request did not fail (no error, and a 2xx or 3xx status code)
+func afterCommit(next buffalo.Handler) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		committed := []func() error{}
+		c.Set("after_commit", &committed)
+
+		if err := next(c); err != nil {
+			return err
+		}
+		if res, ok := c.Response().(*buffalo.Response); ok && (res.Status < 200 || res.Status >= 400) {
+			return nil // rolled back
+		}
+
+		for _, fn := range committed {
+			if err := fn(); err != nil {
+				c.Logger().Error(err)
+			}
+		}
+		return nil
+	}
+}
+
+// onCommit queues fn to run once the request's transaction is committed (eg: handing a record it created to the
+// background worker, which would not find it before). Outside a request, fn runs right away
+func onCommit(c buffalo.Context, fn func() error) error {
+	committed, ok := c.Value("after_commit").(*[]func() error)
+	if !ok {
+		return fn()
+	}
+	*committed = append(*committed, fn)
+	return nil
+}
@@ -1,10 +1,14 @@
 package actions
 
 import (
+	"database/sql"
 	"fmt"
+	"net/http"
 	"os"
+	"strings"
 
 	"github.com/gobuffalo/buffalo"
+	csrf "github.com/gobuffalo/mw-csrf"
 	"github.com/gobuffalo/nulls"
 	"github.com/gobuffalo/pop/v5"
 	"github.com/markbates/going/defaults"
@@ -13,7 +17,6 @@ import (
 	"github.com/markbates/goth/providers/strava"
 	"github.com/pkg/errors"
 	"github.com/tcarreira/roaw2020/models"
-	stravaclient "github.com/tcarreira/roaw2020/strava_client"
 )
 
 func init() {
@@ -50,13 +53,27 @@ func AuthCallback(c buffalo.Context) error {
 	u.Provider = gu.Provider
 	u.ProviderID = gu.UserID
 	u.Email = nulls.NewString(gu.Email)
-	u.AccessToken = gu.AccessToken
-	u.RefreshToken = gu.RefreshToken
+	u.AccessToken = models.EncryptedString(gu.AccessToken)
+	u.RefreshToken = models.EncryptedString(gu.RefreshToken)
+	u.TokenExpiresAt = nulls.Time{}
+	if !gu.ExpiresAt.IsZero() {
+		u.TokenExpiresAt = nulls.NewTime(gu.ExpiresAt)
+	}
+	u.NeedsReauth = false
+	if u.IsBootstrapAdmin() {
+		u.Role = models.UserRoleAdmin
+	}
 	u.AvatarURL = gu.AvatarURL
 
 	if err = tx.Save(u); err != nil {
 		return errors.WithStack(err)
 	}
+	if !exists {
+		// a new user on the dashboard
+		if err = invalidateCache(c); err != nil {
+			return errors.WithStack(err)
+		}
+	}
 
 	c.Session().Set("current_user_id", u.ID)
 	if err = c.Session().Save(); err != nil {
@@ -67,7 +84,7 @@ func AuthCallback(c buffalo.Context) error {
 
 	// if first login from this user
 	if !exists {
-		if err := u.SyncActivities(tx, stravaclient.FetchAllActivities); err != nil {
+		if _, err := enqueueSyncJob(c, u, models.SyncModeAll); err != nil {
 			c.Logger().Error(err)
 		}
 	}
@@ -89,7 +106,12 @@ func SetCurrentUser(next buffalo.Handler) buffalo.Handler {
 			u := &models.User{}
 			tx := c.Value("tx").(*pop.Connection)
 			if err := tx.Find(u, uid); err != nil {
-				return errors.WithStack(err)
+				if errors.Cause(err) != sql.ErrNoRows {
+					return errors.WithStack(err)
+				}
+				// the user was deleted (eg: by an admin, or from Strava)
+				c.Session().Clear()
+				return next(c)
 			}
 			c.Set("current_user", u)
 		}
@@ -107,3 +129,93 @@ func Authorize(next buffalo.Handler) buffalo.Handler {
 		return next(c)
 	}
 }
+
+// AdminAuthorize will enforce the current user is an admin (after Authorize, or APIAuthorize)
+func AdminAuthorize(next buffalo.Handler) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		if u, ok := c.Value("current_user").(*models.User); !ok || !u.IsAdmin() {
+			return c.Error(http.StatusForbidden, errors.New("only admins can do that"))
+		}
+		return next(c)
+	}
+}
+
+// authorizeUser checks the current user can act on the user's data (their own, or an admin), or returns 403 Forbidden
+func authorizeUser(c buffalo.Context, user *models.User) error {
+	if u, ok := c.Value("current_user").(*models.User); !ok || !u.CanManage(user) {
+		return c.Error(http.StatusForbidden, fmt.Errorf("only %s (or an admin) can do that", user.Name))
+	}
+	return nil
+}
+
+// bearerToken returns the token of an "Authorization: Bearer <token>" header
+func bearerToken(r *http.Request) (string, bool) {
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) < len("Bearer ") || !strings.EqualFold(authorization[:len("Bearer ")], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(authorization[len("Bearer "):]), true
+}
+
+// csrfProtect is csrf.New as the app is built (like app.Use(csrf.New) would keep it)
+var csrfProtect = csrf.New
+
+// apiPath is where the API is served (see CSRFUnlessAPIToken)
+const apiPath = "/api/v1"
+
+// CSRF protects against CSRF attacks (see csrf.New). The API's requests are left to CSRFUnlessAPIToken,
+// once their token is validated
+func CSRF(next buffalo.Handler) buffalo.Handler {
+	protected := csrfProtect(next)
+	return func(c buffalo.Context) error {
+		if path := c.Request().URL.Path; path == apiPath || strings.HasPrefix(path, apiPath+"/") {
+			return next(c)
+		}
+		return protected(c)
+	}
+}
+
+// CSRFUnlessAPIToken protects the API against CSRF attacks, but not the requests authenticated by an API token
+// (see SetCurrentUserFromAPIToken): browsers do not send an Authorization header cross-site, so they are not forged
+func CSRFUnlessAPIToken(next buffalo.Handler) buffalo.Handler {
+	protected := csrfProtect(next)
+	return func(c buffalo.Context) error {
+		if _, ok := c.Value("api_token").(*models.APIToken); ok {
+			return next(c)
+		}
+		return protected(c)
+	}
+}
+
+// SetCurrentUserFromAPIToken will set the current_user (and api_token) on the context
+// from an "Authorization: Bearer <token>" header (see models.APIToken), instead of the session.
+// Unknown (or revoked) tokens get 401 Unauthorized, and methods not allowed by the token's scope 403 Forbidden
+func SetCurrentUserFromAPIToken(next buffalo.Handler) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		token, ok := bearerToken(c.Request())
+		if !ok {
+			return next(c)
+		}
+
+		tx := c.Value("tx").(*pop.Connection)
+		apiToken, err := models.FindAPIToken(tx, token)
+		if err != nil {
+			return c.Error(http.StatusUnauthorized, errors.New("invalid API token"))
+		}
+		if !apiToken.Allows(c.Request().Method) {
+			return c.Error(http.StatusForbidden, fmt.Errorf("the API token's scope (%s) does not allow %s requests", apiToken.Scope, c.Request().Method))
+		}
+
+		u := &models.User{}
+		if err := tx.Find(u, apiToken.UserID); err != nil {
+			return errors.WithStack(err)
+		}
+		if err := apiToken.Touch(tx); err != nil {
+			return errors.WithStack(err)
+		}
+
+		c.Set("current_user", u)
+		c.Set("api_token", apiToken)
+		return next(c)
+	}
+}
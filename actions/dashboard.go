@@ -3,155 +3,105 @@ package actions
 import (
 	"fmt"
 	"net/http"
-	"strconv"
-	"time"
 
 	"github.com/gobuffalo/buffalo"
-	"github.com/gobuffalo/envy"
+	"github.com/gobuffalo/nulls"
 	"github.com/gobuffalo/pop/v5"
 	"github.com/gobuffalo/x/responder"
+	"github.com/gofrs/uuid"
+	"github.com/tcarreira/roaw2020/models"
+	"github.com/tcarreira/roaw2020/stats"
 )
 
-type userDistanceData struct {
-	UserID   string `json:"user_id" db:"user_id"`
-	User     string `json:"user" db:"user"`
-	Distance int    `json:"distance" db:"distance"`
+// dashboardScope is what the dashboard shows: the season's activities, of everyone or of a group's members
+type dashboardScope struct {
+	Season *models.Season
+	Group  *models.Group // nil for everyone
+	User   *models.User  // nil for everyone (in the group)
 }
 
-type userActivityCount struct {
-	UserID string `json:"user_id" db:"user_id"`
-	User   string `json:"user" db:"user"`
-	Count  int    `json:"distance" db:"count"`
-}
-
-type userDuration struct {
-	UserID   string `json:"user_id" db:"user_id"`
-	User     string `json:"user" db:"user"`
-	Duration int    `json:"distance" db:"duration"`
-}
-
-func parseThisNextYear(osEnv string) (string, string) {
-
-	thisYear, err := strconv.Atoi(osEnv)
+// getDashboardScope returns the selected season and group (see getSeason and getGroup)
+func getDashboardScope(c buffalo.Context, tx *pop.Connection) (dashboardScope, error) {
+	season, err := getSeason(c, tx)
 	if err != nil {
-		App().Logger.Errorf("%s could not be parsed int int.", osEnv)
+		return dashboardScope{}, c.Error(http.StatusNotFound, err)
+	}
 
-		currentYearInt := time.Now().Year()
-		return fmt.Sprintf("%d", currentYearInt), fmt.Sprintf("%d", currentYearInt+1)
+	group, err := getGroup(c, tx)
+	if err != nil {
+		return dashboardScope{}, err
 	}
 
-	return fmt.Sprintf("%d", thisYear), fmt.Sprintf("%d", thisYear+1)
+	return dashboardScope{Season: season, Group: group}, nil
 }
 
-func getAllUsersTotalDistance(tx *pop.Connection) ([]userDistanceData, error) {
-	thisYear, nextYear := parseThisNextYear(envy.Get("ROAW_YEAR", ""))
-
-	queryString := "SELECT " +
-		"  u.id as user_id, " +
-		"  u.name as user, " +
-		"  SUM(COALESCE(a.distance,0)) as distance " +
-		"FROM users u " +
-		"  LEFT JOIN activities a ON a.user_id = u.id " +
-		"WHERE a.type IS NULL OR (a.type = 'Run' " +
-		"  AND a.datetime >= '" + thisYear + "-01-01' " +
-		"  AND a.datetime <  '" + nextYear + "-01-01' ) " +
-		"GROUP BY u.id " +
-		"ORDER BY distance DESC"
-
-	data := []userDistanceData{}
-
-	err := tx.RawQuery(queryString).All(&data)
-
-	return data, err
+// filter returns the stats filter of the scope: the season's weekly stats (of its activities valid for its ChallengeRule)
+func (scope dashboardScope) filter() stats.Filter {
+	filter := stats.Filter{Season: scope.Season}
+	if scope.Group != nil {
+		filter.GroupID = scope.Group.ID
+	}
+	if scope.User != nil {
+		filter.UserIDs = []uuid.UUID{scope.User.ID}
+	}
+	return filter
 }
 
-func getAllUsersActivityCount(tx *pop.Connection) ([]userActivityCount, error) {
-	thisYear, nextYear := parseThisNextYear(envy.Get("ROAW_YEAR", ""))
-
-	queryString := "SELECT " +
-		"  u.id as user_id, " +
-		"  u.name as user, " +
-		"  COUNT(a.distance) as count " +
-		"FROM users u " +
-		"  LEFT JOIN activities a ON a.user_id = u.id " +
-		"WHERE a.type IS NULL OR (a.type = 'Run' " +
-		"  AND a.datetime >= '" + thisYear + "-01-01' " +
-		"  AND a.datetime <  '" + nextYear + "-01-01' " +
-		"  AND a.elapsed_time >= 300 ) " +
-		"GROUP BY u.id " +
-		"ORDER BY count DESC"
-
-	data := []userActivityCount{}
-
-	err := tx.RawQuery(queryString).All(&data)
-
-	return data, err
+// getTotals returns the metric's totals of the users in scope, ranked
+func getTotals(tx *pop.Connection, scope dashboardScope, metric stats.Metric) ([]stats.UserTotal, error) {
+	return stats.New(tx).Totals(metric, scope.filter())
 }
 
-func getAllUsersTotalDuration(tx *pop.Connection) ([]userDuration, error) {
-	thisYear, nextYear := parseThisNextYear(envy.Get("ROAW_YEAR", ""))
-
-	queryString := "SELECT " +
-		"  u.id as user_id, " +
-		"  u.name as user, " +
-		"  SUM(COALESCE(a.elapsed_time,0)) as duration " +
-		"FROM users u " +
-		"  LEFT JOIN activities a ON a.user_id = u.id " +
-		"WHERE a.type IS NULL OR (a.type = 'Run' " +
-		"  AND a.datetime >= '" + thisYear + "-01-01' " +
-		"  AND a.datetime <  '" + nextYear + "-01-01' ) " +
-		"GROUP BY u.id " +
-		"ORDER BY duration DESC"
-
-	data := []userDuration{}
-
-	err := tx.RawQuery(queryString).All(&data)
-
-	return data, err
+// dashboardTotals are the dashboard's TOPs
+type dashboardTotals struct {
+	TotalDistance  []stats.UserTotal
+	ActivityCount  []stats.UserTotal
+	TotalDuration  []stats.UserTotal
+	TotalElevation []stats.UserTotal
 }
 
-func getAllUsersMostDistance(tx *pop.Connection) ([]userDistanceData, error) {
-	thisYear, nextYear := parseThisNextYear(envy.Get("ROAW_YEAR", ""))
-
-	queryString := "SELECT " +
-		"  u.id as user_id, " +
-		"  u.name as user, " +
-		"  MAX(COALESCE(a.distance,0)) as distance " +
-		"FROM users u " +
-		"  LEFT JOIN activities a ON a.user_id = u.id " +
-		"WHERE a.type IS NULL OR (a.type = 'Run' " +
-		"  AND a.datetime >= '" + thisYear + "-01-01' " +
-		"  AND a.datetime <  '" + nextYear + "-01-01' ) " +
-		"GROUP BY u.id " +
-		"ORDER BY distance DESC"
-
-	data := []userDistanceData{}
-
-	err := tx.RawQuery(queryString).All(&data)
-
-	return data, err
+// compute fills the TOPs of the users in scope
+func (totals *dashboardTotals) compute(tx *pop.Connection, scope dashboardScope) error {
+	var err error
+	for metric, data := range map[stats.Metric]*[]stats.UserTotal{
+		stats.TotalDistance:    &totals.TotalDistance,
+		stats.ActivityCount:    &totals.ActivityCount,
+		stats.TotalElapsedTime: &totals.TotalDuration,
+		stats.TotalElevation:   &totals.TotalElevation,
+	} {
+		if *data, err = getTotals(tx, scope, metric); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func getAllUsersMostDuration(tx *pop.Connection) ([]userDuration, error) {
-	thisYear, nextYear := parseThisNextYear(envy.Get("ROAW_YEAR", ""))
-
-	queryString := "SELECT " +
-		"  u.id as user_id, " +
-		"  u.name as user, " +
-		"  MAX(COALESCE(a.elapsed_time,0)) as duration " +
-		"FROM users u " +
-		"  LEFT JOIN activities a ON a.user_id = u.id " +
-		"WHERE a.type IS NULL OR (a.type = 'Run' " +
-		"  AND a.datetime >= '" + thisYear + "-01-01' " +
-		"  AND a.datetime <  '" + nextYear + "-01-01' ) " +
-		"GROUP BY u.id " +
-		"ORDER BY duration DESC"
-
-	data := []userDuration{}
+// dashboardOtherTops are the biggest activities of the users
+type dashboardOtherTops struct {
+	MostDistance []stats.UserTotal
+	MostDuration []stats.UserTotal
+}
 
-	err := tx.RawQuery(queryString).All(&data)
+// compute fills the other TOPs of the users in scope
+func (tops *dashboardOtherTops) compute(tx *pop.Connection, scope dashboardScope) error {
+	var err error
+	if tops.MostDistance, err = getTotals(tx, scope, stats.MostDistance); err != nil {
+		return err
+	}
+	tops.MostDuration, err = getTotals(tx, scope, stats.MostElapsedTime)
+	return err
+}
 
-	return data, err
+// getLastSyncedAt returns the most recent successful sync (of any user)
+func getLastSyncedAt(tx *pop.Connection) (nulls.Time, error) {
+	users := &models.Users{}
+	if err := tx.Where("last_synced_at IS NOT NULL").Order("last_synced_at desc").Limit(1).All(users); err != nil {
+		return nulls.Time{}, err
+	}
+	if len(*users) == 0 {
+		return nulls.Time{}, nil
+	}
+	return (*users)[0].LastSyncedAt, nil
 }
 
 // convertPodiumClass will take the 0-index and convert to podium HTML class name
@@ -175,41 +125,39 @@ func DashboardHandler(c buffalo.Context) error {
 		return fmt.Errorf("no transaction found")
 	}
 
-	allUsersTotalDistance, err := getAllUsersTotalDistance(tx)
+	scope, err := getDashboardScope(c, tx)
 	if err != nil {
-		c.Flash().Add("error", fmt.Sprintf("Error fetching total distance data: %v", err))
+		return err
 	}
 
-	allUsersActivityCount, err := getAllUsersActivityCount(tx)
+	totals := dashboardTotals{}
+	version, err := cachedStats(c, scope, &totals, func() error {
+		return totals.compute(tx, scope)
+	})
 	if err != nil {
-		c.Flash().Add("error", fmt.Sprintf("Error fetching totalactivity count: %v", err))
+		c.Flash().Add("error", fmt.Sprintf("Error fetching totals: %v", err))
 	}
 
-	allUsersTotalDuration, err := getAllUsersTotalDuration(tx)
+	lastSyncedAt, err := getLastSyncedAt(tx)
 	if err != nil {
-		c.Flash().Add("error", fmt.Sprintf("Error fetching total duration data: %v", err))
-	}
-
-	weeklyStats, err := getWeeklyDistanceStats(tx)
-	if err != nil {
-		c.Flash().Add("error", fmt.Sprintf("Error fetching weekly stats: %v", err))
+		c.Flash().Add("error", fmt.Sprintf("Error fetching last sync: %v", err))
 	}
 
 	return responder.Wants("html", func(c buffalo.Context) error {
 		c.Set("convertPodiumClass", convertPodiumClass)
+		c.Set("lastSyncedAt", lastSyncedAt)
 
-		c.Set("totalDistance", allUsersTotalDistance)
-		c.Set("totalCount", allUsersActivityCount)
-		c.Set("totalDuration", allUsersTotalDuration)
-
-		c.Set("weeklyStats", weeklyStats)
+		c.Set("totalDistance", totals.TotalDistance)
+		c.Set("totalCount", totals.ActivityCount)
+		c.Set("totalDuration", totals.TotalDuration)
+		c.Set("totalElevation", totals.TotalElevation)
 
 		return c.Render(http.StatusOK, r.HTML("/dashboard/index.plush.html"))
-	}).Wants("json", func(c buffalo.Context) error {
-		return c.Render(200, r.JSON(allUsersTotalDistance))
-	}).Wants("xml", func(c buffalo.Context) error {
-		return c.Render(200, r.XML(allUsersTotalDistance))
-	}).Respond(c)
+	}).Wants("json", withETag(version, "json", func(c buffalo.Context) error {
+		return c.Render(200, r.JSON(totals.TotalDistance))
+	})).Wants("xml", withETag(version, "xml", func(c buffalo.Context) error {
+		return c.Render(200, r.XML(totals.TotalDistance))
+	})).Respond(c)
 
 }
 
@@ -221,26 +169,29 @@ func DashboardOtherTopsHandler(c buffalo.Context) error {
 		return fmt.Errorf("no transaction found")
 	}
 
-	allUsersMostDistance, err := getAllUsersMostDistance(tx)
+	scope, err := getDashboardScope(c, tx)
 	if err != nil {
-		c.Flash().Add("error", fmt.Sprintf("Error fetching most distance data: %v", err))
+		return err
 	}
 
-	allUsersMostDuration, err := getAllUsersMostDuration(tx)
+	tops := dashboardOtherTops{}
+	version, err := cachedStats(c, scope, &tops, func() error {
+		return tops.compute(tx, scope)
+	})
 	if err != nil {
-		c.Flash().Add("error", fmt.Sprintf("Error fetching most duration data: %v", err))
+		c.Flash().Add("error", fmt.Sprintf("Error fetching other tops: %v", err))
 	}
 
 	return responder.Wants("html", func(c buffalo.Context) error {
 		c.Set("convertPodiumClass", convertPodiumClass)
 
-		c.Set("mostDistance", allUsersMostDistance)
-		c.Set("mostDuration", allUsersMostDuration)
+		c.Set("mostDistance", tops.MostDistance)
+		c.Set("mostDuration", tops.MostDuration)
 
 		return c.Render(http.StatusOK, r.Plain("/dashboard/other-tops.plush.html"))
-	}).Wants("json", func(c buffalo.Context) error {
-		return c.Render(200, r.JSON(allUsersMostDistance))
-	}).Wants("xml", func(c buffalo.Context) error {
-		return c.Render(200, r.XML(allUsersMostDistance))
-	}).Respond(c)
+	}).Wants("json", withETag(version, "json", func(c buffalo.Context) error {
+		return c.Render(200, r.JSON(tops.MostDistance))
+	})).Wants("xml", withETag(version, "xml", func(c buffalo.Context) error {
+		return c.Render(200, r.XML(tops.MostDistance))
+	})).Respond(c)
 }
@@ -0,0 +1,60 @@
+package actions
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/tcarreira/roaw2020/cache"
+)
+
+// cacheKey identifies the stats of the request: its endpoint and params, with the season and group resolved
+// by the scope (the group may come from the session). Every format renders the same stats, so it is not part of the key
+func (scope dashboardScope) cacheKey(c buffalo.Context) string {
+	params := c.Request().URL.Query()
+	params.Set("season", scope.Season.StatsID().String())
+	params.Del("group")
+	if scope.Group != nil {
+		params.Set("group", scope.Group.ID.String())
+	}
+	return c.Request().URL.Path + "?" + params.Encode()
+}
+
+// cachedStats loads the stats of the request into value (a pointer), from the cache or computing them.
+// It returns their version, to tag the responses (see withETag)
+func cachedStats(c buffalo.Context, scope dashboardScope, value interface{}, compute func() error) (string, error) {
+	return cache.Load(scope.cacheKey(c), value, compute)
+}
+
+// invalidateCache drops the cached stats once the request's transaction is committed (see onCommit).
+// Dropped before, a concurrent request could cache them again from the data still committed
+func invalidateCache(c buffalo.Context) error {
+	return onCommit(c, func() error {
+		cache.Invalidate()
+		return nil
+	})
+}
+
+// withETag renders a representation (eg: "json") of the stats tagged with their version:
+// a request with a matching If-None-Match gets 304 Not Modified, without a body.
+// HTML is not tagged, as pages also show the session (current user, flash messages)
+func withETag(version, format string, render buffalo.Handler) buffalo.Handler {
+	return func(c buffalo.Context) error {
+		if version == "" {
+			return render(c) // not cached (eg: on errors)
+		}
+
+		etag := fmt.Sprintf(`"%s-%s"`, version, format)
+		c.Response().Header().Set("ETag", etag)
+		c.Response().Header().Set("Cache-Control", "no-cache") // always revalidate
+
+		for _, match := range strings.Split(c.Request().Header.Get("If-None-Match"), ",") {
+			if match = strings.TrimSpace(match); match == etag || match == "*" {
+				c.Response().WriteHeader(http.StatusNotModified)
+				return nil
+			}
+		}
+		return render(c)
+	}
+}
@@ -0,0 +1,151 @@
+package actions
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/x/responder"
+	"github.com/tcarreira/roaw2020/models"
+	"github.com/tcarreira/roaw2020/stats"
+)
+
+// status of a week in a streak calendar
+const (
+	weekRan     = "ran"
+	weekMissed  = "missed"
+	weekPending = "pending" // the current week, still without activities
+	weekFuture  = "future"
+)
+
+// streakWeekClass returns the (bootstrap) badge class for a week in a streak calendar
+func streakWeekClass(status string) string {
+	switch status {
+	case weekRan:
+		return "badge-success"
+	case weekMissed:
+		return "badge-danger"
+	case weekPending:
+		return "badge-warning"
+	}
+	return "badge-light"
+}
+
+type streakWeek struct {
+	Week     int       `json:"week"`
+	StartsOn time.Time `json:"starts_on"`
+	Count    int       `json:"count"`
+	Status   string    `json:"status"`
+}
+
+// Title describes the week (eg: "Week 2 (Jan 6): 3 activities")
+func (w streakWeek) Title() string {
+	return fmt.Sprintf("Week %d (%s): %d activities", w.Week, w.StartsOn.Format("Jan 2"), w.Count)
+}
+
+// userStreak is how many weeks in a row a user did (at least) one challenge activity
+type userStreak struct {
+	UserID      string       `json:"user_id"`
+	User        string       `json:"user"`
+	Current     int          `json:"current"`
+	Longest     int          `json:"longest"`
+	MissedWeeks []int        `json:"missed_weeks"`
+	Weeks       []streakWeek `json:"weeks"`
+}
+
+// computeStreak fills the streak calendar (and current/longest streaks, and missed weeks) from the weekly counts.
+// current is the week in progress (see models.Weeks.Of), which does not break the current streak while it has no activities
+func computeStreak(counts map[int]int, weeks models.Weeks, current int) userStreak {
+	streak := userStreak{MissedWeeks: []int{}, Weeks: []streakWeek{}}
+
+	run := 0
+	for week := 1; week <= weeks.Count(); week++ {
+		count := counts[week]
+		var status string
+		switch {
+		case week > current:
+			status = weekFuture
+		case count > 0:
+			status = weekRan
+		case week == current:
+			status = weekPending
+		default:
+			status = weekMissed
+		}
+		streak.Weeks = append(streak.Weeks, streakWeek{Week: week, StartsOn: weeks.StartsOn(week), Count: count, Status: status})
+
+		switch status {
+		case weekRan:
+			run++
+			if run > streak.Longest {
+				streak.Longest = run
+			}
+			streak.Current = run
+		case weekMissed:
+			run = 0
+			streak.Current = 0
+			streak.MissedWeeks = append(streak.MissedWeeks, week)
+		}
+	}
+
+	return streak
+}
+
+// getStreaks returns the streaks of every user in scope, ranked by current streak (then by longest streak)
+func getStreaks(tx *pop.Connection, scope dashboardScope, now time.Time) ([]userStreak, error) {
+	weeks := scope.Season.Weeks()
+	weekly, err := stats.New(tx).Weekly(stats.ActivityCount, scope.filter())
+	if err != nil {
+		return nil, err
+	}
+
+	streaks := []userStreak{}
+	for _, userWeeks := range weekly {
+		streak := computeStreak(userWeeks.Weeks, weeks, weeks.Of(now))
+		streak.UserID = userWeeks.UserID
+		streak.User = userWeeks.User
+		streaks = append(streaks, streak)
+	}
+
+	sort.SliceStable(streaks, func(i, j int) bool {
+		if streaks[i].Current != streaks[j].Current {
+			return streaks[i].Current > streaks[j].Current
+		}
+		return streaks[i].Longest > streaks[j].Longest
+	})
+
+	return streaks, nil
+}
+
+// DashboardStreaksHandler returns simple html (expected to be requested by js) ranking users by current streak
+func DashboardStreaksHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	scope, err := getDashboardScope(c, tx)
+	if err != nil {
+		return err
+	}
+
+	streaks, err := getStreaks(tx, scope, time.Now())
+	if err != nil {
+		c.Flash().Add("error", fmt.Sprintf("Error fetching streaks: %v", err))
+	}
+
+	return responder.Wants("html", func(c buffalo.Context) error {
+		c.Set("convertPodiumClass", convertPodiumClass)
+		c.Set("streaks", streaks)
+
+		return c.Render(http.StatusOK, r.Plain("/dashboard/streaks.plush.html"))
+	}).Wants("json", func(c buffalo.Context) error {
+		return c.Render(200, r.JSON(streaks))
+	}).Wants("xml", func(c buffalo.Context) error {
+		return c.Render(200, r.XML(streaks))
+	}).Respond(c)
+}
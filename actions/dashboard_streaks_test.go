@@ -0,0 +1,34 @@
+package actions
+
+import (
+	"time"
+
+	"github.com/tcarreira/roaw2020/models"
+)
+
+func (as *ActionSuite) Test_computeStreak() {
+	// 10 weeks, from Monday 2020-01-06
+	weeks := models.NewWeeks(time.Date(2020, time.January, 6, 0, 0, 0, 0, time.UTC), time.Date(2020, time.March, 16, 0, 0, 0, 0, time.UTC), time.Monday)
+	counts := map[int]int{0: 3, 1: 1, 2: 2, 3: 1, 5: 1, 6: 1, 9: 1}
+
+	streak := computeStreak(counts, weeks, 7)
+	as.Equal(2, streak.Current) // the current week (7) is still pending
+	as.Equal(3, streak.Longest)
+	as.Equal([]int{4}, streak.MissedWeeks)
+	as.Len(streak.Weeks, 10)
+	as.Equal(streakWeek{Week: 4, StartsOn: time.Date(2020, time.January, 27, 0, 0, 0, 0, time.UTC), Count: 0, Status: weekMissed}, streak.Weeks[3])
+	as.Equal(weekPending, streak.Weeks[6].Status)
+	as.Equal(weekFuture, streak.Weeks[8].Status)
+	as.Equal(1, streak.Weeks[8].Count)
+
+	counts[7] = 1
+	streak = computeStreak(counts, weeks, 7)
+	as.Equal(3, streak.Current)
+	as.Equal(3, streak.Longest)
+
+	// the season is over: the last week is not pending anymore
+	streak = computeStreak(counts, weeks, 11)
+	as.Equal(0, streak.Current)
+	as.Equal(3, streak.Longest)
+	as.Equal([]int{4, 8, 10}, streak.MissedWeeks)
+}
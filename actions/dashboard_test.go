@@ -0,0 +1,130 @@
+package actions
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tcarreira/roaw2020/cache"
+	"github.com/tcarreira/roaw2020/models"
+	"github.com/tcarreira/roaw2020/stats"
+)
+
+func (as *ActionSuite) Test_Dashboard_TotalsAndWeeklySeries() {
+	flat := as.createStravaUser()
+	hills := &models.User{Name: "Hills", Provider: "strava", ProviderID: "43"}
+	as.NoError(as.DB.Create(hills))
+
+	season := &models.Season{
+		Name:     "2020",
+		StartsOn: time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC),
+		EndsOn:   time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}
+	as.NoError(models.DB.Create(season))
+
+	for i, activity := range []models.Activity{
+		{Type: "Run", Datetime: time.Date(2020, time.January, 7, 8, 0, 0, 0, time.UTC), Distance: 5000, ElapsedTime: 1800, TotalElevationGain: 120.4}, // week 2
+		{Type: "Run", Datetime: time.Date(2020, time.January, 22, 8, 0, 0, 0, time.UTC), Distance: 8000, ElapsedTime: 3000, TotalElevationGain: 80.3}, // week 4
+		{Type: "Ride", Datetime: time.Date(2020, time.January, 23, 8, 0, 0, 0, time.UTC), Distance: 40000, ElapsedTime: 5000},                         // not a run
+		{Type: "Run", Datetime: time.Date(2019, time.December, 30, 8, 0, 0, 0, time.UTC), Distance: 9000, ElapsedTime: 3000},                          // other season
+	} {
+		activity.UserID, activity.Provider, activity.ProviderID, activity.Name = hills.ID, "strava", strconv.Itoa(i), "Activity"
+		activity.MovingTime = activity.ElapsedTime
+		as.NoError(activity.CreateOrUpdate(models.DB))
+	}
+
+	scope := dashboardScope{Season: season}
+
+	totalElevation, err := getTotals(models.DB, scope, stats.TotalElevation)
+	as.NoError(err)
+	as.Equal([]stats.UserTotal{
+		{UserID: hills.ID.String(), User: "Hills", Value: 201},
+		{UserID: flat.ID.String(), User: flat.Name, Value: 0},
+	}, totalElevation)
+
+	mostDistance, err := getTotals(models.DB, scope, stats.MostDistance)
+	as.NoError(err)
+	as.Equal(8000, mostDistance[0].Value)
+
+	weekly, err := getWeeklySeries(models.DB, scope, weeklySeries{Metric: stats.TotalDistance, Divisor: 1000})
+	as.NoError(err)
+	as.Equal([]stats.Point{{Week: 1, Value: 0}, {Week: 2, Value: 5}, {Week: 3, Value: 0}, {Week: 4, Value: 8}}, weekly["Hills"])
+	as.Equal([]stats.Point{{Week: 1, Value: 0}}, weekly[flat.Name])
+
+	cumulative, err := getWeeklySeries(models.DB, scope, weeklySeries{Metric: stats.ActivityCount, Cumulative: true})
+	as.NoError(err)
+	as.Equal([]stats.Point{{Week: 1, Value: 0}, {Week: 2, Value: 1}, {Week: 3, Value: 1}, {Week: 4, Value: 2}}, cumulative["Hills"])
+	as.Equal([]stats.Point{{Week: 1, Value: 0}, {Week: 4, Value: 0}}, cumulative[flat.Name])
+
+	// a single user
+	scope.User = flat
+	totalDistance, err := getTotals(models.DB, scope, stats.TotalDistance)
+	as.NoError(err)
+	as.Len(totalDistance, 1)
+	as.Equal(flat.ID.String(), totalDistance[0].UserID)
+
+	// by date range and type: aggregated from the activities (still valid for the season's rule)
+	filter := stats.Filter{Season: season, From: time.Date(2020, time.January, 15, 0, 0, 0, 0, time.UTC), Types: []string{"Run", "Ride"}}
+	totalDistance, err = stats.New(models.DB).Totals(stats.TotalDistance, filter)
+	as.NoError(err)
+	as.Equal([]stats.UserTotal{
+		{UserID: hills.ID.String(), User: "Hills", Value: 8000},
+		{UserID: flat.ID.String(), User: flat.Name, Value: 0},
+	}, totalDistance)
+
+	filter.To = time.Date(2020, time.January, 20, 0, 0, 0, 0, time.UTC)
+	weeks, err := stats.New(models.DB).Weekly(stats.ActivityCount, filter)
+	as.NoError(err)
+	as.Len(weeks, 2)
+	for _, userWeeks := range weeks {
+		as.Empty(userWeeks.Weeks)
+	}
+
+	filter = stats.Filter{Season: season, Types: []string{"Run"}}
+	weeks, err = stats.New(models.DB).Weekly(stats.TotalDistance, filter)
+	as.NoError(err)
+	as.Equal([]stats.UserWeeks{
+		{UserID: flat.ID.String(), User: flat.Name, Weeks: map[int]int{}},
+		{UserID: hills.ID.String(), User: "Hills", Weeks: map[int]int{2: 5000, 4: 8000}},
+	}, weeks)
+}
+
+func (as *ActionSuite) Test_Dashboard_CachedWithETag() {
+	user := as.createStravaUser()
+
+	res := as.JSON("/dashboard/weekly/distances").Get()
+	as.Equal(http.StatusOK, res.Code)
+	etag := res.Header().Get("ETag")
+	as.NotEmpty(etag)
+	as.NotEqual(etag, as.XML("/dashboard/weekly/distances").Get().Header().Get("ETag"))
+
+	req := as.JSON("/dashboard/weekly/distances")
+	req.Headers["If-None-Match"] = etag
+	res = req.Get()
+	as.Equal(http.StatusNotModified, res.Code)
+	as.Empty(res.Body.String())
+
+	// a synced activity invalidates the cached stats (once committed, see syncJobWorker)
+	activity := &models.Activity{
+		UserID: user.ID, Provider: "strava", ProviderID: "1", Name: "Run", Type: "Run",
+		Datetime: models.DefaultSeason().StartsOn.AddDate(0, 0, 10), Distance: 5000, MovingTime: 1800, ElapsedTime: 1800,
+	}
+	as.NoError(activity.CreateOrUpdate(models.DB))
+	cache.Invalidate()
+
+	res = req.Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.NotEqual(etag, res.Header().Get("ETag"))
+	as.Contains(res.Body.String(), `"y":5`)
+}
+
+func (as *ActionSuite) Test_WeeklyDurationStats_UnknownDuration() {
+	res := as.JSON("/dashboard/weekly/duration?duration=fastest").Get()
+	as.Equal(http.StatusBadRequest, res.Code)
+
+	res = as.JSON("/dashboard/weekly/cumulative-duration?duration=fastest").Get()
+	as.Equal(http.StatusBadRequest, res.Code)
+
+	res = as.JSON("/dashboard/weekly/duration?duration=elapsed").Get()
+	as.Equal(http.StatusOK, res.Code)
+}
@@ -0,0 +1,158 @@
+package actions
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/x/responder"
+	"github.com/tcarreira/roaw2020/stats"
+)
+
+// durationMetrics are the selectable durations (?duration=moving|elapsed)
+var durationMetrics = map[string]stats.Metric{
+	"":        stats.TotalMovingTime,
+	"moving":  stats.TotalMovingTime,
+	"elapsed": stats.TotalElapsedTime,
+}
+
+// weeklySeries describes a weekly chart: its metric, if cumulative, and the divisor of its unit (eg: 1000 for Km)
+type weeklySeries struct {
+	Metric     stats.Metric
+	Cumulative bool
+	Divisor    int
+}
+
+// getWeeklySeries returns the weekly (or cumulative) series of the users in scope
+func getWeeklySeries(tx *pop.Connection, scope dashboardScope, series weeklySeries) (stats.WeeklySeries, error) {
+	weekly, err := stats.New(tx).Weekly(series.Metric, scope.filter())
+	if err != nil {
+		return stats.WeeklySeries{}, err
+	}
+
+	data := stats.Series(weekly)
+	if series.Cumulative {
+		data = data.Cumulative()
+	}
+	if series.Divisor > 1 {
+		data = data.Scale(series.Divisor)
+	}
+	return data, nil
+}
+
+// weeklyStatsHandler shows the weekly series by user
+func weeklyStatsHandler(c buffalo.Context, series weeklySeries) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	scope, err := getDashboardScope(c, tx)
+	if err != nil {
+		return err
+	}
+
+	weeklyStats := stats.WeeklySeries{}
+	version, err := cachedStats(c, scope, &weeklyStats, func() error {
+		weeklyStats, err = getWeeklySeries(tx, scope, series)
+		return err
+	})
+	if err != nil {
+		c.Flash().Add("error", fmt.Sprintf("Error fetching weekly stats: %v", err))
+	}
+
+	return responder.Wants("html", func(c buffalo.Context) error {
+		c.Set("weeklyStats", weeklyStats)
+
+		return c.Render(http.StatusOK, r.HTML("/dashboard/weekly-stats.plush.html"))
+	}).Wants("json", withETag(version, "json", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.JSON(weeklyStats))
+	})).Wants("xml", withETag(version, "xml", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.XML(weeklyStats))
+	})).Respond(c)
+}
+
+// WeeklyLabelsHandler returns the weeks of the season (the weekly series' x axis)
+func WeeklyLabelsHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	season, err := getSeason(c, tx)
+	if err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+
+	labels := season.Weeks().Labels()
+
+	return responder.Wants("html", func(c buffalo.Context) error {
+		c.Set("weeklyStats", labels)
+
+		return c.Render(http.StatusOK, r.HTML("/dashboard/weekly-stats.plush.html"))
+	}).Wants("json", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.JSON(labels))
+	}).Wants("xml", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.XML(labels))
+	}).Respond(c)
+}
+
+// WeeklyDistanceStatsHandler shows the weekly distance (Km) by user
+func WeeklyDistanceStatsHandler(c buffalo.Context) error {
+	return weeklyStatsHandler(c, weeklySeries{Metric: stats.TotalDistance, Divisor: 1000})
+}
+
+// WeeklyCumulativeDistanceStatsHandler shows the cumulative weekly distance (Km) by user
+func WeeklyCumulativeDistanceStatsHandler(c buffalo.Context) error {
+	return weeklyStatsHandler(c, weeklySeries{Metric: stats.TotalDistance, Cumulative: true, Divisor: 1000})
+}
+
+// WeeklyCountStatsHandler shows the weekly number of activities by user
+func WeeklyCountStatsHandler(c buffalo.Context) error {
+	return weeklyStatsHandler(c, weeklySeries{Metric: stats.ActivityCount})
+}
+
+// WeeklyCumulativeCountStatsHandler shows the cumulative weekly number of activities by user
+func WeeklyCumulativeCountStatsHandler(c buffalo.Context) error {
+	return weeklyStatsHandler(c, weeklySeries{Metric: stats.ActivityCount, Cumulative: true})
+}
+
+// WeeklyElevationStatsHandler shows the weekly elevation gain (m) by user
+func WeeklyElevationStatsHandler(c buffalo.Context) error {
+	return weeklyStatsHandler(c, weeklySeries{Metric: stats.TotalElevation})
+}
+
+// WeeklyCumulativeElevationStatsHandler shows the cumulative weekly elevation gain (m) by user
+func WeeklyCumulativeElevationStatsHandler(c buffalo.Context) error {
+	return weeklyStatsHandler(c, weeklySeries{Metric: stats.TotalElevation, Cumulative: true})
+}
+
+// durationSeries returns the duration (minutes) series: the moving time, or the elapsed time with ?duration=elapsed
+func durationSeries(c buffalo.Context, cumulative bool) (weeklySeries, error) {
+	metric, ok := durationMetrics[c.Param("duration")]
+	if !ok {
+		return weeklySeries{}, c.Error(http.StatusBadRequest, fmt.Errorf("unknown duration %q (expected moving or elapsed)", c.Param("duration")))
+	}
+	return weeklySeries{Metric: metric, Cumulative: cumulative, Divisor: 60}, nil
+}
+
+// WeeklyDurationStatsHandler shows the weekly duration (minutes) by user
+func WeeklyDurationStatsHandler(c buffalo.Context) error {
+	series, err := durationSeries(c, false)
+	if err != nil {
+		return err
+	}
+	return weeklyStatsHandler(c, series)
+}
+
+// WeeklyCumulativeDurationStatsHandler shows the cumulative weekly duration (minutes) by user
+func WeeklyCumulativeDurationStatsHandler(c buffalo.Context) error {
+	series, err := durationSeries(c, true)
+	if err != nil {
+		return err
+	}
+	return weeklyStatsHandler(c, series)
+}
@@ -0,0 +1,318 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/x/responder"
+	"github.com/gofrs/uuid"
+	"github.com/tcarreira/roaw2020/models"
+)
+
+// groupSessionKey keeps the group selected on the dashboard
+const groupSessionKey = "current_group_id"
+
+// groupAll (as the "group" param) selects everyone (no group)
+const groupAll = "all"
+
+// getGroup returns the group selected on the dashboard (nil for everyone), and makes it (and the
+// current user's groups, for the group selector) available to the templates.
+// The "group" param switches the selected group, which is kept on the session.
+func getGroup(c buffalo.Context, tx *pop.Connection) (*models.Group, error) {
+	c.Set("group", nil)
+	c.Set("groups", models.Groups{})
+
+	user, ok := c.Value("current_user").(*models.User)
+	if !ok {
+		// groups are private
+		return nil, nil
+	}
+
+	groups, err := models.UserGroups(tx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	c.Set("groups", groups)
+
+	groupID, _ := c.Session().Get(groupSessionKey).(string)
+	if values, ok := c.Request().URL.Query()["group"]; ok {
+		groupID = values[0]
+		if groupID == groupAll {
+			groupID = ""
+		}
+		c.Session().Set(groupSessionKey, groupID)
+	}
+	if groupID == "" {
+		return nil, nil
+	}
+
+	for i := range groups {
+		if groups[i].ID.String() == groupID {
+			c.Set("group", &groups[i])
+			return &groups[i], nil
+		}
+	}
+
+	// not a member (anymore)
+	c.Session().Delete(groupSessionKey)
+	return nil, c.Error(http.StatusForbidden, fmt.Errorf("not a member of group %s", groupID))
+}
+
+// currentGroupMembership finds the group (by the group_id param) and the current user's membership
+// (a not found error when not a member)
+func currentGroupMembership(c buffalo.Context, tx *pop.Connection) (*models.Group, *models.GroupMembership, error) {
+	user := c.Value("current_user").(*models.User)
+
+	group := &models.Group{}
+	if err := tx.Find(group, c.Param("group_id")); err != nil {
+		return nil, nil, c.Error(http.StatusNotFound, err)
+	}
+
+	membership, err := group.Membership(tx, user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if membership == nil {
+		return nil, nil, c.Error(http.StatusNotFound, fmt.Errorf("group %s not found", group.ID))
+	}
+
+	return group, membership, nil
+}
+
+// ListGroupsHandler lists the current user's groups
+func ListGroupsHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	groups, err := models.UserGroups(tx, c.Value("current_user").(*models.User).ID)
+	if err != nil {
+		return err
+	}
+
+	return responder.Wants("html", func(c buffalo.Context) error {
+		c.Set("groups", groups)
+		c.Set("newGroup", &models.Group{})
+		return c.Render(http.StatusOK, r.HTML("/groups/index.plush.html"))
+	}).Wants("json", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.JSON(groups))
+	}).Wants("xml", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.XML(groups))
+	}).Respond(c)
+}
+
+// CreateGroupsHandler creates a group, with the current user as its admin
+func CreateGroupsHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	group := &models.Group{}
+	if err := c.Bind(group); err != nil {
+		return err
+	}
+
+	verrs, err := models.CreateGroup(tx, group, c.Value("current_user").(*models.User))
+	if err != nil {
+		return err
+	}
+
+	if verrs.HasAny() {
+		return responder.Wants("html", func(c buffalo.Context) error {
+			c.Flash().Add("danger", verrs.Error())
+			return c.Redirect(http.StatusSeeOther, "/groups")
+		}).Wants("json", func(c buffalo.Context) error {
+			return c.Render(http.StatusUnprocessableEntity, r.JSON(verrs))
+		}).Wants("xml", func(c buffalo.Context) error {
+			return c.Render(http.StatusUnprocessableEntity, r.XML(verrs))
+		}).Respond(c)
+	}
+
+	return responder.Wants("html", func(c buffalo.Context) error {
+		c.Flash().Add("success", "Group was successfully created. Share its invite link!")
+		return c.Redirect(http.StatusSeeOther, "/groups/%v", group.ID)
+	}).Wants("json", func(c buffalo.Context) error {
+		return c.Render(http.StatusCreated, r.JSON(group))
+	}).Wants("xml", func(c buffalo.Context) error {
+		return c.Render(http.StatusCreated, r.XML(group))
+	}).Respond(c)
+}
+
+// ShowGroupsHandler shows a group's members (and the invite link, for admins)
+func ShowGroupsHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	group, membership, err := currentGroupMembership(c, tx)
+	if err != nil {
+		return err
+	}
+
+	members, err := group.Members(tx)
+	if err != nil {
+		return err
+	}
+
+	userIDs := make([]interface{}, len(members))
+	for i, member := range members {
+		userIDs[i] = member.UserID
+	}
+	users := &models.Users{}
+	if err := tx.Where("id in (?)", userIDs...).All(users); err != nil {
+		return err
+	}
+	userNames := map[string]string{}
+	for _, user := range *users {
+		userNames[user.ID.String()] = user.Name
+	}
+
+	return responder.Wants("html", func(c buffalo.Context) error {
+		c.Set("group", group)
+		c.Set("membership", membership)
+		c.Set("members", members)
+		c.Set("userName", func(member models.GroupMembership) string { return userNames[member.UserID.String()] })
+		c.Set("inviteURL", fmt.Sprintf("%s/groups/join/%s", App().Host, group.InviteCode))
+		return c.Render(http.StatusOK, r.HTML("/groups/show.plush.html"))
+	}).Wants("json", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.JSON(members))
+	}).Wants("xml", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.XML(members))
+	}).Respond(c)
+}
+
+// JoinGroupsHandler makes the current user a member of the invite code's group (and selects it on the dashboard)
+func JoinGroupsHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	group, err := models.FindGroupByInviteCode(tx, c.Param("invite_code"))
+	if err != nil {
+		c.Flash().Add("danger", "Invalid invite code")
+		return c.Redirect(http.StatusSeeOther, "/groups")
+	}
+
+	if err := group.Join(tx, c.Value("current_user").(*models.User)); err != nil {
+		return err
+	}
+	if err := invalidateCache(c); err != nil {
+		return err
+	}
+
+	c.Flash().Add("success", fmt.Sprintf("You are a member of %s", group.Name))
+	return c.Redirect(http.StatusSeeOther, "/?group=%v", group.ID)
+}
+
+// RegenerateGroupInviteCodeHandler replaces the group's invite code (admins only)
+func RegenerateGroupInviteCodeHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	group, membership, err := currentGroupMembership(c, tx)
+	if err != nil {
+		return err
+	}
+	if membership.Role != models.GroupRoleAdmin {
+		return c.Error(http.StatusForbidden, fmt.Errorf("only the group's admins can change the invite link"))
+	}
+
+	if err := group.RegenerateInviteCode(tx); err != nil {
+		return err
+	}
+
+	c.Flash().Add("success", "The previous invite link no longer works")
+	return c.Redirect(http.StatusSeeOther, "/groups/%v", group.ID)
+}
+
+// UpdateGroupMembersHandler changes a member's role (admins only)
+func UpdateGroupMembersHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	group, membership, err := currentGroupMembership(c, tx)
+	if err != nil {
+		return err
+	}
+	if membership.Role != models.GroupRoleAdmin {
+		return c.Error(http.StatusForbidden, fmt.Errorf("only the group's admins can change roles"))
+	}
+
+	member, err := group.Membership(tx, uuid.FromStringOrNil(c.Param("user_id")))
+	if err != nil {
+		return err
+	}
+	if member == nil {
+		return c.Error(http.StatusNotFound, fmt.Errorf("member %s not found", c.Param("user_id")))
+	}
+
+	return groupMemberChanged(c, group, group.SetRole(tx, member, c.Param("role")))
+}
+
+// DeleteGroupMembersHandler removes a member: admins can remove anyone, members can leave
+func DeleteGroupMembersHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	group, membership, err := currentGroupMembership(c, tx)
+	if err != nil {
+		return err
+	}
+
+	member := membership
+	if c.Param("user_id") != membership.UserID.String() {
+		if membership.Role != models.GroupRoleAdmin {
+			return c.Error(http.StatusForbidden, fmt.Errorf("only the group's admins can remove members"))
+		}
+		if member, err = group.Membership(tx, uuid.FromStringOrNil(c.Param("user_id"))); err != nil {
+			return err
+		}
+		if member == nil {
+			return c.Error(http.StatusNotFound, fmt.Errorf("member %s not found", c.Param("user_id")))
+		}
+	}
+
+	err = group.RemoveMember(tx, member)
+	if err == nil {
+		err = invalidateCache(c)
+	}
+	if err == nil && member == membership {
+		c.Flash().Add("success", fmt.Sprintf("You left %s", group.Name))
+		return c.Redirect(http.StatusSeeOther, "/groups")
+	}
+	return groupMemberChanged(c, group, err)
+}
+
+// groupMemberChanged flashes the outcome of a member's change and redirects back to the group.
+// Validation errors (eg: removing the last admin) are shown to the user.
+func groupMemberChanged(c buffalo.Context, group *models.Group, err error) error {
+	switch {
+	case errors.Is(err, models.ErrLastGroupAdmin):
+		c.Flash().Add("danger", "The group needs at least one admin")
+	case err != nil:
+		return err
+	default:
+		c.Flash().Add("success", "Group members were successfully updated")
+	}
+	return c.Redirect(http.StatusSeeOther, "/groups/%v", group.ID)
+}
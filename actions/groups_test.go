@@ -0,0 +1,108 @@
+package actions
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/tcarreira/roaw2020/cache"
+	"github.com/tcarreira/roaw2020/models"
+	"github.com/tcarreira/roaw2020/stats"
+)
+
+func (as *ActionSuite) Test_Groups_CreateJoinAndScopeDashboard() {
+	admin := as.createStravaUser()
+	member := &models.User{Name: "Friend", Provider: "strava", ProviderID: "43"}
+	as.NoError(as.DB.Create(member))
+	outsider := &models.User{Name: "Stranger", Provider: "strava", ProviderID: "44"}
+	as.NoError(as.DB.Create(outsider))
+
+	as.Session.Set("current_user_id", admin.ID)
+	res := as.HTML("/groups").Post(map[string]interface{}{"Name": "Team"})
+	as.Equal(http.StatusSeeOther, res.Code)
+
+	group := &models.Group{}
+	as.NoError(as.DB.Where("name = ?", "Team").First(group))
+	isAdmin, err := group.IsAdmin(models.DB, admin.ID)
+	as.NoError(err)
+	as.True(isAdmin)
+
+	as.Session.Set("current_user_id", member.ID)
+	res = as.HTML("/groups/join/%s", group.InviteCode).Get()
+	as.Equal(http.StatusSeeOther, res.Code)
+
+	// only the group's members are on its dashboard
+	jres := as.JSON("/?group=%s", group.ID).Get()
+	as.Equal(http.StatusOK, jres.Code)
+	totalDistance := []stats.UserTotal{}
+	as.NoError(json.Unmarshal(jres.Body.Bytes(), &totalDistance))
+	as.Len(totalDistance, 2)
+	for _, row := range totalDistance {
+		as.NotEqual(outsider.ID.String(), row.UserID)
+	}
+
+	// everyone
+	jres = as.JSON("/?group=all").Get()
+	as.NoError(json.Unmarshal(jres.Body.Bytes(), &totalDistance))
+	as.Len(totalDistance, 3)
+
+	// private: not for outsiders
+	as.Session.Set("current_user_id", outsider.ID)
+	jres = as.JSON("/?group=%s", group.ID).Get()
+	as.Equal(http.StatusForbidden, jres.Code)
+	res = as.HTML("/groups/%s", group.ID).Get()
+	as.Equal(http.StatusNotFound, res.Code)
+}
+
+func (as *ActionSuite) Test_Groups_LastAdminCanNotLeave() {
+	admin := as.createStravaUser()
+	group := &models.Group{Name: "Team"}
+	verrs, err := models.CreateGroup(models.DB, group, admin)
+	as.NoError(err)
+	as.False(verrs.HasAny())
+
+	as.Session.Set("current_user_id", admin.ID)
+	res := as.HTML("/groups/%s", group.ID).Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), group.InviteCode)
+
+	res = as.HTML("/groups/%s/members/%s", group.ID, admin.ID).Delete()
+	as.Equal(http.StatusSeeOther, res.Code)
+
+	membership, err := group.Membership(models.DB, admin.ID)
+	as.NoError(err)
+	as.NotNil(membership)
+}
+
+// invalidationStore records, on every invalidation, whether the change was already committed (see committed)
+type invalidationStore struct {
+	cache.Store
+	committed func() bool
+	seen      []bool
+}
+
+func (s *invalidationStore) Invalidate() {
+	s.seen = append(s.seen, s.committed())
+	s.Store.Invalidate()
+}
+
+func (as *ActionSuite) Test_Groups_JoinInvalidatesCacheAfterCommit() {
+	admin := as.createStravaUser()
+	group := &models.Group{Name: "Team"}
+	verrs, err := models.CreateGroup(models.DB, group, admin)
+	as.NoError(err)
+	as.False(verrs.HasAny())
+	member := &models.User{Name: "Friend", Provider: "strava", ProviderID: "43"}
+	as.NoError(as.DB.Create(member))
+
+	store := &invalidationStore{Store: cache.NewMemory(0), committed: func() bool {
+		membership, err := group.Membership(models.DB, member.ID)
+		return err == nil && membership != nil
+	}}
+	cache.Use(store)
+	defer cache.Use(cache.NewMemory(cache.DefaultTTL()))
+
+	as.Session.Set("current_user_id", member.ID)
+	res := as.HTML("/groups/join/%s", group.InviteCode).Get()
+	as.Equal(http.StatusSeeOther, res.Code)
+	as.Equal([]bool{true}, store.seen)
+}
@@ -3,11 +3,14 @@ package actions
 import (
 	"fmt"
 	"math"
+	"time"
 
 	"github.com/gobuffalo/buffalo"
 	"github.com/gobuffalo/buffalo/render"
+	"github.com/gobuffalo/nulls"
 	"github.com/gobuffalo/packr/v2"
 	"github.com/gobuffalo/plush"
+	"github.com/tcarreira/roaw2020/models"
 )
 
 var r *render.Engine
@@ -60,6 +63,50 @@ func pace(distanceMeters, durationSeconds int) string {
 	return fmt.Sprintf("%02d:%02d", min, sec)
 }
 
+// round formats a measure (eg: elevation in meters) without decimals
+func round(f float64) string {
+	return fmt.Sprintf("%.0f", f)
+}
+
+// roundNull formats an optional measure (eg: heart rate) without decimals, or "-" if missing
+func roundNull(f nulls.Float64) string {
+	if !f.Valid {
+		return "-"
+	}
+	return round(f.Float64)
+}
+
+// timeAgo converts a (nullable) time to a short human relative time (5m ago, 3h ago, 2d ago)
+func timeAgo(t nulls.Time) string {
+	if !t.Valid {
+		return "never"
+	}
+
+	elapsed := time.Since(t.Time)
+	switch {
+	case elapsed < time.Minute:
+		return "just now"
+	case elapsed < time.Hour:
+		return fmt.Sprintf("%dm ago", int(elapsed.Minutes()))
+	case elapsed < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(elapsed.Hours()))
+	}
+	return fmt.Sprintf("%dd ago", int(elapsed.Hours()/24))
+}
+
+// syncJobStatusClass converts a SyncJob status to a bootstrap color class
+func syncJobStatusClass(status string) string {
+	switch status {
+	case models.SyncJobSucceeded:
+		return "success"
+	case models.SyncJobFailed:
+		return "danger"
+	case models.SyncJobRetrying:
+		return "warning"
+	}
+	return "secondary"
+}
+
 func eq(a, b interface{}) bool {
 	return a == b
 }
@@ -75,16 +122,21 @@ func init() {
 
 		// Add template helpers here:
 		Helpers: render.Helpers{
-			"appShortName":   "ROAW",
-			"appLongName":    "Run Once a Week",
-			"appFullName":    "ROAW - Run Once a Week",
-			"isLoggedIn":     isLoggedIn,
-			"secondsToHuman": SecondsToHuman,
-			"metersToKm":     metersToKm,
-			"speed":          speed,
-			"pace":           pace,
-			"eq":             eq,
-			"host":           App().Options.Host,
+			"appShortName":       "ROAW",
+			"appLongName":        "Run Once a Week",
+			"appFullName":        "ROAW - Run Once a Week",
+			"isLoggedIn":         isLoggedIn,
+			"isAdmin":            isAdmin,
+			"secondsToHuman":     SecondsToHuman,
+			"metersToKm":         metersToKm,
+			"speed":              speed,
+			"pace":               pace,
+			"round":              round,
+			"roundNull":          roundNull,
+			"eq":                 eq,
+			"syncJobStatusClass": syncJobStatusClass,
+			"timeAgo":            timeAgo,
+			"host":               App().Options.Host,
 			// "isActive": func(name string, help plush.HelperContext) string {
 			// 	if cr, ok := help.Value("current_path").(string); ok {
 			// 		if strings.HasPrefix(cr, name) {
@@ -109,3 +161,9 @@ func isLoggedIn(help plush.HelperContext) bool {
 	}
 	return false
 }
+
+// isAdmin tells if the current user is an admin
+func isAdmin(help plush.HelperContext) bool {
+	u, ok := help.Value("current_user").(*models.User)
+	return ok && u.IsAdmin()
+}
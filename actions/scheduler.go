@@ -0,0 +1,145 @@
+package actions
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/envy"
+	"github.com/gobuffalo/events"
+	"github.com/gobuffalo/pop/v5"
+
+	"github.com/tcarreira/roaw2020/cache"
+	"github.com/tcarreira/roaw2020/models"
+	"github.com/tcarreira/roaw2020/scheduler"
+)
+
+// listenSyncScheduler starts the sync scheduler together with the background worker
+func listenSyncScheduler() error {
+	_, err := events.NamedListen("roaw:sync-scheduler", func(e events.Event) {
+		if e.Kind != buffalo.EvtWorkerStart {
+			return
+		}
+		if err := startSyncScheduler(app.Context); err != nil {
+			app.Logger.Error(err)
+		}
+	})
+	return err
+}
+
+// listenSyncJobsResume hands the pending SyncJobs back to the background worker once it starts:
+// the (in memory) worker loses them when the app restarts. Long lost jobs are failed instead (see models.ExpireSyncJobs)
+func listenSyncJobsResume() error {
+	_, err := events.NamedListen("roaw:sync-jobs-resume", func(e events.Event) {
+		if e.Kind != buffalo.EvtWorkerStart {
+			return
+		}
+		if err := resumeSyncJobs(models.DB); err != nil {
+			app.Logger.Errorf("Error resuming the sync jobs. %v", err)
+		}
+	})
+	return err
+}
+
+// resumeSyncJobs fails the expired SyncJobs and hands the other pending ones to the background worker.
+// tx must not be a transaction: the worker would not see the jobs' changes before they are committed
+func resumeSyncJobs(tx *pop.Connection) error {
+	if err := models.ExpireSyncJobs(tx); err != nil {
+		return err
+	}
+
+	jobs, err := models.PendingSyncJobs(tx)
+	if err != nil {
+		return err
+	}
+	for i := range jobs {
+		if err := performSyncJobIn(&jobs[i], 0); err != nil {
+			return err
+		}
+	}
+	if len(jobs) > 0 {
+		app.Logger.Infof("Resumed %d sync jobs", len(jobs))
+	}
+
+	return nil
+}
+
+// listenWeeklyStatsBackfill fills the weekly stats, if still empty, once the app starts (see models.BackfillWeeklyUserStats)
+func listenWeeklyStatsBackfill() error {
+	_, err := events.NamedListen("roaw:weekly-stats-backfill", func(e events.Event) {
+		if e.Kind != buffalo.EvtWorkerStart {
+			return
+		}
+		err := models.DB.Transaction(func(tx *pop.Connection) error {
+			count, err := models.BackfillWeeklyUserStats(tx)
+			if count > 0 {
+				app.Logger.Infof("Filled %d weekly stats", count)
+			}
+			return err
+		})
+		if err != nil {
+			app.Logger.Errorf("Error filling the weekly stats. %v", err)
+			return
+		}
+		cache.Invalidate()
+	})
+	return err
+}
+
+// startSyncScheduler periodically enqueues an (incremental) sync job for every user,
+// following ROAW_SYNC_SCHEDULE (eg: "@every 1h", "0 */6 * * *"). Disabled when empty.
+// Users' jobs are staggered by ROAW_SYNC_STAGGER (default 30s) to stay within Strava's rate limits.
+func startSyncScheduler(ctx context.Context) error {
+	spec := envy.Get("ROAW_SYNC_SCHEDULE", "")
+	if spec == "" {
+		return nil
+	}
+
+	schedule, err := scheduler.Parse(spec)
+	if err != nil {
+		return err
+	}
+
+	stagger, err := time.ParseDuration(envy.Get("ROAW_SYNC_STAGGER", "30s"))
+	if err != nil {
+		return fmt.Errorf("invalid ROAW_SYNC_STAGGER. %w", err)
+	}
+
+	app.Logger.Infof("Starting sync scheduler (%s)", spec)
+	go scheduler.Run(ctx, schedule, func() {
+		if err := scheduleUsersSync(models.DB, stagger); err != nil {
+			app.Logger.Errorf("Error scheduling users' sync. %v", err)
+		}
+	})
+
+	return nil
+}
+
+// scheduleUsersSync enqueues a sync job for every user (without one still pending, nor needing to log in again), stagger apart
+func scheduleUsersSync(tx *pop.Connection, stagger time.Duration) error {
+	users := &models.Users{}
+	if err := tx.Where("needs_reauth = ?", false).Order("last_synced_at asc").All(users); err != nil {
+		return err
+	}
+
+	delay := time.Duration(0)
+	for i := range *users {
+		user := &(*users)[i]
+
+		pending, err := models.HasPendingSyncJob(tx, user.ID)
+		if err != nil {
+			return err
+		}
+		if pending {
+			continue
+		}
+
+		if _, err := enqueueSyncJobIn(tx, user, models.SyncModeLatest, delay); err != nil {
+			return err
+		}
+		delay += stagger
+	}
+
+	return nil
+}
@@ -0,0 +1,32 @@
+package actions
+
+import (
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/tcarreira/roaw2020/models"
+)
+
+// getSeason returns the season selected with the "season" param (default: the current season)
+// and makes it (and every season, for the season selector) available to the templates
+func getSeason(c buffalo.Context, tx *pop.Connection) (*models.Season, error) {
+	season, err := models.FindSeason(tx, c.Param("season"))
+	if err != nil {
+		return nil, err
+	}
+
+	seasons, err := models.AllSeasons(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	seasonQuery := ""
+	if c.Param("season") != "" {
+		seasonQuery = "?season=" + season.ID.String()
+	}
+
+	c.Set("season", season)
+	c.Set("seasons", seasons)
+	c.Set("seasonQuery", seasonQuery)
+	return season, nil
+}
+
@@ -0,0 +1,212 @@
+package actions
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/buffalo/worker"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/x/responder"
+	"github.com/gofrs/uuid"
+
+	"github.com/tcarreira/roaw2020/cache"
+	"github.com/tcarreira/roaw2020/models"
+	stravaclient "github.com/tcarreira/roaw2020/strava_client"
+)
+
+// syncJobHandlerName is the worker handler performing models.SyncJob
+const syncJobHandlerName = "sync_user_activities"
+
+// syncFunctions maps a SyncJob mode to the function syncing the user's activities
+var syncFunctions = map[string]func(user *models.User, tx *pop.Connection) (models.SyncReport, error){
+	models.SyncModeLatest: (*models.User).SyncLatestActivities,
+	models.SyncModeAll:    (*models.User).SyncAllActivities,
+}
+
+// enqueueSyncJob creates a SyncJob for the user, in the request's transaction,
+// and hands it to the background worker once that transaction is committed
+func enqueueSyncJob(c buffalo.Context, user *models.User, mode string) (*models.SyncJob, error) {
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return nil, fmt.Errorf("no transaction found")
+	}
+
+	job, err := createSyncJob(tx, user, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	return job, onCommit(c, func() error { return performSyncJobIn(job, 0) })
+}
+
+// enqueueSyncJobIn creates a SyncJob for the user, to be performed by the background worker after delay.
+// tx must not be a transaction: the worker would not find the job before it is committed
+func enqueueSyncJobIn(tx *pop.Connection, user *models.User, mode string, delay time.Duration) (*models.SyncJob, error) {
+	job, err := createSyncJob(tx, user, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	return job, performSyncJobIn(job, delay)
+}
+
+// createSyncJob stores a new (pending) SyncJob for the user
+func createSyncJob(tx *pop.Connection, user *models.User, mode string) (*models.SyncJob, error) {
+	job := models.NewSyncJob(user.ID, mode)
+
+	verrs, err := tx.ValidateAndCreate(job)
+	if err != nil {
+		return nil, err
+	}
+	if verrs.HasAny() {
+		return nil, verrs
+	}
+	return job, nil
+}
+
+// performSyncJobIn hands the (stored) SyncJob to the background worker, to be performed after delay
+func performSyncJobIn(job *models.SyncJob, delay time.Duration) error {
+	return app.Worker.PerformIn(worker.Job{
+		Queue:   "default",
+		Handler: syncJobHandlerName,
+		Args:    worker.Args{"sync_job_id": job.ID.String()},
+	}, delay)
+}
+
+// syncJobWorker performs one attempt of a SyncJob, re-scheduling it (with backoff) when it should be retried
+func syncJobWorker(args worker.Args) error {
+	jobID, err := uuid.FromString(fmt.Sprintf("%v", args["sync_job_id"]))
+	if err != nil {
+		return err
+	}
+
+	job := &models.SyncJob{}
+	if err := models.DB.Find(job, jobID); err != nil {
+		return fmt.Errorf("sync job %s not found. %w", jobID, err)
+	}
+
+	if !job.IsPending() {
+		return nil
+	}
+
+	syncFunction, ok := syncFunctions[job.Mode]
+	if !ok {
+		return fmt.Errorf("unknown sync mode %s", job.Mode)
+	}
+
+	if err := job.Perform(models.DB, syncFunction); err != nil {
+		return err
+	}
+	if job.Status == models.SyncJobSucceeded {
+		cache.Invalidate() // the synced activities' stats
+	}
+
+	if job.ShouldRetry() {
+		retryIn := job.RetryIn()
+		// not before Strava's rate limit budget is back (eg: the attempt failed with stravaclient.ErrRateLimited)
+		if wait := stravaclient.DefaultTransport.Usage().Wait(time.Now()); wait > retryIn {
+			retryIn = wait
+		}
+		app.Logger.Warnf("sync job %s failed (attempt %d/%d). Retrying in %s. %s", job.ID, job.Attempts, job.MaxAttempts, retryIn, job.LastError.String)
+		return performSyncJobIn(job, retryIn)
+	}
+
+	return nil
+}
+
+// ListSyncJobsHandler gets the latest SyncJobs. This function is mapped to the path
+// GET /sync-jobs
+func ListSyncJobsHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	syncJobs := &models.SyncJobs{}
+
+	// Paginate results. Params "page" and "per_page" control pagination.
+	// Default values are "page=1" and "per_page=20".
+	q := tx.PaginateFromParams(c.Params())
+
+	if err := q.Order("created_at desc").All(syncJobs); err != nil {
+		return err
+	}
+
+	return responder.Wants("html", func(c buffalo.Context) error {
+		userNames, err := usersNames(tx, *syncJobs)
+		if err != nil {
+			return err
+		}
+
+		pending := false
+		for _, job := range *syncJobs {
+			pending = pending || job.IsPending()
+		}
+
+		c.Set("pagination", q.Paginator)
+		c.Set("syncJobs", syncJobs)
+		c.Set("userName", func(userID uuid.UUID) string { return userNames[userID] })
+		c.Set("pending", pending)
+		return c.Render(http.StatusOK, r.HTML("/sync_jobs/index.plush.html"))
+	}).Wants("json", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.JSON(syncJobs))
+	}).Wants("xml", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.XML(syncJobs))
+	}).Respond(c)
+}
+
+// ShowSyncJobsHandler gets one SyncJob (to be polled until it is finished). This function is mapped to
+// the path GET /sync-jobs/{sync_job_id}
+func ShowSyncJobsHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	syncJob := &models.SyncJob{}
+	if err := tx.Find(syncJob, c.Param("sync_job_id")); err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+
+	return responder.Wants("html", func(c buffalo.Context) error {
+		user := &models.User{}
+		if err := tx.Find(user, syncJob.UserID); err != nil {
+			return c.Error(http.StatusNotFound, err)
+		}
+
+		c.Set("syncJob", syncJob)
+		c.Set("user", user)
+		return c.Render(http.StatusOK, r.HTML("/sync_jobs/show.plush.html"))
+	}).Wants("json", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.JSON(syncJob))
+	}).Wants("xml", func(c buffalo.Context) error {
+		return c.Render(http.StatusOK, r.XML(syncJob))
+	}).Respond(c)
+}
+
+// usersNames maps the jobs' user ids to user names
+func usersNames(tx *pop.Connection, syncJobs models.SyncJobs) (map[uuid.UUID]string, error) {
+	userNames := map[uuid.UUID]string{}
+	if len(syncJobs) == 0 {
+		return userNames, nil
+	}
+
+	userIDs := make([]interface{}, len(syncJobs))
+	for i, job := range syncJobs {
+		userIDs[i] = job.UserID
+	}
+
+	users := &models.Users{}
+	if err := tx.Where("id in (?)", userIDs...).All(users); err != nil {
+		return userNames, err
+	}
+	for _, user := range *users {
+		userNames[user.ID] = user.Name
+	}
+
+	return userNames, nil
+}
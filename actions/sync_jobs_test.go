@@ -0,0 +1,79 @@
+package actions
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gobuffalo/buffalo/worker"
+	"github.com/tcarreira/roaw2020/models"
+)
+
+// recordingWorker records the jobs handed to it (instead of performing them), calling handed for each one (if set)
+type recordingWorker struct {
+	worker.Worker
+	jobs   []worker.Job
+	handed func(job worker.Job)
+}
+
+func (w *recordingWorker) Perform(job worker.Job) error {
+	return w.PerformIn(job, 0)
+}
+
+func (w *recordingWorker) PerformIn(job worker.Job, d time.Duration) error {
+	w.jobs = append(w.jobs, job)
+	if w.handed != nil {
+		w.handed(job)
+	}
+	return nil
+}
+
+// recordJobs replaces the app's worker with a recordingWorker, until the returned function is called
+func (as *ActionSuite) recordJobs() (*recordingWorker, func()) {
+	recorder := &recordingWorker{Worker: app.Worker}
+	app.Worker = recorder
+	return recorder, func() { app.Worker = recorder.Worker }
+}
+
+func (as *ActionSuite) Test_SyncJobs_EnqueuedAfterCommit() {
+	recorder, restore := as.recordJobs()
+	defer restore()
+	committed := []bool{}
+	recorder.handed = func(job worker.Job) {
+		count, err := models.DB.Where("id = ?", job.Args["sync_job_id"]).Count(&models.SyncJob{})
+		committed = append(committed, err == nil && count == 1)
+	}
+
+	user := as.createStravaUser()
+	other := &models.User{Name: "Other", Provider: "strava", ProviderID: "43"}
+	as.NoError(as.DB.Create(other))
+	as.Session.Set("current_user_id", user.ID)
+
+	res := as.JSON("/users/%s/sync", user.ID).Get()
+	as.Equal(http.StatusAccepted, res.Code)
+	as.Equal([]bool{true}, committed)
+
+	// a failed request is rolled back: nothing is enqueued
+	res = as.JSON("/users/%s/sync", other.ID).Get()
+	as.Equal(http.StatusForbidden, res.Code)
+	as.Equal([]bool{true}, committed)
+}
+
+func (as *ActionSuite) Test_SyncJobs_ResumedOnWorkerStart() {
+	recorder, restore := as.recordJobs()
+	defer restore()
+
+	user := as.createStravaUser()
+	queued := models.NewSyncJob(user.ID, models.SyncModeLatest)
+	as.NoError(as.DB.Create(queued))
+	lost := models.NewSyncJob(user.ID, models.SyncModeAll)
+	as.NoError(as.DB.Create(lost))
+	as.NoError(as.DB.RawQuery("update sync_jobs set updated_at = ? where id = ?", time.Now().Add(-24*time.Hour), lost.ID).Exec())
+
+	as.NoError(resumeSyncJobs(models.DB))
+
+	as.Len(recorder.jobs, 1)
+	as.Equal(queued.ID.String(), recorder.jobs[0].Args["sync_job_id"])
+
+	as.NoError(models.DB.Reload(lost))
+	as.Equal(models.SyncJobFailed, lost.Status)
+}
@@ -1,8 +1,10 @@
 package actions
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/gobuffalo/buffalo"
 	"github.com/gobuffalo/pop/v5"
@@ -10,7 +12,6 @@ import (
 
 	"github.com/tcarreira/roaw2020/models"
 	stravaclient "github.com/tcarreira/roaw2020/strava_client"
-	"github.com/tcarreira/roaw2020/strava_client/swagger"
 )
 
 // ListUsersHandler gets all Users. This function is mapped to the path
@@ -41,9 +42,9 @@ func ListUsersHandler(c buffalo.Context) error {
 		c.Set("users", users)
 		return c.Render(http.StatusOK, r.HTML("/users/index.plush.html"))
 	}).Wants("json", func(c buffalo.Context) error {
-		return c.Render(http.StatusOK, r.JSON(users))
+		return c.Render(http.StatusOK, r.JSON(users.Public()))
 	}).Wants("xml", func(c buffalo.Context) error {
-		return c.Render(http.StatusOK, r.XML(users))
+		return c.Render(http.StatusOK, r.XML(users.Public()))
 	}).Respond(c)
 }
 
@@ -64,21 +65,42 @@ func ShowUsersHandler(c buffalo.Context) error {
 		return c.Error(http.StatusNotFound, err)
 	}
 
-	allActivitiesStats, validActivitiesStats, err := user.GetStats(tx)
+	season, err := getSeason(c, tx)
+	if err != nil {
+		return c.Error(http.StatusNotFound, err)
+	}
+
+	allActivitiesStats, validActivitiesStats, err := user.GetStats(tx, season)
 	if err != nil {
 		c.Logger().Errorf("Error fetching user stats. %+v", err)
 	}
 
+	challengeRule, err := season.ChallengeRule(tx)
+	if err != nil {
+		return err
+	}
+
+	streak := userStreak{}
+	streaks, err := getStreaks(tx, dashboardScope{Season: season, User: user}, time.Now())
+	if err != nil {
+		c.Logger().Errorf("Error fetching user streak. %+v", err)
+	} else if len(streaks) > 0 {
+		streak = streaks[0]
+	}
+
 	return responder.Wants("html", func(c buffalo.Context) error {
 		c.Set("user", user)
+		c.Set("challengeRule", challengeRule)
+		c.Set("streak", streak)
+		c.Set("streakWeekClass", streakWeekClass)
 		c.Set("allActivitiesStats", allActivitiesStats)
 		c.Set("validActivitiesStats", validActivitiesStats)
 
 		return c.Render(http.StatusOK, r.HTML("/users/show.plush.html"))
 	}).Wants("json", func(c buffalo.Context) error {
-		return c.Render(http.StatusOK, r.JSON(user))
+		return c.Render(http.StatusOK, r.JSON(user.Public()))
 	}).Wants("xml", func(c buffalo.Context) error {
-		return c.Render(http.StatusOK, r.XML(user))
+		return c.Render(http.StatusOK, r.XML(user.Public()))
 	}).Respond(c)
 }
 
@@ -96,42 +118,85 @@ func ListUserActivitiesHandler(c buffalo.Context) error {
 		return c.Error(http.StatusNotFound, err)
 	}
 
-	activities := &models.Activities{}
+	activities := models.Activities{}
 
 	// Paginate results. Params "page" and "per_page" control pagination.
 	// Default values are "page=1" and "per_page=20".
 	q := tx.PaginateFromParams(c.Params())
 
-	// To find the User the parameter user_id is used.
-	if err := q.Where("user_id = ?", c.Param("user_id")).Order("activities.datetime DESC").All(activities); err != nil {
+	// Private activities are only listed to their owner
+	current, _ := c.Value("current_user").(*models.User)
+	if err := q.Scope(models.VisibleActivities(*user, current)).Order("activities.datetime DESC").All(&activities); err != nil {
 		c.Flash().Add("error", fmt.Sprintf("Could not fetch activities (%s)", err))
 		c.Logger().Error(err)
 		return c.Redirect(http.StatusSeeOther, "/users/"+c.Param("user_id"))
 	}
 
 	return responder.Wants("html", func(c buffalo.Context) error {
+		season, err := getSeason(c, tx)
+		if err != nil {
+			return c.Error(http.StatusNotFound, err)
+		}
+		challengeRule, err := season.ChallengeRule(tx)
+		if err != nil {
+			return err
+		}
+
 		c.Set("pagination", q.Paginator)
 		c.Set("user", user)
 		c.Set("activities", activities)
+		c.Set("challengeRule", challengeRule)
 		return c.Render(http.StatusOK, r.HTML("/users/activities.plush.html"))
 	}).Wants("json", func(c buffalo.Context) error {
-		return c.Render(http.StatusOK, r.JSON(activities))
+		return c.Render(http.StatusOK, r.JSON(activities.Public()))
 	}).Wants("xml", func(c buffalo.Context) error {
-		return c.Render(http.StatusOK, r.XML(activities))
+		return c.Render(http.StatusOK, r.XML(activities.Public()))
 	}).Respond(c)
 }
 
-// SyncUserLatestActivitiesHandler will import user's latest activities from the provider and populate the database
+// DeleteUsersHandler deletes the current user's account and all their data ("delete my data"):
+// the app's access on Strava is revoked, and the deletion recorded on the audit log (see models.User.DeleteAccount).
+// This function is mapped to the path DELETE /users/{user_id}
+func DeleteUsersHandler(c buffalo.Context) error {
+	// Get the DB connection from the context
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	user, ok := c.Value("current_user").(*models.User)
+	if !ok || user.ID.String() != c.Param("user_id") {
+		return c.Error(http.StatusForbidden, fmt.Errorf("users can only delete their own account"))
+	}
+
+	err := user.DeleteAccount(tx, user, stravaclient.Deauthorize)
+	if errors.Is(err, models.ErrLastAdmin) {
+		c.Flash().Add("danger", "You are the last admin: make someone else an admin before deleting your data")
+		return c.Redirect(http.StatusSeeOther, "/users/%s", user.ID)
+	}
+	if err != nil {
+		return err
+	}
+	if err := invalidateCache(c); err != nil {
+		return err
+	}
+
+	c.Session().Clear()
+	c.Flash().Add("success", "Your account and all your data were deleted")
+	return c.Redirect(http.StatusSeeOther, "/")
+}
+
+// SyncUserLatestActivitiesHandler will enqueue a job importing user's latest activities from the provider
 func SyncUserLatestActivitiesHandler(c buffalo.Context) error {
-	return syncUserActivitiesHandler(c, stravaclient.FetchLatestActivities)
+	return syncUserActivitiesHandler(c, models.SyncModeLatest)
 }
 
-// SyncUserAllActivitiesHandler will import user's all activities from the provider and populate the database
+// SyncUserAllActivitiesHandler will enqueue a job importing user's all activities from the provider
 func SyncUserAllActivitiesHandler(c buffalo.Context) error {
-	return syncUserActivitiesHandler(c, stravaclient.FetchAllActivities)
+	return syncUserActivitiesHandler(c, models.SyncModeAll)
 }
 
-func syncUserActivitiesHandler(c buffalo.Context, syncFunction func(stravaAccessToken string) ([]swagger.SummaryActivity, error)) error {
+func syncUserActivitiesHandler(c buffalo.Context, mode string) error {
 	// Get the DB connection from the context
 	tx, ok := c.Value("tx").(*pop.Connection)
 	if !ok {
@@ -143,14 +208,20 @@ func syncUserActivitiesHandler(c buffalo.Context, syncFunction func(stravaAccess
 	if err := tx.Find(user, c.Param("user_id")); err != nil {
 		return c.Error(http.StatusNotFound, err)
 	}
+	if err := authorizeUser(c, user); err != nil {
+		return err
+	}
 
-	if err := user.SyncActivities(tx, syncFunction); err != nil {
-		c.Logger().Error(err)
-		c.Flash().Add("warning", err.Error())
-	} else {
-		c.Flash().Add("success", "Syncronized")
+	syncJob, err := enqueueSyncJob(c, user, mode)
+	if err != nil {
+		return err
 	}
 
-	return c.Redirect(http.StatusSeeOther, "/users/"+c.Param("user_id"))
-	// return c.Render(http.StatusOK, r.String("OK"))
+	return responder.Wants("html", func(c buffalo.Context) error {
+		return c.Redirect(http.StatusSeeOther, "/sync-jobs/%v", syncJob.ID)
+	}).Wants("json", func(c buffalo.Context) error {
+		return c.Render(http.StatusAccepted, r.JSON(syncJob))
+	}).Wants("xml", func(c buffalo.Context) error {
+		return c.Render(http.StatusAccepted, r.XML(syncJob))
+	}).Respond(c)
 }
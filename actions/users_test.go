@@ -0,0 +1,161 @@
+package actions
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gobuffalo/nulls"
+	"github.com/tcarreira/roaw2020/models"
+)
+
+func (as *ActionSuite) Test_Users_NeverRenderSecrets() {
+	user := as.createStravaUser()
+	user.Email = nulls.NewString("athlete@example.com")
+	as.NoError(as.DB.Update(user))
+	as.Session.Set("current_user_id", user.ID)
+
+	bodies := []string{}
+	for _, path := range []string{"/users", "/users/" + user.ID.String()} {
+		res := as.JSON(path).Get()
+		as.Equal(http.StatusOK, res.Code)
+		bodies = append(bodies, res.Body.String())
+
+		xml := as.XML(path).Get()
+		as.Equal(http.StatusOK, xml.Code)
+		bodies = append(bodies, xml.Body.String())
+	}
+
+	for _, body := range bodies {
+		as.Contains(body, user.Name)
+		as.NotContains(body, "access")
+		as.NotContains(body, "refresh")
+		as.NotContains(body, "athlete@example.com")
+	}
+}
+
+func (as *ActionSuite) Test_Users_ShowNeedsReauth() {
+	user := as.createStravaUser()
+	as.Session.Set("current_user_id", user.ID)
+
+	res := as.HTML("/users").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.NotContains(res.Body.String(), "needs re-auth")
+
+	user.NeedsReauth = true
+	as.NoError(as.DB.Update(user))
+
+	res = as.HTML("/users").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "needs re-auth")
+}
+
+func (as *ActionSuite) Test_Users_SyncOnlyOwnData() {
+	user := as.createStravaUser()
+	other := &models.User{Name: "Other", Provider: "strava", ProviderID: "43", AccessToken: "access", RefreshToken: "refresh"}
+	as.NoError(as.DB.Create(other))
+	as.Session.Set("current_user_id", user.ID)
+
+	res := as.JSON("/users/%s/sync-all", other.ID).Get()
+	as.Equal(http.StatusForbidden, res.Code)
+	res = as.JSON("/api/v1/users/%s/sync", other.ID).Post(nil)
+	as.Equal(http.StatusForbidden, res.Code)
+	res = as.JSON("/users/%s/sync", user.ID).Get()
+	as.Equal(http.StatusAccepted, res.Code)
+
+	// admins can sync anyone
+	as.loginAdmin()
+	res = as.JSON("/users/%s/sync-all", other.ID).Get()
+	as.Equal(http.StatusAccepted, res.Code)
+}
+
+func (as *ActionSuite) Test_Users_DeleteMyData() {
+	deauthorized := []string{}
+	defer as.fakeStrava(func(w http.ResponseWriter, r *http.Request) {
+		as.Equal("/deauthorize", r.URL.Path)
+		deauthorized = append(deauthorized, r.FormValue("access_token"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "access"}`))
+	})()
+
+	user := as.createStravaUser()
+	other := &models.User{Name: "Other", Provider: "strava", ProviderID: "43", AccessToken: "access", RefreshToken: "refresh"}
+	as.NoError(as.DB.Create(other))
+	as.NoError(as.DB.Create(&models.Activity{UserID: user.ID, Provider: "strava", ProviderID: "1001", Name: "Run", Type: "Run"}))
+	as.Session.Set("current_user_id", user.ID)
+
+	// only their own
+	res := as.HTML("/users/%s", other.ID).Delete()
+	as.Equal(http.StatusForbidden, res.Code)
+
+	res = as.HTML("/users/%s", user.ID).Delete()
+	as.Equal(http.StatusSeeOther, res.Code)
+	as.Equal([]string{"access"}, deauthorized)
+
+	exists, err := as.DB.Where("id = ?", user.ID).Exists(&models.User{})
+	as.NoError(err)
+	as.False(exists)
+	count, err := as.DB.Where("user_id = ?", user.ID).Count(&models.Activity{})
+	as.NoError(err)
+	as.Equal(0, count)
+
+	audit := &models.AuditLog{}
+	as.NoError(as.DB.Where("user_id = ?", user.ID).First(audit))
+	as.Equal(models.AuditUserDeleted, audit.Action)
+	as.Equal(user.ID, audit.ActorID.UUID)
+	as.Equal("1 activities deleted, Strava access revoked", audit.Details)
+
+	// logged out
+	res = as.HTML("/users").Get()
+	as.Equal(http.StatusFound, res.Code)
+}
+
+func (as *ActionSuite) Test_Users_DeleteMyData_LastAdmin() {
+	user := as.createStravaUser()
+	as.NoError(user.SetRole(models.DB, models.UserRoleAdmin))
+	as.Session.Set("current_user_id", user.ID)
+
+	res := as.HTML("/users/%s", user.ID).Delete()
+	as.Equal(http.StatusSeeOther, res.Code)
+	as.Equal(fmt.Sprintf("/users/%s", user.ID), res.Location())
+
+	exists, err := as.DB.Where("id = ?", user.ID).Exists(&models.User{})
+	as.NoError(err)
+	as.True(exists)
+}
+
+func (as *ActionSuite) Test_Users_ActivitiesHideLocationAndPrivate() {
+	user := as.createStravaUser()
+	other := &models.User{Name: "Other", Provider: "strava", ProviderID: "43"}
+	as.NoError(as.DB.Create(other))
+
+	datetime := models.DefaultSeason().StartsOn.AddDate(0, 0, 10)
+	for _, activity := range []models.Activity{
+		{ProviderID: "1", Name: "Public Run", Private: false},
+		{ProviderID: "2", Name: "Private Run", Private: true},
+	} {
+		activity.UserID, activity.Provider, activity.Type, activity.Datetime = user.ID, "strava", "Run", datetime
+		activity.StartLat, activity.StartLng, activity.SummaryPolyline = nulls.NewFloat64(38.7), nulls.NewFloat64(-9.1), "polyline"
+		as.NoError(as.DB.Create(&activity))
+	}
+
+	// the owner sees their private activities
+	as.Session.Set("current_user_id", user.ID)
+	res := as.JSON("/users/%s/activities", user.ID).Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), "Private Run")
+
+	// the others do not
+	as.Session.Set("current_user_id", other.ID)
+	bodies := []string{
+		as.JSON("/users/%s/activities", user.ID).Get().Body.String(),
+		as.XML("/users/%s/activities", user.ID).Get().Body.String(),
+		as.HTML("/users/%s/activities", user.ID).Get().Body.String(),
+		as.JSON("/api/v1/users/%s/activities", user.ID).Get().Body.String(),
+	}
+	for _, body := range bodies {
+		as.Contains(body, "Public Run")
+		as.NotContains(body, "Private Run")
+		as.NotContains(body, "38.7")
+		as.NotContains(body, "polyline")
+	}
+}
@@ -0,0 +1,107 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gobuffalo/buffalo"
+	"github.com/gobuffalo/buffalo/worker"
+	"github.com/gobuffalo/envy"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gofrs/uuid"
+	"github.com/tcarreira/roaw2020/cache"
+	"github.com/tcarreira/roaw2020/models"
+	stravaclient "github.com/tcarreira/roaw2020/strava_client"
+)
+
+var errWebhookVerification = errors.New("invalid webhook verification request")
+var errWebhookSubscription = errors.New("unknown webhook subscription")
+
+// webhookEventHandlerName is the worker handler processing a stored models.WebhookEvent
+const webhookEventHandlerName = "process_webhook_event"
+
+// StravaWebhookVerifyHandler validates Strava's push subscription (echoes hub.challenge).
+// This function is mapped to the path GET /webhooks/strava
+func StravaWebhookVerifyHandler(c buffalo.Context) error {
+	verifyToken := envy.Get("STRAVA_WEBHOOK_VERIFY_TOKEN", "")
+
+	if c.Param("hub.mode") != "subscribe" || verifyToken == "" || c.Param("hub.verify_token") != verifyToken {
+		return c.Error(http.StatusForbidden, errWebhookVerification)
+	}
+
+	return c.Render(http.StatusOK, r.JSON(map[string]string{"hub.challenge": c.Param("hub.challenge")}))
+}
+
+// StravaWebhookEventHandler receives Strava's push events (of our subscription: STRAVA_WEBHOOK_SUBSCRIPTION_ID),
+// stores them and hands them to the background worker: Strava expects a reply within 2 seconds.
+// This function is mapped to the path POST /webhooks/strava
+func StravaWebhookEventHandler(c buffalo.Context) error {
+	body, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return c.Error(http.StatusBadRequest, err)
+	}
+
+	event, err := models.ParseStravaWebhookEvent(body)
+	if err != nil {
+		return c.Error(http.StatusBadRequest, err)
+	}
+
+	// anyone can POST here: only the events of our subscription are trusted
+	subscriptionID := envy.Get("STRAVA_WEBHOOK_SUBSCRIPTION_ID", "")
+	if subscriptionID == "" || event.SubscriptionID != subscriptionID {
+		return c.Error(http.StatusForbidden, errWebhookSubscription)
+	}
+
+	tx, ok := c.Value("tx").(*pop.Connection)
+	if !ok {
+		return fmt.Errorf("no transaction found")
+	}
+
+	if err := tx.Create(event); err != nil {
+		return err
+	}
+
+	err = onCommit(c, func() error {
+		return app.Worker.Perform(worker.Job{
+			Queue:   "default",
+			Handler: webhookEventHandlerName,
+			Args:    worker.Args{"webhook_event_id": event.ID.String()},
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Render(http.StatusOK, r.JSON(map[string]string{"id": event.ID.String()}))
+}
+
+// webhookEventWorker processes a stored WebhookEvent (unless it already was).
+// Failed events are not retried: they can be replayed later (grift webhooks:replay)
+func webhookEventWorker(args worker.Args) error {
+	eventID, err := uuid.FromString(fmt.Sprintf("%v", args["webhook_event_id"]))
+	if err != nil {
+		return err
+	}
+
+	event := &models.WebhookEvent{}
+	if err := models.DB.Find(event, eventID); err != nil {
+		return fmt.Errorf("webhook event %s not found. %w", eventID, err)
+	}
+	if event.ProcessedAt.Valid {
+		return nil
+	}
+
+	err = models.DB.Transaction(func(tx *pop.Connection) error {
+		return event.Handle(tx, stravaclient.FetchActivity)
+	})
+	if err != nil {
+		return err
+	}
+	cache.Invalidate() // the event's activity (or user) stats
+	if event.Error.Valid {
+		app.Logger.Errorf("Error processing webhook event %s. %s", event.ID, event.Error.String)
+	}
+	return nil
+}
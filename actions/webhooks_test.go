@@ -0,0 +1,188 @@
+package actions
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gobuffalo/envy"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/strava"
+	"golang.org/x/oauth2"
+
+	"github.com/tcarreira/roaw2020/models"
+)
+
+// fakeStravaProvider does not call Strava to refresh tokens
+type fakeStravaProvider struct {
+	*strava.Provider
+}
+
+func (p *fakeStravaProvider) RefreshToken(refreshToken string) (*oauth2.Token, error) {
+	return &oauth2.Token{AccessToken: "access", RefreshToken: refreshToken}, nil
+}
+
+// fakeStrava starts a local server answering like Strava's API would
+func (as *ActionSuite) fakeStrava(handler http.HandlerFunc) func() {
+	server := httptest.NewServer(handler)
+	envy.Set("STRAVA_API_URL", server.URL)
+	envy.Set("STRAVA_OAUTH_URL", server.URL)
+
+	provider := goth.GetProviders()["strava"]
+	goth.UseProviders(&fakeStravaProvider{strava.New("key", "secret", "")})
+
+	return func() {
+		server.Close()
+		envy.Set("STRAVA_API_URL", "https://www.strava.com/api/v3")
+		envy.Set("STRAVA_OAUTH_URL", "https://www.strava.com/oauth")
+		goth.UseProviders(provider)
+	}
+}
+
+// postWebhookEvent POSTs a Strava webhook event (of the subscription, "subscription_id": 1),
+// then performs the job processing it (like the background worker would)
+func (as *ActionSuite) postWebhookEvent(event map[string]interface{}) {
+	envy.Set("STRAVA_WEBHOOK_SUBSCRIPTION_ID", "1")
+	defer envy.Set("STRAVA_WEBHOOK_SUBSCRIPTION_ID", "")
+	recorder, restore := as.recordJobs()
+	defer restore()
+
+	event["subscription_id"] = 1
+	res := as.JSON("/webhooks/strava").Post(event)
+	as.Equal(http.StatusOK, res.Code)
+
+	// stored, but not processed yet
+	as.Len(recorder.jobs, 1)
+	as.Equal(webhookEventHandlerName, recorder.jobs[0].Handler)
+	stored := &models.WebhookEvent{}
+	as.NoError(as.DB.Find(stored, recorder.jobs[0].Args["webhook_event_id"]))
+	as.False(stored.ProcessedAt.Valid)
+
+	as.NoError(webhookEventWorker(recorder.jobs[0].Args))
+}
+
+func (as *ActionSuite) createStravaUser() *models.User {
+	user := &models.User{Name: "Athlete", Provider: "strava", ProviderID: "42", AccessToken: "access", RefreshToken: "refresh"}
+	as.NoError(as.DB.Create(user))
+	return user
+}
+
+func (as *ActionSuite) Test_StravaWebhookVerify() {
+	envy.Set("STRAVA_WEBHOOK_VERIFY_TOKEN", "verify-me")
+	defer envy.Set("STRAVA_WEBHOOK_VERIFY_TOKEN", "")
+
+	res := as.HTML("/webhooks/strava?hub.mode=subscribe&hub.verify_token=verify-me&hub.challenge=15f7d1a91c1f40f8a748fd134752feb3").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.Contains(res.Body.String(), `"hub.challenge":"15f7d1a91c1f40f8a748fd134752feb3"`)
+
+	res = as.HTML("/webhooks/strava?hub.mode=subscribe&hub.verify_token=wrong&hub.challenge=abc").Get()
+	as.Equal(http.StatusForbidden, res.Code)
+}
+
+func (as *ActionSuite) Test_StravaWebhookEvent_ActivityCreate() {
+	user := as.createStravaUser()
+	defer as.fakeStrava(func(w http.ResponseWriter, r *http.Request) {
+		as.Equal("/activities/1001", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": 1001, "name": "Lunch Run", "type": "Run", "distance": 8000, "moving_time": 2400, "elapsed_time": 2500, "start_date_local": "2020-06-10T12:00:00Z"}`))
+	})()
+
+	as.postWebhookEvent(map[string]interface{}{
+		"object_type": "activity", "object_id": 1001, "aspect_type": "create",
+		"owner_id": 42, "event_time": 1591790400, "updates": map[string]string{},
+	})
+
+	activity := &models.Activity{}
+	as.NoError(as.DB.Where("provider_id = ?", "1001").First(activity))
+	as.Equal(user.ID, activity.UserID)
+	as.Equal("Lunch Run", activity.Name)
+	as.Equal(8000, activity.Distance)
+
+	event := &models.WebhookEvent{}
+	as.NoError(as.DB.First(event))
+	as.True(event.ProcessedAt.Valid)
+	as.False(event.Error.Valid)
+}
+
+func (as *ActionSuite) Test_StravaWebhookEvent_ActivityDelete() {
+	user := as.createStravaUser()
+	activity := &models.Activity{
+		UserID: user.ID, Provider: "strava", ProviderID: "1001", Name: "Run", Type: "Run",
+		Datetime: models.DefaultSeason().StartsOn.AddDate(0, 0, 10), Distance: 5000, MovingTime: 1800, ElapsedTime: 1800,
+	}
+	as.NoError(activity.CreateOrUpdate(models.DB))
+	count, err := as.DB.Where("user_id = ?", user.ID).Count(&models.WeeklyUserStat{})
+	as.NoError(err)
+	as.Equal(1, count)
+	as.Contains(as.JSON("/dashboard/weekly/distances").Get().Body.String(), `"y":5`) // cached
+
+	as.postWebhookEvent(map[string]interface{}{
+		"object_type": "activity", "object_id": 1001, "aspect_type": "delete",
+		"owner_id": 42, "event_time": 1591790400, "updates": map[string]string{},
+	})
+
+	// soft deleted
+	count, err = as.DB.Where("provider_id = ? AND deleted_at IS NULL", "1001").Count(&models.Activity{})
+	as.NoError(err)
+	as.Equal(0, count)
+	count, err = as.DB.Where("provider_id = ?", "1001").Count(&models.Activity{})
+	as.NoError(err)
+	as.Equal(1, count)
+
+	// it stops counting for the stats
+	count, err = as.DB.Where("user_id = ?", user.ID).Count(&models.WeeklyUserStat{})
+	as.NoError(err)
+	as.Equal(0, count)
+	res := as.JSON("/dashboard/weekly/distances").Get()
+	as.Equal(http.StatusOK, res.Code)
+	as.NotContains(res.Body.String(), `"y":5`)
+}
+
+func (as *ActionSuite) Test_StravaWebhookEvent_Deauthorization() {
+	user := as.createStravaUser()
+	as.NoError(as.DB.Create(&models.Activity{UserID: user.ID, Provider: "strava", ProviderID: "1001", Name: "Run", Type: "Run"}))
+
+	as.postWebhookEvent(map[string]interface{}{
+		"object_type": "athlete", "object_id": 42, "aspect_type": "update",
+		"owner_id": 42, "event_time": 1591790400, "updates": map[string]string{"authorized": "false"},
+	})
+
+	count, err := as.DB.Count(&models.User{})
+	as.NoError(err)
+	as.Equal(0, count)
+	count, err = as.DB.Count(&models.Activity{})
+	as.NoError(err)
+	as.Equal(0, count)
+
+	audit := &models.AuditLog{}
+	as.NoError(as.DB.Where("user_id = ?", user.ID).First(audit))
+	as.Equal(models.AuditUserDeleted, audit.Action)
+	as.True(audit.ByStrava())
+}
+
+func (as *ActionSuite) Test_StravaWebhookEvent_OtherSubscription() {
+	as.createStravaUser()
+	recorder, restore := as.recordJobs()
+	defer restore()
+
+	deauthorization := map[string]interface{}{
+		"object_type": "athlete", "object_id": 42, "aspect_type": "update",
+		"owner_id": 42, "subscription_id": 666, "event_time": 1591790400, "updates": map[string]string{"authorized": "false"},
+	}
+
+	// no subscription configured
+	res := as.JSON("/webhooks/strava").Post(deauthorization)
+	as.Equal(http.StatusForbidden, res.Code)
+
+	envy.Set("STRAVA_WEBHOOK_SUBSCRIPTION_ID", "1")
+	defer envy.Set("STRAVA_WEBHOOK_SUBSCRIPTION_ID", "")
+	res = as.JSON("/webhooks/strava").Post(deauthorization)
+	as.Equal(http.StatusForbidden, res.Code)
+
+	as.Empty(recorder.jobs)
+	count, err := as.DB.Count(&models.WebhookEvent{})
+	as.NoError(err)
+	as.Equal(0, count)
+	count, err = as.DB.Count(&models.User{})
+	as.NoError(err)
+	as.Equal(1, count)
+}
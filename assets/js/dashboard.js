@@ -1,9 +1,20 @@
 
+// keep the selected season (eg: "?season=<id>") on every request
+function seasonQuery() {
+    return $("#nav-tabContent").data("season-query") || "";
+}
+
 $( document ).ready(function(){
 
     var colorHash = new ColorHash();
     var xlabel = [];
-    for (let i=0; i<54; i+=1){ xlabel.push(i); };
+
+    // the season's weeks (eg: "W1 Dec 30"), as the weekly series start on week 1
+    async function getWeekLabels(){
+        const response = await fetch("/dashboard/weekly/weeks" + seasonQuery(), {headers: {'Content-Type': 'application/json'}});
+        const weeks = await response.json();
+        return weeks.map(function(week) { return week.label; });
+    }
     
     var myChart;
     
@@ -26,10 +37,12 @@ $( document ).ready(function(){
             }
         }); 
         myChart.canvas.parentNode.style.height = '180px';
+        return myChart;
     }
     
+    // series are tagged (ETag): unchanged ones are revalidated (304 Not Modified) instead of fetched again
     async function getDatasets(url){
-        const response = await fetch(url, {headers: {'Content-Type': 'application/json'}});
+        const response = await fetch(url, {headers: {'Content-Type': 'application/json'}, cache: 'no-cache'});
         const userData = await response.json();
         
         var datasets = []
@@ -50,30 +63,62 @@ $( document ).ready(function(){
 
 
     async function createWeeklyDistancesChart() {
-        const datasets = await getDatasets("/dashboard/weekly/distances")
+        const datasets = await getDatasets("/dashboard/weekly/distances" + seasonQuery())
         drawChart('distance-chart', "Weekly Distance (Km)", xlabel, datasets) 
     };
 
     async function createCumulativeDistancesChart() {
-        const datasets = await getDatasets("/dashboard/weekly/cumulative-distances")
+        const datasets = await getDatasets("/dashboard/weekly/cumulative-distances" + seasonQuery())
         drawChart('cumulative-distance-chart', "Overall Distance (Km)", xlabel, datasets) 
     };
 
     async function createWeeklyCountsChart() {
-        const datasets = await getDatasets("/dashboard/weekly/counts")
+        const datasets = await getDatasets("/dashboard/weekly/counts" + seasonQuery())
         drawChart('counts-chart', "Weekly Run Activities", xlabel, datasets) 
     };
 
 
     async function createCumulativeCountsChart() {
-        const datasets = await getDatasets("/dashboard/weekly/cumulative-counts")
+        const datasets = await getDatasets("/dashboard/weekly/cumulative-counts" + seasonQuery())
         drawChart('cumulative-counts-chart', "Overall Run Activities", xlabel, datasets) 
     };
 
-    createWeeklyDistancesChart();
-    createCumulativeDistancesChart();
-    createWeeklyCountsChart();
-    createCumulativeCountsChart();
+    async function createWeeklyElevationChart() {
+        const datasets = await getDatasets("/dashboard/weekly/elevation" + seasonQuery())
+        drawChart('elevation-chart', "Weekly Elevation Gain (m)", xlabel, datasets) 
+    };
+
+    async function createCumulativeElevationChart() {
+        const datasets = await getDatasets("/dashboard/weekly/cumulative-elevation" + seasonQuery())
+        drawChart('cumulative-elevation-chart', "Overall Elevation Gain (m)", xlabel, datasets) 
+    };
+
+    var durationCharts = {};
+
+    async function createDurationCharts() {
+        const duration = $("#duration-select").val();
+        const query = (seasonQuery() ? seasonQuery() + "&" : "?") + "duration=" + duration;
+        const title = (duration == "elapsed") ? "Elapsed Time" : "Moving Time";
+
+        const weekly = await getDatasets("/dashboard/weekly/duration" + query)
+        const cumulative = await getDatasets("/dashboard/weekly/cumulative-duration" + query)
+        Object.values(durationCharts).forEach(function(chart) { chart.destroy(); });
+        durationCharts.weekly = await drawChart('duration-chart', "Weekly " + title + " (min)", xlabel, weekly)
+        durationCharts.cumulative = await drawChart('cumulative-duration-chart', "Overall " + title + " (min)", xlabel, cumulative)
+    };
+
+    $("#duration-select").on("change", createDurationCharts);
+
+    getWeekLabels().then(function(labels) {
+        xlabel = labels;
+        createWeeklyDistancesChart();
+        createCumulativeDistancesChart();
+        createWeeklyCountsChart();
+        createCumulativeCountsChart();
+        createWeeklyElevationChart();
+        createCumulativeElevationChart();
+        createDurationCharts();
+    });
 });
 
 
@@ -87,6 +132,13 @@ async function fillHtmlDiv(selector,spinnerSelector, url) {
 $("#nav-other-top-tab").on("shown.bs.tab", function (e) {
     // Fetch if div is empty
     if ($("#other-tops-content").html() == ""){
-        fillHtmlDiv("#other-tops-content", "#nav-other-top-spinner", "/dashboard/other-tops")
+        fillHtmlDiv("#other-tops-content", "#nav-other-top-spinner", "/dashboard/other-tops" + seasonQuery())
     }
-});
\ No newline at end of file
+});
+
+$("#nav-streaks-tab").on("shown.bs.tab", function (e) {
+    // Fetch if div is empty
+    if ($("#streaks-content").html() == ""){
+        fillHtmlDiv("#streaks-content", "#nav-streaks-spinner", "/dashboard/streaks" + seasonQuery())
+    }
+});
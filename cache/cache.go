@@ -0,0 +1,133 @@
+// Package cache keeps computed values (eg: the dashboard's stats) until they are invalidated (eg: by a sync).
+//
+// Values are stored encoded as JSON on a Store: Memory (in-process) by default,
+// or any other implementation (eg: Redis, shared by every instance) set with Use.
+package cache
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/gobuffalo/envy"
+)
+
+// Store keeps encoded values by key
+type Store interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Invalidate() // removes every value
+}
+
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// Memory is an in-process Store. Values expire after its TTL (zero: never)
+type Memory struct {
+	mu      sync.RWMutex
+	ttl     time.Duration
+	entries map[string]memoryEntry
+	now     func() time.Time
+}
+
+// NewMemory returns an empty Memory store
+func NewMemory(ttl time.Duration) *Memory {
+	return &Memory{ttl: ttl, entries: map[string]memoryEntry{}, now: time.Now}
+}
+
+// Get returns the (not expired) value of key
+func (m *Memory) Get(key string) ([]byte, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entry, ok := m.entries[key]
+	if !ok || !entry.expiresAt.IsZero() && !m.now().Before(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// Set stores the value of key
+func (m *Memory) Set(key string, value []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := memoryEntry{value: value}
+	if m.ttl > 0 {
+		entry.expiresAt = m.now().Add(m.ttl)
+	}
+	m.entries[key] = entry
+}
+
+// Invalidate removes every value
+func (m *Memory) Invalidate() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries = map[string]memoryEntry{}
+}
+
+// DefaultTTL returns ROAW_CACHE_TTL (default: 10m): how long a value may be cached.
+// It bounds how stale a value may get when an invalidation is missed (eg: written by another instance)
+func DefaultTTL() time.Duration {
+	ttl, err := time.ParseDuration(envy.Get("ROAW_CACHE_TTL", "10m"))
+	if err != nil {
+		return 10 * time.Minute
+	}
+	return ttl
+}
+
+var (
+	storeMu sync.RWMutex
+	store   Store = NewMemory(DefaultTTL())
+)
+
+// Use replaces the Store (eg: on the app's setup)
+func Use(s Store) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	store = s
+}
+
+func current() Store {
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+	return store
+}
+
+// Invalidate removes every cached value (eg: after new activities are written)
+func Invalidate() {
+	current().Invalidate()
+}
+
+// Version identifies the encoded value (eg: as an HTTP ETag): it changes whenever the value does
+func Version(value []byte) string {
+	sum := sha1.Sum(value)
+	return hex.EncodeToString(sum[:10])
+}
+
+// Load decodes the cached value of key into value (a pointer), or fills it with compute and caches it.
+// It returns the value's Version
+func Load(key string, value interface{}, compute func() error) (string, error) {
+	s := current()
+
+	if encoded, ok := s.Get(key); ok {
+		if err := json.Unmarshal(encoded, value); err == nil {
+			return Version(encoded), nil
+		}
+	}
+
+	if err := compute(); err != nil {
+		return "", err
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	s.Set(key, encoded)
+	return Version(encoded), nil
+}
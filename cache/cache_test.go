@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemory(t *testing.T) {
+	now := time.Date(2020, 6, 15, 10, 0, 0, 0, time.UTC)
+	m := NewMemory(time.Minute)
+	m.now = func() time.Time { return now }
+
+	if _, ok := m.Get("a"); ok {
+		t.Errorf("unexpected value on an empty store")
+	}
+
+	m.Set("a", []byte("1"))
+	if value, ok := m.Get("a"); !ok || string(value) != "1" {
+		t.Errorf("got %q (%v), expected 1", value, ok)
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := m.Get("a"); ok {
+		t.Errorf("value must expire after the TTL")
+	}
+
+	m.Set("a", []byte("2"))
+	m.Invalidate()
+	if _, ok := m.Get("a"); ok {
+		t.Errorf("value must be removed by Invalidate")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	Use(NewMemory(0))
+	defer Use(NewMemory(DefaultTTL()))
+
+	computed := 0
+	compute := func(value *[]int) func() error {
+		return func() error {
+			computed++
+			*value = []int{1, 2, computed}
+			return nil
+		}
+	}
+
+	first := []int{}
+	version, err := Load("key", &first, compute(&first))
+	if err != nil || computed != 1 {
+		t.Fatalf("expected a computed value: %v (%d)", err, computed)
+	}
+
+	cached := []int{}
+	cachedVersion, err := Load("key", &cached, compute(&cached))
+	if err != nil || computed != 1 || cachedVersion != version || len(cached) != 3 || cached[2] != 1 {
+		t.Errorf("expected the cached value: %v %v (%d, %s != %s)", cached, err, computed, cachedVersion, version)
+	}
+
+	Invalidate()
+	recomputed := []int{}
+	recomputedVersion, err := Load("key", &recomputed, compute(&recomputed))
+	if err != nil || computed != 2 || recomputedVersion == version {
+		t.Errorf("expected a new value (and version) after Invalidate: %v %v (%d)", recomputed, err, computed)
+	}
+
+	_, err = Load("failing", &recomputed, func() error { return errors.New("failed") })
+	if err == nil {
+		t.Errorf("expected compute's error")
+	}
+	if _, ok := current().Get("failing"); ok {
+		t.Errorf("errors must not be cached")
+	}
+}
@@ -0,0 +1,42 @@
+package grifts
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gobuffalo/pop/v5"
+	"github.com/markbates/grift/grift"
+	"github.com/tcarreira/roaw2020/models"
+	"github.com/tcarreira/roaw2020/secrets"
+)
+
+var _ = grift.Namespace("secrets", func() {
+
+	grift.Desc("generate-key", "Prints a new encryption key, to prepend to ROAW_ENCRYPTION_KEYS (with a comma)")
+	grift.Add("generate-key", func(c *grift.Context) error {
+		key, err := secrets.GenerateKey(time.Now().Format("20060102150405"))
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(key)
+		return nil
+	})
+
+	grift.Desc("reencrypt", "Encrypts the users' tokens with the first key of ROAW_ENCRYPTION_KEYS (eg: after adding or rotating a key)")
+	grift.Add("reencrypt", func(c *grift.Context) error {
+		count := 0
+		err := models.DB.Transaction(func(tx *pop.Connection) error {
+			var err error
+			count, err = models.ReencryptUserTokens(tx)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Re-encrypted the tokens of %d users\n", count)
+		return nil
+	})
+
+})
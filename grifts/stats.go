@@ -0,0 +1,31 @@
+package grifts
+
+import (
+	"fmt"
+
+	"github.com/gobuffalo/pop/v5"
+	"github.com/markbates/grift/grift"
+	"github.com/tcarreira/roaw2020/cache"
+	"github.com/tcarreira/roaw2020/models"
+)
+
+var _ = grift.Namespace("stats", func() {
+
+	grift.Desc("rebuild", "Rebuilds the weekly stats of every season from the activities (eg: after changing ROAW_WEEK_START)")
+	grift.Add("rebuild", func(c *grift.Context) error {
+		count := 0
+		err := models.DB.Transaction(func(tx *pop.Connection) error {
+			var err error
+			count, err = models.RebuildWeeklyUserStats(tx)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		cache.Invalidate()
+
+		fmt.Printf("Rebuilt %d weekly stats\n", count)
+		return nil
+	})
+
+})
@@ -0,0 +1,55 @@
+package grifts
+
+import (
+	"fmt"
+
+	"github.com/gobuffalo/pop/v5"
+	"github.com/markbates/grift/grift"
+	"github.com/tcarreira/roaw2020/cache"
+	"github.com/tcarreira/roaw2020/models"
+	stravaclient "github.com/tcarreira/roaw2020/strava_client"
+)
+
+var _ = grift.Namespace("webhooks", func() {
+
+	grift.Desc("replay", "Replays stored Strava webhook events (failed or unprocessed by default, or the given event ids)")
+	grift.Add("replay", func(c *grift.Context) error {
+		events := &models.WebhookEvents{}
+
+		q := models.DB.Order("event_time asc")
+		if len(c.Args) > 0 {
+			ids := make([]interface{}, len(c.Args))
+			for i, id := range c.Args {
+				ids[i] = id
+			}
+			q = q.Where("id in (?)", ids...)
+		} else {
+			q = q.Where("processed_at IS NULL OR error IS NOT NULL")
+		}
+
+		if err := q.All(events); err != nil {
+			return err
+		}
+
+		failed := 0
+		for i := range *events {
+			event := &(*events)[i]
+			err := models.DB.Transaction(func(tx *pop.Connection) error {
+				return event.Handle(tx, stravaclient.FetchActivity)
+			})
+			if err != nil {
+				return err
+			}
+			if event.Error.Valid {
+				fmt.Printf("event %s (%s/%s): %s\n", event.ID, event.ObjectType, event.AspectType, event.Error.String)
+				failed++
+			}
+		}
+
+		cache.Invalidate()
+
+		fmt.Printf("Replayed %d events (%d failed)\n", len(*events), failed)
+		return nil
+	})
+
+})
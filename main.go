@@ -4,6 +4,7 @@ import (
 	"log"
 
 	"github.com/tcarreira/roaw2020/actions"
+	"github.com/tcarreira/roaw2020/secrets"
 )
 
 // main is the starting point for your Buffalo application.
@@ -13,6 +14,11 @@ import (
 // call `app.Serve()`, unless you don't want to start your
 // application that is. :)
 func main() {
+	// eg: no ROAW_ENCRYPTION_KEYS in production (the Strava tokens would not be encrypted)
+	if err := secrets.Check(); err != nil {
+		log.Fatal(err)
+	}
+
 	app := actions.App()
 	if err := app.Serve(); err != nil {
 		log.Fatal(err)
@@ -6,11 +6,12 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/gobuffalo/nulls"
 	"github.com/gobuffalo/pop/v5"
 	"github.com/gobuffalo/validate/v3"
 	"github.com/gobuffalo/validate/v3/validators"
 	"github.com/gofrs/uuid"
-	"github.com/tcarreira/roaw2020/strava_client/swagger"
+	stravaclient "github.com/tcarreira/roaw2020/strava_client"
 )
 
 // Activity is used by pop to map your activities database table to your go code.
@@ -25,8 +26,29 @@ type Activity struct {
 	Distance    int       `json:"distance" db:"distance"`
 	MovingTime  int       `json:"moving_time" db:"moving_time"`
 	ElapsedTime int       `json:"elapsed_time" db:"elapsed_time"`
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+	Manual      bool      `json:"manual" db:"manual"`
+	Trainer     bool      `json:"trainer" db:"trainer"`
+	Commute     bool      `json:"commute" db:"commute"`
+	Private     bool      `json:"private" db:"private"`
+
+	TotalElevationGain float64       `json:"total_elevation_gain" db:"total_elevation_gain"` // meters
+	ElevHigh           nulls.Float64 `json:"elev_high" db:"elev_high"`                       // meters
+	ElevLow            nulls.Float64 `json:"elev_low" db:"elev_low"`                         // meters
+	AverageSpeed       float64       `json:"average_speed" db:"average_speed"`               // meters per second
+	MaxSpeed           float64       `json:"max_speed" db:"max_speed"`                       // meters per second
+	AverageHeartrate   nulls.Float64 `json:"average_heartrate" db:"average_heartrate"`       // beats per minute
+	MaxHeartrate       nulls.Float64 `json:"max_heartrate" db:"max_heartrate"`               // beats per minute
+	StartLat           nulls.Float64 `json:"start_lat" db:"start_lat"`
+	StartLng           nulls.Float64 `json:"start_lng" db:"start_lng"`
+	Timezone           string        `json:"timezone" db:"timezone"`
+	GearID             string        `json:"gear_id" db:"gear_id"`
+	WorkoutType        int           `json:"workout_type" db:"workout_type"`
+	KudosCount         int           `json:"kudos_count" db:"kudos_count"`
+	SummaryPolyline    string        `json:"summary_polyline" db:"summary_polyline"`
+
+	DeletedAt nulls.Time `json:"deleted_at" db:"deleted_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at" db:"updated_at"`
 }
 
 // String is not required by pop and may be deleted
@@ -44,6 +66,92 @@ func (a Activities) String() string {
 	return string(ja)
 }
 
+// PublicActivity is what may be shown of an Activity to other users, in any format: never where the athlete was
+// (start coordinates nor route)
+type PublicActivity struct {
+	ID                 uuid.UUID     `json:"id" xml:"id"`
+	UserID             uuid.UUID     `json:"user_id" xml:"user_id"`
+	Provider           string        `json:"provider" xml:"provider"`
+	ProviderID         string        `json:"provider_id" xml:"provider_id"`
+	Name               string        `json:"name" xml:"name"`
+	Type               string        `json:"type" xml:"type"`
+	Datetime           time.Time     `json:"datetime" xml:"datetime"`
+	Timezone           string        `json:"timezone" xml:"timezone"`
+	Distance           int           `json:"distance" xml:"distance"`
+	MovingTime         int           `json:"moving_time" xml:"moving_time"`
+	ElapsedTime        int           `json:"elapsed_time" xml:"elapsed_time"`
+	Manual             bool          `json:"manual" xml:"manual"`
+	Trainer            bool          `json:"trainer" xml:"trainer"`
+	Commute            bool          `json:"commute" xml:"commute"`
+	Private            bool          `json:"private" xml:"private"`
+	TotalElevationGain float64       `json:"total_elevation_gain" xml:"total_elevation_gain"`
+	ElevHigh           nulls.Float64 `json:"elev_high" xml:"elev_high"`
+	ElevLow            nulls.Float64 `json:"elev_low" xml:"elev_low"`
+	AverageSpeed       float64       `json:"average_speed" xml:"average_speed"`
+	MaxSpeed           float64       `json:"max_speed" xml:"max_speed"`
+	AverageHeartrate   nulls.Float64 `json:"average_heartrate" xml:"average_heartrate"`
+	MaxHeartrate       nulls.Float64 `json:"max_heartrate" xml:"max_heartrate"`
+	GearID             string        `json:"gear_id" xml:"gear_id"`
+	WorkoutType        int           `json:"workout_type" xml:"workout_type"`
+	KudosCount         int           `json:"kudos_count" xml:"kudos_count"`
+	CreatedAt          time.Time     `json:"created_at" xml:"created_at"`
+	UpdatedAt          time.Time     `json:"updated_at" xml:"updated_at"`
+}
+
+// Public returns the activity's PublicActivity projection
+func (a Activity) Public() PublicActivity {
+	return PublicActivity{
+		ID:                 a.ID,
+		UserID:             a.UserID,
+		Provider:           a.Provider,
+		ProviderID:         a.ProviderID,
+		Name:               a.Name,
+		Type:               a.Type,
+		Datetime:           a.Datetime,
+		Timezone:           a.Timezone,
+		Distance:           a.Distance,
+		MovingTime:         a.MovingTime,
+		ElapsedTime:        a.ElapsedTime,
+		Manual:             a.Manual,
+		Trainer:            a.Trainer,
+		Commute:            a.Commute,
+		Private:            a.Private,
+		TotalElevationGain: a.TotalElevationGain,
+		ElevHigh:           a.ElevHigh,
+		ElevLow:            a.ElevLow,
+		AverageSpeed:       a.AverageSpeed,
+		MaxSpeed:           a.MaxSpeed,
+		AverageHeartrate:   a.AverageHeartrate,
+		MaxHeartrate:       a.MaxHeartrate,
+		GearID:             a.GearID,
+		WorkoutType:        a.WorkoutType,
+		KudosCount:         a.KudosCount,
+		CreatedAt:          a.CreatedAt,
+		UpdatedAt:          a.UpdatedAt,
+	}
+}
+
+// Public returns the activities' PublicActivity projections
+func (a Activities) Public() []PublicActivity {
+	public := make([]PublicActivity, 0, len(a))
+	for _, activity := range a {
+		public = append(public, activity.Public())
+	}
+	return public
+}
+
+// VisibleActivities scopes a query to the owner's (not deleted) activities that the viewer (nil: anonymous) may see:
+// the ones private on Strava only to the owner
+func VisibleActivities(owner User, viewer *User) pop.ScopeFunc {
+	return func(q *pop.Query) *pop.Query {
+		q = q.Where("activities.user_id = ? AND activities.deleted_at IS NULL", owner.ID)
+		if viewer == nil || viewer.ID != owner.ID {
+			q = q.Where("activities.private = ?", false)
+		}
+		return q
+	}
+}
+
 // Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
 // This method is not required and may be deleted.
 func (a *Activity) Validate(tx *pop.Connection) (*validate.Errors, error) {
@@ -72,20 +180,37 @@ func (a *Activity) ValidateUpdate(tx *pop.Connection) (*validate.Errors, error)
 }
 
 // CreateOrUpdate will create or update the activity
-// (based on (provider,provider_id) key)
+// (based on (provider,provider_id) key). A soft deleted activity is restored.
+// The weekly stats of its week (and of its previous week, when it moved) are refreshed
 func (a *Activity) CreateOrUpdate(tx *pop.Connection) error {
 	tmpActivity := &Activity{}
 
 	q := tx.Where("provider = ?", a.Provider).Where("provider_id = ?", a.ProviderID)
 	q.First(tmpActivity)
 
-	if IsSameActivity(a, tmpActivity) {
+	if IsSameActivity(a, tmpActivity) && !tmpActivity.DeletedAt.Valid {
 		return nil
 	}
 
 	a.ID = tmpActivity.ID
-	err := tx.Save(a)
-	return err
+	if err := tx.Save(a); err != nil {
+		return err
+	}
+
+	datetimes := []time.Time{a.Datetime}
+	if tmpActivity.ID != uuid.Nil {
+		datetimes = append(datetimes, tmpActivity.Datetime)
+	}
+	return RefreshWeeklyUserStats(tx, a.UserID, datetimes...)
+}
+
+// SoftDelete marks the activity as deleted (it stops counting for stats)
+func (a *Activity) SoftDelete(tx *pop.Connection) error {
+	a.DeletedAt = nulls.NewTime(time.Now())
+	if err := tx.UpdateColumns(a, "deleted_at", "updated_at"); err != nil {
+		return err
+	}
+	return RefreshWeeklyUserStats(tx, a.UserID, a.Datetime)
 }
 
 // IsSameActivity returns true when activities' relevant fields are equal
@@ -97,13 +222,39 @@ func IsSameActivity(a1, a2 *Activity) bool {
 		a1.Datetime.Unix() == a2.Datetime.Unix() &&
 		a1.Distance == a2.Distance &&
 		a1.MovingTime == a2.MovingTime &&
-		a1.ElapsedTime == a2.ElapsedTime
+		a1.ElapsedTime == a2.ElapsedTime &&
+		a1.Manual == a2.Manual &&
+		a1.Trainer == a2.Trainer &&
+		a1.Commute == a2.Commute &&
+		a1.Private == a2.Private &&
+		a1.TotalElevationGain == a2.TotalElevationGain &&
+		a1.ElevHigh == a2.ElevHigh &&
+		a1.ElevLow == a2.ElevLow &&
+		a1.AverageSpeed == a2.AverageSpeed &&
+		a1.MaxSpeed == a2.MaxSpeed &&
+		a1.AverageHeartrate == a2.AverageHeartrate &&
+		a1.MaxHeartrate == a2.MaxHeartrate &&
+		a1.StartLat == a2.StartLat &&
+		a1.StartLng == a2.StartLng &&
+		a1.Timezone == a2.Timezone &&
+		a1.GearID == a2.GearID &&
+		a1.WorkoutType == a2.WorkoutType &&
+		a1.KudosCount == a2.KudosCount &&
+		a1.SummaryPolyline == a2.SummaryPolyline
+
+}
 
+// nullFloat returns a null value when Strava did not send the value
+func nullFloat(f *float32) nulls.Float64 {
+	if f == nil {
+		return nulls.Float64{}
+	}
+	return nulls.NewFloat64(float64(*f))
 }
 
-// ParseStravaActivity converts from swagger.SummaryActivity to models.User
-func ParseStravaActivity(stravaActivity swagger.SummaryActivity, user User) *Activity {
-	return &Activity{
+// ParseStravaActivity converts from stravaclient.Activity to models.Activity
+func ParseStravaActivity(stravaActivity stravaclient.Activity, user User) *Activity {
+	activity := &Activity{
 		UserID:      user.ID,
 		Provider:    user.Provider,
 		ProviderID:  strconv.Itoa(int(stravaActivity.Id)),
@@ -113,5 +264,33 @@ func ParseStravaActivity(stravaActivity swagger.SummaryActivity, user User) *Act
 		Distance:    int(stravaActivity.Distance),
 		MovingTime:  int(stravaActivity.MovingTime),
 		ElapsedTime: int(stravaActivity.ElapsedTime),
+		Manual:      stravaActivity.Manual,
+		Trainer:     stravaActivity.Trainer,
+		Commute:     stravaActivity.Commute,
+		Private:     stravaActivity.Private,
+
+		TotalElevationGain: float64(stravaActivity.TotalElevationGain),
+		ElevHigh:           nullFloat(stravaActivity.ElevHigh),
+		ElevLow:            nullFloat(stravaActivity.ElevLow),
+		AverageSpeed:       float64(stravaActivity.AverageSpeed),
+		MaxSpeed:           float64(stravaActivity.MaxSpeed),
+		Timezone:           stravaActivity.Timezone,
+		GearID:             stravaActivity.GearId,
+		WorkoutType:        int(stravaActivity.WorkoutType),
+		KudosCount:         int(stravaActivity.KudosCount),
+	}
+
+	if stravaActivity.HasHeartrate {
+		activity.AverageHeartrate = nulls.NewFloat64(float64(stravaActivity.AverageHeartrate))
+		activity.MaxHeartrate = nulls.NewFloat64(float64(stravaActivity.MaxHeartrate))
 	}
+	if latlng := stravaActivity.StartLatlng; len(latlng) == 2 {
+		activity.StartLat = nulls.NewFloat64(float64(latlng[0]))
+		activity.StartLng = nulls.NewFloat64(float64(latlng[1]))
+	}
+	if stravaActivity.Map_ != nil {
+		activity.SummaryPolyline = stravaActivity.Map_.SummaryPolyline
+	}
+
+	return activity
 }
@@ -1,5 +1,54 @@
 package models
 
+import (
+	"encoding/json"
+
+	"github.com/gobuffalo/nulls"
+	stravaclient "github.com/tcarreira/roaw2020/strava_client"
+	"github.com/tcarreira/roaw2020/strava_client/swagger"
+)
+
 // func (ms *ModelSuite) Test_Activity() {
 // 	ms.Fail("This test needs to be implemented!")
 // }
+
+func (ms *ModelSuite) Test_ParseStravaActivity() {
+	raw := `{
+		"id": 42, "name": "Hills", "type": "Run", "start_date_local": "2020-06-20T08:00:00Z",
+		"distance": 10000, "moving_time": 3000, "elapsed_time": 3300,
+		"total_elevation_gain": 250.5, "elev_high": 400, "elev_low": 150,
+		"average_speed": 3.3, "max_speed": 5.1, "has_heartrate": true, "average_heartrate": 150, "max_heartrate": 180,
+		"start_latlng": [38.7, -9.1], "timezone": "(GMT+00:00) Europe/Lisbon", "gear_id": "g1",
+		"workout_type": 1, "kudos_count": 3, "commute": true, "map": {"summary_polyline": "abc"}
+	}`
+	stravaActivity := stravaclient.Activity{}
+	ms.NoError(json.Unmarshal([]byte(raw), &stravaActivity))
+
+	activity := ParseStravaActivity(stravaActivity, User{Provider: "strava"})
+	ms.Equal("42", activity.ProviderID)
+	ms.Equal(250.5, activity.TotalElevationGain)
+	ms.Equal(nulls.NewFloat64(400), activity.ElevHigh)
+	ms.Equal(nulls.NewFloat64(150), activity.AverageHeartrate)
+	ms.Equal(nulls.NewFloat64(180), activity.MaxHeartrate)
+	ms.InDelta(38.7, activity.StartLat.Float64, 0.0001)
+	ms.InDelta(-9.1, activity.StartLng.Float64, 0.0001)
+	ms.Equal("g1", activity.GearID)
+	ms.Equal(1, activity.WorkoutType)
+	ms.Equal(3, activity.KudosCount)
+	ms.True(activity.Commute)
+	ms.False(activity.Private)
+	ms.Equal("abc", activity.SummaryPolyline)
+
+	// manual activities have no GPS nor heart rate
+	manual := ParseStravaActivity(stravaclient.Activity{SummaryActivity: swagger.SummaryActivity{Id: 43, Type_: stravaActivity.Type_, Manual: true}}, User{Provider: "strava"})
+	ms.False(manual.StartLat.Valid)
+	ms.False(manual.AverageHeartrate.Valid)
+	ms.False(manual.ElevHigh.Valid)
+
+	// an activity at the sea level has a (zero) elevation
+	seaLevel := stravaclient.Activity{}
+	ms.NoError(json.Unmarshal([]byte(`{"id": 44, "type": "Swim", "elev_high": 2.5, "elev_low": 0}`), &seaLevel))
+	activity = ParseStravaActivity(seaLevel, User{Provider: "strava"})
+	ms.Equal(nulls.NewFloat64(2.5), activity.ElevHigh)
+	ms.Equal(nulls.NewFloat64(0), activity.ElevLow)
+}
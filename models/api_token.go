@@ -0,0 +1,136 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/validate/v3"
+	"github.com/gobuffalo/validate/v3/validators"
+	"github.com/gofrs/uuid"
+)
+
+// APIToken scopes
+const (
+	APITokenScopeRead      = "read"       // GET requests only
+	APITokenScopeReadWrite = "read-write" // any request
+)
+
+// apiTokenPrefix starts every token, so leaked tokens are easy to spot (eg: by secret scanners)
+const apiTokenPrefix = "roaw_"
+
+// APIToken is used by pop to map your api_tokens database table to your go code.
+// An APIToken is a personal access token, authenticating its user on the API (Authorization: Bearer <token>).
+// Only its hash is stored: the token is shown once, when it is created (see CreateAPIToken).
+type APIToken struct {
+	ID         uuid.UUID  `json:"id" db:"id"`
+	UserID     uuid.UUID  `json:"user_id" db:"user_id"`
+	Name       string     `json:"name" db:"name"`
+	Prefix     string     `json:"prefix" db:"prefix"` // the first characters of the token, to recognize it
+	TokenHash  string     `json:"-" db:"token_hash"`
+	Scope      string     `json:"scope" db:"scope"`
+	LastUsedAt nulls.Time `json:"last_used_at" db:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// String is not required by pop and may be deleted
+func (t APIToken) String() string {
+	jt, _ := json.Marshal(t)
+	return string(jt)
+}
+
+// APITokens is not required by pop and may be deleted
+type APITokens []APIToken
+
+// String is not required by pop and may be deleted
+func (t APITokens) String() string {
+	jt, _ := json.Marshal(t)
+	return string(jt)
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (t *APIToken) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.Validate(
+		&validators.UUIDIsPresent{Field: t.UserID, Name: "UserID"},
+		&validators.StringIsPresent{Field: t.Name, Name: "Name"},
+		&validators.StringIsPresent{Field: t.TokenHash, Name: "TokenHash"},
+		&validators.StringInclusion{Field: t.Scope, Name: "Scope", List: []string{APITokenScopeRead, APITokenScopeReadWrite}},
+	), nil
+}
+
+// hashAPIToken returns the stored hash of a token. Tokens are random (256 bits), so a fast hash is enough
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newAPIToken returns a random token
+func newAPIToken() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return apiTokenPrefix + hex.EncodeToString(secret), nil
+}
+
+// CreateAPIToken creates a token for the user (apiToken's Name and Scope). It returns the token itself,
+// which is not stored: it can not be shown again
+func CreateAPIToken(tx *pop.Connection, apiToken *APIToken, user *User) (string, *validate.Errors, error) {
+	token, err := newAPIToken()
+	if err != nil {
+		return "", nil, err
+	}
+	apiToken.UserID = user.ID
+	apiToken.Prefix = token[:len(apiTokenPrefix)+6]
+	apiToken.TokenHash = hashAPIToken(token)
+
+	verrs, err := tx.ValidateAndCreate(apiToken)
+	if err != nil || verrs.HasAny() {
+		return "", verrs, err
+	}
+	return token, verrs, nil
+}
+
+// FindAPIToken returns the APIToken of a token (an error when it does not exist, or was revoked)
+func FindAPIToken(tx *pop.Connection, token string) (*APIToken, error) {
+	apiToken := &APIToken{}
+	err := tx.Where("token_hash = ?", hashAPIToken(strings.TrimSpace(token))).First(apiToken)
+	return apiToken, err
+}
+
+// UserAPITokens returns the user's tokens (latest first)
+func UserAPITokens(tx *pop.Connection, userID uuid.UUID) (APITokens, error) {
+	apiTokens := APITokens{}
+	err := tx.Where("user_id = ?", userID).Order("created_at desc").All(&apiTokens)
+	return apiTokens, err
+}
+
+// Allows tells if the token's scope allows a request's method: read tokens only allow safe methods (GET, HEAD, OPTIONS)
+func (t *APIToken) Allows(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return t.Scope == APITokenScopeReadWrite
+}
+
+// apiTokenTouchInterval bounds how often LastUsedAt is written (not on every request)
+const apiTokenTouchInterval = time.Minute
+
+// Touch records the token was used now
+func (t *APIToken) Touch(tx *pop.Connection) error {
+	now := time.Now()
+	if t.LastUsedAt.Valid && now.Sub(t.LastUsedAt.Time) < apiTokenTouchInterval {
+		return nil
+	}
+	t.LastUsedAt = nulls.NewTime(now)
+	return tx.UpdateColumns(t, "last_used_at", "updated_at")
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"net/http"
+	"strings"
+)
+
+func (ms *ModelSuite) Test_APIToken() {
+	user := ms.createUser()
+
+	apiToken := &APIToken{Name: "Slack bot", Scope: APITokenScopeRead}
+	token, verrs, err := CreateAPIToken(DB, apiToken, user)
+	ms.NoError(err)
+	ms.False(verrs.HasAny())
+	ms.True(strings.HasPrefix(token, apiToken.Prefix))
+	ms.NotContains(apiToken.TokenHash, token[len(apiToken.Prefix):]) // only the hash is stored
+
+	found, err := FindAPIToken(DB, token)
+	ms.NoError(err)
+	ms.Equal(apiToken.ID, found.ID)
+	ms.True(found.Allows(http.MethodGet))
+	ms.False(found.Allows(http.MethodPost))
+
+	_, err = FindAPIToken(DB, token+"0")
+	ms.Error(err)
+
+	ms.NoError(found.Touch(DB))
+	ms.NoError(DB.Reload(found))
+	ms.True(found.LastUsedAt.Valid)
+
+	// an unknown scope
+	_, verrs, err = CreateAPIToken(DB, &APIToken{Name: "Admin", Scope: "admin"}, user)
+	ms.NoError(err)
+	ms.True(verrs.HasAny())
+
+	// deleting the user revokes its tokens
+	ms.NoError(user.DeleteWithActivities(DB))
+	_, err = FindAPIToken(DB, token)
+	ms.Error(err)
+}
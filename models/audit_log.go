@@ -0,0 +1,75 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/validate/v3"
+	"github.com/gobuffalo/validate/v3/validators"
+	"github.com/gofrs/uuid"
+)
+
+// AuditLog actions
+const (
+	AuditUserDeleted = "user.deleted"
+)
+
+// AuditLog is used by pop to map your audit_logs database table to your go code.
+// An AuditLog records an action on a user's data (eg: their deletion), kept after the user is gone:
+// only ids are recorded, never the user's personal data.
+type AuditLog struct {
+	ID        uuid.UUID  `json:"id" db:"id"`
+	Action    string     `json:"action" db:"action"`
+	UserID    uuid.UUID  `json:"user_id" db:"user_id"`   // whose data
+	ActorID   nulls.UUID `json:"actor_id" db:"actor_id"` // who did it (null: Strava, eg: a deauthorization)
+	Details   string     `json:"details" db:"details"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// String is not required by pop and may be deleted
+func (a AuditLog) String() string {
+	ja, _ := json.Marshal(a)
+	return string(ja)
+}
+
+// AuditLogs is not required by pop and may be deleted
+type AuditLogs []AuditLog
+
+// String is not required by pop and may be deleted
+func (a AuditLogs) String() string {
+	ja, _ := json.Marshal(a)
+	return string(ja)
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (a *AuditLog) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.Validate(
+		&validators.StringIsPresent{Field: a.Action, Name: "Action"},
+		&validators.UUIDIsPresent{Field: a.UserID, Name: "UserID"},
+	), nil
+}
+
+// ByStrava tells if the action was triggered from Strava (no actor)
+func (a AuditLog) ByStrava() bool {
+	return !a.ActorID.Valid
+}
+
+// recordAudit stores an AuditLog of the action on the user's data, by actor (nil: Strava)
+func recordAudit(tx *pop.Connection, action string, user *User, actor *User, details string) error {
+	audit := &AuditLog{Action: action, UserID: user.ID, Details: details}
+	if actor != nil {
+		audit.ActorID = nulls.NewUUID(actor.ID)
+	}
+	verrs, err := tx.ValidateAndCreate(audit)
+	if err != nil {
+		return err
+	}
+	if verrs.HasAny() {
+		return verrs
+	}
+	return nil
+}
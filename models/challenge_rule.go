@@ -0,0 +1,135 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/validate/v3"
+	"github.com/gobuffalo/validate/v3/validators"
+	"github.com/gofrs/uuid"
+)
+
+// ChallengeRule is used by pop to map your challenge_rules database table to your go code.
+// It defines which activities are valid (count) for the challenge.
+type ChallengeRule struct {
+	ID             uuid.UUID `json:"id" db:"id"`
+	Name           string    `json:"name" db:"name"`
+	ActivityTypes  string    `json:"activity_types" db:"activity_types"`     // comma separated (eg: "Run,VirtualRun")
+	MinDistance    int       `json:"min_distance" db:"min_distance"`         // meters
+	MinElapsedTime int       `json:"min_elapsed_time" db:"min_elapsed_time"` // seconds
+	AllowManual    bool      `json:"allow_manual" db:"allow_manual"`
+	AllowTrainer   bool      `json:"allow_trainer" db:"allow_trainer"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// String is not required by pop and may be deleted
+func (r ChallengeRule) String() string {
+	jr, _ := json.Marshal(r)
+	return string(jr)
+}
+
+// ChallengeRules is not required by pop and may be deleted
+type ChallengeRules []ChallengeRule
+
+// String is not required by pop and may be deleted
+func (r ChallengeRules) String() string {
+	jr, _ := json.Marshal(r)
+	return string(jr)
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (r *ChallengeRule) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.Validate(
+		&validators.StringIsPresent{Field: r.Name, Name: "Name"},
+		&validators.StringIsPresent{Field: strings.Join(r.Types(), ","), Name: "ActivityTypes"},
+		&validators.IntIsGreaterThan{Field: r.MinDistance, Name: "MinDistance", Compared: -1},
+		&validators.IntIsGreaterThan{Field: r.MinElapsedTime, Name: "MinElapsedTime", Compared: -1},
+	), nil
+}
+
+// DefaultChallengeRule is used while no rule is stored: runs of at least 15 minutes
+func DefaultChallengeRule() *ChallengeRule {
+	return &ChallengeRule{
+		Name:           "Default",
+		ActivityTypes:  "Run",
+		MinElapsedTime: 15 * 60,
+		AllowManual:    true,
+		AllowTrainer:   true,
+	}
+}
+
+// CurrentChallengeRule returns the stored rule (the oldest one), or DefaultChallengeRule when there is none
+func CurrentChallengeRule(tx *pop.Connection) (*ChallengeRule, error) {
+	rules := ChallengeRules{}
+	if err := tx.Order("created_at asc").Limit(1).All(&rules); err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return DefaultChallengeRule(), nil
+	}
+	return &rules[0], nil
+}
+
+// Types returns the (trimmed, non empty) activity types
+func (r *ChallengeRule) Types() []string {
+	types := []string{}
+	for _, activityType := range strings.Split(r.ActivityTypes, ",") {
+		if activityType = strings.TrimSpace(activityType); activityType != "" {
+			types = append(types, activityType)
+		}
+	}
+	return types
+}
+
+// Allows returns true when the activity is valid for the challenge
+func (r *ChallengeRule) Allows(a Activity) bool {
+	if a.DeletedAt.Valid {
+		return false
+	}
+	if a.Manual && !r.AllowManual || a.Trainer && !r.AllowTrainer {
+		return false
+	}
+	if a.Distance < r.MinDistance || a.ElapsedTime < r.MinElapsedTime {
+		return false
+	}
+	for _, activityType := range r.Types() {
+		if a.Type == activityType {
+			return true
+		}
+	}
+	return false
+}
+
+// Description is a human readable summary of the rule (eg: "Run activities, of at least 15 min (elapsed)")
+func (r *ChallengeRule) Description() string {
+	description := strings.Join(r.Types(), ", ") + " activities"
+
+	minimums := []string{}
+	if r.MinDistance > 0 {
+		minimums = append(minimums, fmt.Sprintf("%.1f km", float64(r.MinDistance)/1000))
+	}
+	if r.MinElapsedTime > 0 {
+		minimums = append(minimums, fmt.Sprintf("%d min (elapsed)", r.MinElapsedTime/60))
+	}
+	if len(minimums) > 0 {
+		description += ", of at least " + strings.Join(minimums, " and ")
+	}
+
+	excluded := []string{}
+	if !r.AllowManual {
+		excluded = append(excluded, "manual")
+	}
+	if !r.AllowTrainer {
+		excluded = append(excluded, "trainer")
+	}
+	if len(excluded) > 0 {
+		description += ", excluding " + strings.Join(excluded, " and ") + " activities"
+	}
+
+	return description
+}
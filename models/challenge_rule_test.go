@@ -0,0 +1,25 @@
+package models
+
+func (ms *ModelSuite) Test_ChallengeRule_Allows() {
+	rule := &ChallengeRule{ActivityTypes: "Run, VirtualRun", MinDistance: 1000, MinElapsedTime: 900, AllowManual: false, AllowTrainer: true}
+
+	ms.True(rule.Allows(Activity{Type: "Run", Distance: 5000, ElapsedTime: 1800}))
+	ms.True(rule.Allows(Activity{Type: "VirtualRun", Distance: 5000, ElapsedTime: 1800, Trainer: true}))
+	ms.False(rule.Allows(Activity{Type: "Ride", Distance: 5000, ElapsedTime: 1800}))
+	ms.False(rule.Allows(Activity{Type: "Run", Distance: 500, ElapsedTime: 1800}))
+	ms.False(rule.Allows(Activity{Type: "Run", Distance: 5000, ElapsedTime: 600}))
+	ms.False(rule.Allows(Activity{Type: "Run", Distance: 5000, ElapsedTime: 1800, Manual: true}))
+}
+
+func (ms *ModelSuite) Test_CurrentChallengeRule() {
+	rule, err := CurrentChallengeRule(DB)
+	ms.NoError(err)
+	ms.Equal(DefaultChallengeRule(), rule)
+
+	stored := &ChallengeRule{Name: "Long runs", ActivityTypes: "Run", MinDistance: 10000}
+	ms.NoError(DB.Create(stored))
+
+	rule, err = CurrentChallengeRule(DB)
+	ms.NoError(err)
+	ms.Equal(stored.ID, rule.ID)
+}
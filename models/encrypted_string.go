@@ -0,0 +1,76 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gofrs/uuid"
+	"github.com/tcarreira/roaw2020/secrets"
+)
+
+// EncryptedString is a string column encrypted at rest (see package secrets): it is encrypted when written,
+// and decrypted when read. Values stored in plaintext (eg: before ROAW_ENCRYPTION_KEYS was set) are read as they are
+type EncryptedString string
+
+// Value implements driver.Valuer: the encrypted value
+func (s EncryptedString) Value() (driver.Value, error) {
+	return secrets.Encrypt(string(s))
+}
+
+// Scan implements sql.Scanner: the decrypted value
+func (s *EncryptedString) Scan(src interface{}) error {
+	var value string
+	switch src := src.(type) {
+	case nil:
+	case string:
+		value = src
+	case []byte:
+		value = string(src)
+	default:
+		return fmt.Errorf("cannot scan %T into an EncryptedString", src)
+	}
+
+	decrypted, err := secrets.Decrypt(value)
+	if err != nil {
+		return err
+	}
+	*s = EncryptedString(decrypted)
+	return nil
+}
+
+// ReencryptUserTokens encrypts the users' tokens with the primary key: the ones stored in plaintext, or with an older key
+// (see secrets.Keyring.NeedsReencryption). It returns the number of re-encrypted users
+func ReencryptUserTokens(tx *pop.Connection) (int, error) {
+	keyring := secrets.Default()
+	if !keyring.Enabled() {
+		return 0, errors.New("ROAW_ENCRYPTION_KEYS is not set: there is no key to encrypt with")
+	}
+
+	rows := []struct {
+		ID           uuid.UUID `db:"id"`
+		AccessToken  string    `db:"access_token"`
+		RefreshToken string    `db:"refresh_token"`
+	}{}
+	if err := tx.RawQuery("SELECT id, access_token, refresh_token FROM users").All(&rows); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, row := range rows {
+		if !keyring.NeedsReencryption(row.AccessToken) && !keyring.NeedsReencryption(row.RefreshToken) {
+			continue
+		}
+
+		user := &User{}
+		if err := tx.Find(user, row.ID); err != nil {
+			return count, err
+		}
+		if err := tx.UpdateColumns(user, "access_token", "refresh_token"); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"strings"
+
+	"github.com/tcarreira/roaw2020/secrets"
+)
+
+// useKeys sets a Keyring of the keys (see secrets.GenerateKey) until the returned func is called
+func (ms *ModelSuite) useKeys(keys ...string) func() {
+	previous := secrets.Default()
+	keyring, err := secrets.ParseKeyring(strings.Join(keys, ","))
+	ms.NoError(err)
+	secrets.Use(keyring)
+	return func() { secrets.Use(previous) }
+}
+
+// storedTokens returns the user's tokens as stored
+func (ms *ModelSuite) storedTokens(user *User) (string, string) {
+	row := struct {
+		AccessToken  string `db:"access_token"`
+		RefreshToken string `db:"refresh_token"`
+	}{}
+	ms.NoError(DB.RawQuery("SELECT access_token, refresh_token FROM users WHERE id = ?", user.ID).First(&row))
+	return row.AccessToken, row.RefreshToken
+}
+
+func (ms *ModelSuite) Test_EncryptedString_TokensEncryptedAtRest() {
+	oldKey, err := secrets.GenerateKey("old")
+	ms.NoError(err)
+	newKey, err := secrets.GenerateKey("new")
+	ms.NoError(err)
+
+	// stored before the keys were set
+	restore := ms.useKeys()
+	plain := ms.createUser()
+	restore()
+	access, _ := ms.storedTokens(plain)
+	ms.Equal("access", access)
+
+	defer ms.useKeys(oldKey)()
+	user := &User{Name: "Encrypted", Provider: "strava", ProviderID: "43", AccessToken: "access", RefreshToken: "refresh"}
+	ms.NoError(DB.Create(user))
+	access, refresh := ms.storedTokens(user)
+	ms.True(secrets.IsEncrypted(access))
+	ms.True(secrets.IsEncrypted(refresh))
+	ms.NotContains(access, "access")
+
+	found := &User{}
+	ms.NoError(DB.Find(found, user.ID))
+	ms.Equal(EncryptedString("access"), found.AccessToken)
+	ms.Equal(EncryptedString("refresh"), found.RefreshToken)
+	ms.NoError(DB.Find(found, plain.ID)) // plaintext is still read
+	ms.Equal(EncryptedString("access"), found.AccessToken)
+
+	count, err := ReencryptUserTokens(DB)
+	ms.NoError(err)
+	ms.Equal(1, count) // the plaintext one
+
+	// rotated: the old key still decrypts, until the tokens are re-encrypted with the new one
+	defer ms.useKeys(newKey, oldKey)()
+	count, err = ReencryptUserTokens(DB)
+	ms.NoError(err)
+	ms.Equal(2, count)
+	for _, u := range []*User{plain, user} {
+		access, refresh := ms.storedTokens(u)
+		ms.True(strings.HasPrefix(access, "enc:v1:new:"))
+		ms.True(strings.HasPrefix(refresh, "enc:v1:new:"))
+	}
+
+	defer ms.useKeys(newKey)()
+	ms.NoError(DB.Find(found, user.ID))
+	ms.Equal(EncryptedString("access"), found.AccessToken)
+}
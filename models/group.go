@@ -0,0 +1,246 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/validate/v3"
+	"github.com/gobuffalo/validate/v3/validators"
+	"github.com/gofrs/uuid"
+)
+
+// Group membership roles
+const (
+	GroupRoleAdmin  = "admin"
+	GroupRoleMember = "member"
+)
+
+// ErrLastGroupAdmin is returned when the last admin would be removed (or demoted)
+var ErrLastGroupAdmin = errors.New("the group needs at least one admin")
+
+// Group is used by pop to map your groups database table to your go code.
+// A Group (league) is a private competition between its members. New members join with the invite code.
+type Group struct {
+	ID         uuid.UUID `json:"id" db:"id"`
+	Name       string    `json:"name" db:"name"`
+	InviteCode string    `json:"-" db:"invite_code"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// String is not required by pop and may be deleted
+func (g Group) String() string {
+	jg, _ := json.Marshal(g)
+	return string(jg)
+}
+
+// Groups is not required by pop and may be deleted
+type Groups []Group
+
+// String is not required by pop and may be deleted
+func (g Groups) String() string {
+	jg, _ := json.Marshal(g)
+	return string(jg)
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (g *Group) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.Validate(
+		&validators.StringIsPresent{Field: g.Name, Name: "Name"},
+		&validators.StringIsPresent{Field: g.InviteCode, Name: "InviteCode"},
+	), nil
+}
+
+// GroupMembership is used by pop to map your group_memberships database table to your go code.
+type GroupMembership struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	GroupID   uuid.UUID `json:"group_id" db:"group_id"`
+	UserID    uuid.UUID `json:"user_id" db:"user_id"`
+	Role      string    `json:"role" db:"role"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// GroupMemberships is not required by pop and may be deleted
+type GroupMemberships []GroupMembership
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (m *GroupMembership) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.Validate(
+		&validators.UUIDIsPresent{Field: m.GroupID, Name: "GroupID"},
+		&validators.UUIDIsPresent{Field: m.UserID, Name: "UserID"},
+		&validators.StringInclusion{Field: m.Role, Name: "Role", List: []string{GroupRoleAdmin, GroupRoleMember}},
+	), nil
+}
+
+// newInviteCode returns a random invite code
+func newInviteCode() (string, error) {
+	code := make([]byte, 8)
+	if _, err := rand.Read(code); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(code), nil
+}
+
+// CreateGroup creates a group (with a new invite code), with the user as its admin
+func CreateGroup(tx *pop.Connection, group *Group, admin *User) (*validate.Errors, error) {
+	inviteCode, err := newInviteCode()
+	if err != nil {
+		return nil, err
+	}
+	group.InviteCode = inviteCode
+
+	verrs, err := tx.ValidateAndCreate(group)
+	if err != nil || verrs.HasAny() {
+		return verrs, err
+	}
+
+	return verrs, tx.Create(&GroupMembership{GroupID: group.ID, UserID: admin.ID, Role: GroupRoleAdmin})
+}
+
+// FindGroupByInviteCode returns the group with this invite code
+func FindGroupByInviteCode(tx *pop.Connection, inviteCode string) (*Group, error) {
+	group := &Group{}
+	err := tx.Where("invite_code = ?", inviteCode).First(group)
+	return group, err
+}
+
+// UserGroups returns the groups the user is a member of (by name)
+func UserGroups(tx *pop.Connection, userID uuid.UUID) (Groups, error) {
+	groups := Groups{}
+	q := tx.Where("id IN (SELECT group_id FROM group_memberships WHERE user_id = ?)", userID).Order("name asc")
+	err := q.All(&groups)
+	return groups, err
+}
+
+// RegenerateInviteCode replaces the invite code (the previous invite links stop working)
+func (g *Group) RegenerateInviteCode(tx *pop.Connection) error {
+	inviteCode, err := newInviteCode()
+	if err != nil {
+		return err
+	}
+	g.InviteCode = inviteCode
+	return tx.UpdateColumns(g, "invite_code", "updated_at")
+}
+
+// Membership returns the user's membership (nil when the user is not a member)
+func (g *Group) Membership(tx *pop.Connection, userID uuid.UUID) (*GroupMembership, error) {
+	memberships := GroupMemberships{}
+	if err := tx.Where("group_id = ? AND user_id = ?", g.ID, userID).All(&memberships); err != nil {
+		return nil, err
+	}
+	if len(memberships) == 0 {
+		return nil, nil
+	}
+	return &memberships[0], nil
+}
+
+// IsAdmin returns true when the user is one of the group's admins
+func (g *Group) IsAdmin(tx *pop.Connection, userID uuid.UUID) (bool, error) {
+	membership, err := g.Membership(tx, userID)
+	return membership != nil && membership.Role == GroupRoleAdmin, err
+}
+
+// Join adds the user to the group, as a member (nothing changes if already a member)
+func (g *Group) Join(tx *pop.Connection, user *User) error {
+	membership, err := g.Membership(tx, user.ID)
+	if err != nil || membership != nil {
+		return err
+	}
+	return tx.Create(&GroupMembership{GroupID: g.ID, UserID: user.ID, Role: GroupRoleMember})
+}
+
+// Members returns the group's memberships (admins first)
+func (g *Group) Members(tx *pop.Connection) (GroupMemberships, error) {
+	memberships := GroupMemberships{}
+	err := tx.Where("group_id = ?", g.ID).Order("role asc, created_at asc").All(&memberships)
+	return memberships, err
+}
+
+// SetRole changes a member's role. The last admin can not stop being one.
+func (g *Group) SetRole(tx *pop.Connection, membership *GroupMembership, role string) error {
+	if membership.Role == GroupRoleAdmin && role != GroupRoleAdmin {
+		if err := g.ensureAnotherAdmin(tx, membership); err != nil {
+			return err
+		}
+	}
+	membership.Role = role
+	verrs, err := tx.ValidateAndUpdate(membership)
+	if err != nil {
+		return err
+	}
+	if verrs.HasAny() {
+		return verrs
+	}
+	return nil
+}
+
+// RemoveMember removes the membership (eg: a member leaving). The last admin can not leave.
+func (g *Group) RemoveMember(tx *pop.Connection, membership *GroupMembership) error {
+	if membership.Role == GroupRoleAdmin {
+		if err := g.ensureAnotherAdmin(tx, membership); err != nil {
+			return err
+		}
+	}
+	return tx.Destroy(membership)
+}
+
+// leaveGroups removes the user from every group (eg: the user is deleted). A group left without admins gets
+// a new one (its oldest member), or is deleted when nobody is left
+func leaveGroups(tx *pop.Connection, userID uuid.UUID) error {
+	memberships := GroupMemberships{}
+	if err := tx.Where("user_id = ?", userID).All(&memberships); err != nil {
+		return err
+	}
+
+	for i := range memberships {
+		membership := &memberships[i]
+		if err := tx.Destroy(membership); err != nil {
+			return err
+		}
+		if membership.Role != GroupRoleAdmin {
+			continue
+		}
+		if err := ensureGroupAdmin(tx, membership.GroupID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureGroupAdmin promotes the group's oldest member when there is no admin left, or deletes the group without members
+func ensureGroupAdmin(tx *pop.Connection, groupID uuid.UUID) error {
+	admins, err := tx.Where("group_id = ? AND role = ?", groupID, GroupRoleAdmin).Count(&GroupMembership{})
+	if err != nil || admins > 0 {
+		return err
+	}
+
+	members := GroupMemberships{}
+	if err := tx.Where("group_id = ?", groupID).Order("created_at asc").Limit(1).All(&members); err != nil {
+		return err
+	}
+	if len(members) == 0 {
+		return tx.RawQuery("DELETE FROM groups WHERE id = ?", groupID).Exec()
+	}
+
+	members[0].Role = GroupRoleAdmin
+	return tx.UpdateColumns(&members[0], "role", "updated_at")
+}
+
+func (g *Group) ensureAnotherAdmin(tx *pop.Connection, membership *GroupMembership) error {
+	q := tx.Where("group_id = ? AND role = ? AND id <> ?", g.ID, GroupRoleAdmin, membership.ID)
+	others, err := q.Count(&GroupMembership{})
+	if err != nil {
+		return err
+	}
+	if others == 0 {
+		return ErrLastGroupAdmin
+	}
+	return nil
+}
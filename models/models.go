@@ -1,6 +1,7 @@
 package models
 
 import (
+	"fmt"
 	"log"
 
 	"github.com/gobuffalo/envy"
@@ -20,3 +21,18 @@ func init() {
 	}
 	pop.Debug = env == "development"
 }
+
+// savepoint runs fn on a savepoint of the transaction tx, rolled back to when fn fails:
+// the transaction goes on (eg: to record the failure) without any of fn's writes
+func savepoint(tx *pop.Connection, name string, fn func() error) error {
+	if err := tx.RawQuery("SAVEPOINT " + name).Exec(); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		if rerr := tx.RawQuery("ROLLBACK TO SAVEPOINT " + name).Exec(); rerr != nil {
+			return fmt.Errorf("%v (rolling back: %w)", err, rerr)
+		}
+		return err
+	}
+	return tx.RawQuery("RELEASE SAVEPOINT " + name).Exec()
+}
@@ -5,6 +5,8 @@ import (
 
 	"github.com/gobuffalo/packr/v2"
 	"github.com/gobuffalo/suite"
+
+	"github.com/tcarreira/roaw2020/testdb"
 )
 
 type ModelSuite struct {
@@ -12,6 +14,10 @@ type ModelSuite struct {
 }
 
 func Test_ModelSuite(t *testing.T) {
+	if err := testdb.Reset(DB, "../migrations"); err != nil {
+		t.Fatal(err)
+	}
+
 	model, err := suite.NewModelWithFixtures(packr.New("app:models:test:fixtures", "../fixtures"))
 	if err != nil {
 		t.Fatal(err)
@@ -0,0 +1,168 @@
+package models
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/gobuffalo/envy"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/validate/v3"
+	"github.com/gobuffalo/validate/v3/validators"
+	"github.com/gofrs/uuid"
+)
+
+// Season is used by pop to map your seasons database table to your go code.
+// A Season is a challenge edition: its activities are the ones started within [StartsOn, EndsOn),
+// valid according to its ChallengeRule (or the current one when not set).
+type Season struct {
+	ID              uuid.UUID  `json:"id" db:"id"`
+	Name            string     `json:"name" db:"name"`
+	StartsOn        time.Time  `json:"starts_on" db:"starts_on"`
+	EndsOn          time.Time  `json:"ends_on" db:"ends_on"` // exclusive
+	ChallengeRuleID nulls.UUID `json:"challenge_rule_id" db:"challenge_rule_id"`
+	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// String is not required by pop and may be deleted
+func (s Season) String() string {
+	js, _ := json.Marshal(s)
+	return string(js)
+}
+
+// Seasons is not required by pop and may be deleted
+type Seasons []Season
+
+// String is not required by pop and may be deleted
+func (s Seasons) String() string {
+	js, _ := json.Marshal(s)
+	return string(js)
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (s *Season) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.Validate(
+		&validators.StringIsPresent{Field: s.Name, Name: "Name"},
+		&validators.TimeIsPresent{Field: s.StartsOn, Name: "StartsOn"},
+		&validators.TimeIsPresent{Field: s.EndsOn, Name: "EndsOn"},
+		&validators.TimeAfterTime{FirstTime: s.EndsOn, FirstName: "EndsOn", SecondTime: s.StartsOn, SecondName: "StartsOn"},
+	), nil
+}
+
+// DefaultSeason is used while no season is stored: the whole ROAW_YEAR (default: this year)
+func DefaultSeason() *Season {
+	year, err := strconv.Atoi(envy.Get("ROAW_YEAR", ""))
+	if err != nil {
+		year = time.Now().Year()
+	}
+
+	return &Season{
+		Name:     strconv.Itoa(year),
+		StartsOn: time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndsOn:   time.Date(year+1, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+// AllSeasons returns every season (latest first), or just DefaultSeason when there is none
+func AllSeasons(tx *pop.Connection) (Seasons, error) {
+	seasons := Seasons{}
+	if err := tx.Order("starts_on desc").All(&seasons); err != nil {
+		return nil, err
+	}
+	if len(seasons) == 0 {
+		seasons = append(seasons, *DefaultSeason())
+	}
+	return seasons, nil
+}
+
+// ActiveSeasons returns the seasons running at the given time
+func ActiveSeasons(tx *pop.Connection, at time.Time) (Seasons, error) {
+	seasons := Seasons{}
+	err := tx.Where("starts_on <= ? AND ends_on > ?", at, at).Order("starts_on desc").All(&seasons)
+	return seasons, err
+}
+
+// CurrentSeason returns the active season (the latest started one),
+// else the latest season which already started, else DefaultSeason
+func CurrentSeason(tx *pop.Connection) (*Season, error) {
+	seasons := Seasons{}
+	if err := tx.Where("starts_on <= ?", time.Now()).Order("starts_on desc").Limit(1).All(&seasons); err != nil {
+		return nil, err
+	}
+	if len(seasons) == 0 {
+		return DefaultSeason(), nil
+	}
+	return &seasons[0], nil
+}
+
+// FindSeason returns the season by id, or CurrentSeason when id is empty (or nil, as the DefaultSeason's)
+func FindSeason(tx *pop.Connection, id string) (*Season, error) {
+	if id == "" || id == uuid.Nil.String() {
+		return CurrentSeason(tx)
+	}
+
+	season := &Season{}
+	if err := tx.Find(season, id); err != nil {
+		return nil, err
+	}
+	return season, nil
+}
+
+// SyncWindow returns the [start, end) window of activities to sync: covering every active season,
+// or the CurrentSeason when none is active
+func SyncWindow(tx *pop.Connection) (time.Time, time.Time, error) {
+	seasons, err := ActiveSeasons(tx, time.Now())
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if len(seasons) == 0 {
+		season, err := CurrentSeason(tx)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		seasons = append(seasons, *season)
+	}
+
+	start, end := seasons[0].StartsOn, seasons[0].EndsOn
+	for _, season := range seasons[1:] {
+		if season.StartsOn.Before(start) {
+			start = season.StartsOn
+		}
+		if season.EndsOn.After(end) {
+			end = season.EndsOn
+		}
+	}
+	return start, end, nil
+}
+
+// ChallengeRule returns the season's rule (or the current one, when not set)
+func (s *Season) ChallengeRule(tx *pop.Connection) (*ChallengeRule, error) {
+	if !s.ChallengeRuleID.Valid {
+		return CurrentChallengeRule(tx)
+	}
+
+	rule := &ChallengeRule{}
+	err := tx.Find(rule, s.ChallengeRuleID.UUID)
+	return rule, err
+}
+
+// Contains returns true when the activity started within the season
+func (s *Season) Contains(a Activity) bool {
+	return !a.Datetime.Before(s.StartsOn) && a.Datetime.Before(s.EndsOn)
+}
+
+// Weeks returns the weeks of the season (starting on ROAW_WEEK_START), to bucket every weekly stat
+func (s *Season) Weeks() Weeks {
+	return NewWeeks(s.StartsOn, s.EndsOn, WeekStart())
+}
+
+// StatsID identifies the season's WeeklyUserStats: its ID, or (for the DefaultSeason, not stored) an ID derived from its start
+func (s *Season) StatsID() uuid.UUID {
+	if s.ID != uuid.Nil {
+		return s.ID
+	}
+	return uuid.NewV5(uuid.Nil, "season:"+s.StartsOn.Format("2006-01-02"))
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gobuffalo/envy"
+	"github.com/gofrs/uuid"
+)
+
+func (ms *ModelSuite) Test_CurrentSeason_DefaultsToROAWYear() {
+	envy.Set("ROAW_YEAR", "2020")
+	defer envy.Set("ROAW_YEAR", "")
+
+	season, err := CurrentSeason(DB)
+	ms.NoError(err)
+	ms.Equal("2020", season.Name)
+	ms.Equal(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), season.StartsOn)
+	ms.Equal(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), season.EndsOn)
+}
+
+func (ms *ModelSuite) Test_SyncWindow() {
+	now := time.Now().UTC()
+	past := &Season{Name: "Past", StartsOn: now.AddDate(-2, 0, 0), EndsOn: now.AddDate(-1, 0, 0)}
+	spring := &Season{Name: "Spring", StartsOn: now.AddDate(0, -1, 0), EndsOn: now.AddDate(0, 2, 0)}
+	year := &Season{Name: "Year", StartsOn: now.AddDate(0, -6, 0), EndsOn: now.AddDate(0, 1, 0)}
+	for _, season := range []*Season{past, spring, year} {
+		ms.NoError(DB.Create(season))
+	}
+
+	// covering both active seasons
+	start, end, err := SyncWindow(DB)
+	ms.NoError(err)
+	ms.Equal(year.StartsOn.Unix(), start.Unix())
+	ms.Equal(spring.EndsOn.Unix(), end.Unix())
+
+	// the current season is the latest started
+	season, err := CurrentSeason(DB)
+	ms.NoError(err)
+	ms.Equal(spring.ID, season.ID)
+}
+
+func (ms *ModelSuite) Test_SyncWindow_NoActiveSeason() {
+	now := time.Now().UTC()
+	past := &Season{Name: "Past", StartsOn: now.AddDate(-2, 0, 0), EndsOn: now.AddDate(-1, 0, 0)}
+	ms.NoError(DB.Create(past))
+
+	// the latest season is still synced (eg: late uploads)
+	start, end, err := SyncWindow(DB)
+	ms.NoError(err)
+	ms.Equal(past.StartsOn.Unix(), start.Unix())
+	ms.Equal(past.EndsOn.Unix(), end.Unix())
+}
+
+func (ms *ModelSuite) Test_Season_Weeks() {
+	season := &Season{
+		StartsOn: time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC),
+		EndsOn:   time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	// 2020-01-01 is a Wednesday: week 1 starts on Monday, 2019-12-30
+	weeks := season.Weeks()
+	ms.Equal(53, weeks.Count())
+	ms.Equal(time.Date(2019, time.December, 30, 0, 0, 0, 0, time.UTC), weeks.StartsOn(1))
+	ms.Equal(11, weeks.Of(time.Date(2020, time.March, 11, 12, 0, 0, 0, time.UTC)))
+	ms.Equal(54, weeks.Of(time.Date(2021, time.March, 11, 12, 0, 0, 0, time.UTC)))
+	ms.Equal(0, weeks.Of(time.Date(2019, time.March, 11, 12, 0, 0, 0, time.UTC)))
+
+	envy.Set("ROAW_WEEK_START", "sunday")
+	defer envy.Set("ROAW_WEEK_START", "")
+	ms.Equal(time.Date(2019, time.December, 29, 0, 0, 0, 0, time.UTC), season.Weeks().StartsOn(1))
+}
+
+func (ms *ModelSuite) Test_Season_StatsID() {
+	season := DefaultSeason()
+	ms.NotEqual(uuid.Nil, season.StatsID())
+	ms.Equal(season.StatsID(), DefaultSeason().StatsID())
+
+	season.ID = uuid.Must(uuid.NewV4())
+	ms.Equal(season.ID, season.StatsID())
+}
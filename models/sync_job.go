@@ -0,0 +1,192 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gobuffalo/envy"
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/validate/v3"
+	"github.com/gobuffalo/validate/v3/validators"
+	"github.com/gofrs/uuid"
+)
+
+// SyncJob status
+const (
+	SyncJobQueued    = "queued"
+	SyncJobRunning   = "running"
+	SyncJobRetrying  = "retrying"
+	SyncJobSucceeded = "succeeded"
+	SyncJobFailed    = "failed"
+)
+
+// SyncJob mode (which activities are fetched from the provider)
+const (
+	SyncModeLatest = "latest"
+	SyncModeAll    = "all"
+)
+
+// syncJobRetryBackoff is the wait before the first retry (doubled on every attempt)
+const syncJobRetryBackoff = 30 * time.Second
+
+// syncJobExpiry is how long a pending SyncJob may go without any progress before it is considered lost
+// (eg: dropped by the worker on a restart)
+const syncJobExpiry = 6 * time.Hour
+
+// SyncJob is used by pop to map your sync_jobs database table to your go code.
+// Each SyncJob syncs the activities of a single User, in background.
+type SyncJob struct {
+	ID          uuid.UUID    `json:"id" db:"id"`
+	UserID      uuid.UUID    `json:"user_id" db:"user_id"`
+	Mode        string       `json:"mode" db:"mode"`
+	Status      string       `json:"status" db:"status"`
+	Attempts    int          `json:"attempts" db:"attempts"`
+	MaxAttempts int          `json:"max_attempts" db:"max_attempts"`
+	LastError   nulls.String `json:"last_error" db:"last_error"`
+	Report      nulls.String `json:"report" db:"report"`
+	StartedAt   nulls.Time   `json:"started_at" db:"started_at"`
+	FinishedAt  nulls.Time   `json:"finished_at" db:"finished_at"`
+	CreatedAt   time.Time    `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at" db:"updated_at"`
+}
+
+// String is not required by pop and may be deleted
+func (j SyncJob) String() string {
+	jj, _ := json.Marshal(j)
+	return string(jj)
+}
+
+// SyncJobs is not required by pop and may be deleted
+type SyncJobs []SyncJob
+
+// String is not required by pop and may be deleted
+func (j SyncJobs) String() string {
+	jj, _ := json.Marshal(j)
+	return string(jj)
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (j *SyncJob) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.Validate(
+		&validators.UUIDIsPresent{Field: j.UserID, Name: "UserID"},
+		&validators.StringInclusion{Field: j.Mode, Name: "Mode", List: []string{SyncModeLatest, SyncModeAll}},
+		&validators.StringInclusion{Field: j.Status, Name: "Status", List: []string{SyncJobQueued, SyncJobRunning, SyncJobRetrying, SyncJobSucceeded, SyncJobFailed}},
+	), nil
+}
+
+// NewSyncJob returns a queued SyncJob for this user (MaxAttempts from ROAW_SYNC_MAX_ATTEMPTS, default 5)
+func NewSyncJob(userID uuid.UUID, mode string) *SyncJob {
+	maxAttempts, err := strconv.Atoi(envy.Get("ROAW_SYNC_MAX_ATTEMPTS", "5"))
+	if err != nil || maxAttempts < 1 {
+		maxAttempts = 5
+	}
+
+	return &SyncJob{
+		UserID:      userID,
+		Mode:        mode,
+		Status:      SyncJobQueued,
+		MaxAttempts: maxAttempts,
+	}
+}
+
+// pendingSyncJobs scopes the SyncJobs which have not finished yet (and are not expired)
+func pendingSyncJobs(q *pop.Query) *pop.Query {
+	return q.Where("status in (?)", SyncJobQueued, SyncJobRunning, SyncJobRetrying).
+		Where("updated_at > ?", time.Now().Add(-syncJobExpiry))
+}
+
+// HasPendingSyncJob returns true when the user has a SyncJob which has not finished yet.
+// Jobs without progress for longer than syncJobExpiry are ignored
+func HasPendingSyncJob(tx *pop.Connection, userID uuid.UUID) (bool, error) {
+	return tx.Where("user_id = ?", userID).Scope(pendingSyncJobs).Exists(&SyncJob{})
+}
+
+// PendingSyncJobs returns the SyncJobs which have not finished yet (and are not expired), oldest first
+func PendingSyncJobs(tx *pop.Connection) (SyncJobs, error) {
+	jobs := SyncJobs{}
+	err := tx.Scope(pendingSyncJobs).Order("created_at asc").All(&jobs)
+	return jobs, err
+}
+
+// ExpireSyncJobs fails the pending SyncJobs without progress for longer than syncJobExpiry
+func ExpireSyncJobs(tx *pop.Connection) error {
+	now := time.Now()
+	return tx.RawQuery("update sync_jobs set status = ?, last_error = ?, finished_at = ?, updated_at = ? where status in (?, ?, ?) and updated_at <= ?",
+		SyncJobFailed, "expired", now, now, SyncJobQueued, SyncJobRunning, SyncJobRetrying, now.Add(-syncJobExpiry)).Exec()
+}
+
+// IsPending returns true while the job has not finished (successfully or not)
+func (j *SyncJob) IsPending() bool {
+	return j.Status == SyncJobQueued || j.Status == SyncJobRunning || j.Status == SyncJobRetrying
+}
+
+// ShouldRetry returns true when the last attempt failed but there are attempts left
+func (j *SyncJob) ShouldRetry() bool {
+	return j.Status == SyncJobRetrying
+}
+
+// RetryIn returns the backoff before the next attempt (30s, 1m, 2m, 4m, ...)
+func (j *SyncJob) RetryIn() time.Duration {
+	if j.Attempts < 1 {
+		return syncJobRetryBackoff
+	}
+	return syncJobRetryBackoff << uint(j.Attempts-1)
+}
+
+// Perform runs one attempt of this job and records its outcome (status, attempts, last error).
+// The user's access token is refreshed (and stored) first: Strava rotates the tokens, so they must outlive a failed sync.
+// Then the user's activities are synced (with syncFunction, eg: (*User).SyncLatestActivities) on their own transaction,
+// so a failure is still recorded. On success, the sync report is recorded.
+func (j *SyncJob) Perform(db *pop.Connection, syncFunction func(user *User, tx *pop.Connection) (SyncReport, error)) error {
+	user := &User{}
+	if err := db.Find(user, j.UserID); err != nil {
+		// the user is gone. Nothing to retry
+		j.Attempts++
+		return j.finish(db, SyncJobFailed, err)
+	}
+
+	j.Attempts++
+	j.Status = SyncJobRunning
+	j.StartedAt = nulls.NewTime(time.Now())
+	if err := db.Save(j); err != nil {
+		return err
+	}
+
+	report := SyncReport{}
+	err := user.RefreshAccessToken(db)
+	if err == nil {
+		err = db.Transaction(func(tx *pop.Connection) error {
+			var err error
+			report, err = syncFunction(user, tx)
+			return err
+		})
+	}
+
+	switch {
+	case err == nil:
+		j.Report = nulls.NewString(report.String())
+		return j.finish(db, SyncJobSucceeded, nil)
+	case errors.Is(err, ErrNeedsReauth):
+		// nothing to retry until the user logs in again
+		return j.finish(db, SyncJobFailed, err)
+	case j.Attempts < j.MaxAttempts:
+		j.Status = SyncJobRetrying
+		j.LastError = nulls.NewString(err.Error())
+		return db.Save(j)
+	default:
+		return j.finish(db, SyncJobFailed, err)
+	}
+}
+
+func (j *SyncJob) finish(db *pop.Connection, status string, err error) error {
+	j.Status = status
+	j.FinishedAt = nulls.NewTime(time.Now())
+	if err != nil {
+		j.LastError = nulls.NewString(err.Error())
+	}
+	return db.Save(j)
+}
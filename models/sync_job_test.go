@@ -0,0 +1,153 @@
+package models
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gobuffalo/pop/v5"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/strava"
+	"golang.org/x/oauth2"
+
+	stravaclient "github.com/tcarreira/roaw2020/strava_client"
+	"github.com/tcarreira/roaw2020/strava_client/swagger"
+)
+
+// fakeStravaProvider does not call Strava to refresh tokens. The "revoked" refresh token is refused, like Strava does
+type fakeStravaProvider struct {
+	*strava.Provider
+	refreshes int
+}
+
+func (p *fakeStravaProvider) RefreshToken(refreshToken string) (*oauth2.Token, error) {
+	p.refreshes++
+	if refreshToken == "revoked" {
+		return nil, &oauth2.RetrieveError{Response: &http.Response{StatusCode: http.StatusBadRequest, Status: "400 Bad Request"}, Body: []byte(`{"message":"Bad Request"}`)}
+	}
+	return &oauth2.Token{AccessToken: "access", RefreshToken: refreshToken, Expiry: time.Now().Add(6 * time.Hour)}, nil
+}
+
+func init() {
+	goth.UseProviders(&fakeStravaProvider{Provider: strava.New("key", "secret", "")})
+}
+
+func (ms *ModelSuite) createUser() *User {
+	user := &User{Name: "Athlete", Provider: "strava", ProviderID: "42", AccessToken: "access", RefreshToken: "refresh"}
+	ms.NoError(ms.DB.Create(user))
+	return user
+}
+
+func (ms *ModelSuite) Test_SyncJob_Perform() {
+	user := ms.createUser()
+	job := NewSyncJob(user.ID, SyncModeLatest)
+	ms.NoError(ms.DB.Create(job))
+
+	runType := swagger.ActivityType("Run")
+	ms.NoError(job.Perform(DB, func(user *User, tx *pop.Connection) (SyncReport, error) {
+		return user.SyncActivities(tx, func(string) ([]stravaclient.Activity, error) {
+			return []stravaclient.Activity{{SummaryActivity: swagger.SummaryActivity{Id: 1, Name: "Run", Type_: &runType, Distance: 1000, MovingTime: 300, ElapsedTime: 300}}}, nil
+		})
+	}))
+
+	ms.Equal(SyncJobSucceeded, job.Status)
+	ms.Equal(1, job.Attempts)
+	ms.True(job.FinishedAt.Valid)
+	ms.Equal("1 activities fetched", job.Report.String)
+	ms.False(job.ShouldRetry())
+
+	count, err := ms.DB.Where("user_id = ?", user.ID).Count(&Activity{})
+	ms.NoError(err)
+	ms.Equal(1, count)
+
+	ms.NoError(DB.Reload(user))
+	ms.True(user.LastSyncedAt.Valid)
+}
+
+func (ms *ModelSuite) Test_SyncJob_Perform_Retries() {
+	user := ms.createUser()
+	job := NewSyncJob(user.ID, SyncModeAll)
+	job.MaxAttempts = 2
+	ms.NoError(ms.DB.Create(job))
+
+	failing := func(user *User, tx *pop.Connection) (SyncReport, error) {
+		return SyncReport{}, errors.New("strava is down")
+	}
+
+	ms.NoError(job.Perform(DB, failing))
+	ms.Equal(SyncJobRetrying, job.Status)
+	ms.True(job.ShouldRetry())
+	ms.Equal(syncJobRetryBackoff, job.RetryIn())
+	ms.Contains(job.LastError.String, "strava is down")
+
+	ms.NoError(job.Perform(DB, failing))
+	ms.Equal(SyncJobFailed, job.Status)
+	ms.Equal(2, job.Attempts)
+	ms.False(job.ShouldRetry())
+	ms.False(job.IsPending())
+}
+
+func (ms *ModelSuite) Test_SyncJob_Perform_KeepsRefreshedTokens() {
+	user := ms.createUser()
+	job := NewSyncJob(user.ID, SyncModeLatest)
+	ms.NoError(ms.DB.Create(job))
+
+	ms.NoError(job.Perform(DB, func(user *User, tx *pop.Connection) (SyncReport, error) {
+		return SyncReport{}, errors.New("strava is down")
+	}))
+	ms.Equal(SyncJobRetrying, job.Status)
+
+	// the tokens Strava rotated were not rolled back with the failed sync
+	ms.NoError(DB.Reload(user))
+	ms.True(user.TokenExpiresAt.Valid)
+	ms.False(user.AccessTokenExpired())
+	ms.False(user.NeedsReauth)
+}
+
+func (ms *ModelSuite) Test_SyncJob_Perform_NeedsReauth() {
+	user := ms.createUser()
+	ms.NoError(ms.DB.RawQuery("update users set refresh_token = ? where id = ?", "revoked", user.ID).Exec())
+	job := NewSyncJob(user.ID, SyncModeLatest)
+	ms.NoError(ms.DB.Create(job))
+
+	ms.NoError(job.Perform(DB, func(user *User, tx *pop.Connection) (SyncReport, error) {
+		return user.SyncActivities(tx, func(string) ([]stravaclient.Activity, error) { return nil, nil })
+	}))
+
+	// not retried: the user must log in again
+	ms.Equal(SyncJobFailed, job.Status)
+	ms.Equal(1, job.Attempts)
+	ms.Contains(job.LastError.String, ErrNeedsReauth.Error())
+
+	ms.NoError(DB.Reload(user))
+	ms.True(user.NeedsReauth)
+}
+
+func (ms *ModelSuite) Test_SyncJob_Expiry() {
+	user := ms.createUser()
+	lost := NewSyncJob(user.ID, SyncModeLatest)
+	ms.NoError(ms.DB.Create(lost))
+	ms.NoError(ms.DB.RawQuery("update sync_jobs set updated_at = ? where id = ?", time.Now().Add(-syncJobExpiry-time.Minute), lost.ID).Exec())
+
+	// a lost job does not keep the user from being synced
+	pending, err := HasPendingSyncJob(DB, user.ID)
+	ms.NoError(err)
+	ms.False(pending)
+
+	queued := NewSyncJob(user.ID, SyncModeLatest)
+	ms.NoError(ms.DB.Create(queued))
+
+	pending, err = HasPendingSyncJob(DB, user.ID)
+	ms.NoError(err)
+	ms.True(pending)
+
+	ms.NoError(ExpireSyncJobs(DB))
+	ms.NoError(DB.Reload(lost))
+	ms.Equal(SyncJobFailed, lost.Status)
+	ms.True(lost.FinishedAt.Valid)
+
+	jobs, err := PendingSyncJobs(DB)
+	ms.NoError(err)
+	ms.Len(jobs, 1)
+	ms.Equal(queued.ID, jobs[0].ID)
+}
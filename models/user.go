@@ -2,31 +2,47 @@ package models
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"strings"
 	"time"
 
+	"github.com/gobuffalo/envy"
 	"github.com/gobuffalo/nulls"
 	"github.com/gobuffalo/pop/v5"
 	"github.com/gobuffalo/validate/v3"
 	"github.com/gobuffalo/validate/v3/validators"
 	"github.com/gofrs/uuid"
 	"github.com/markbates/goth"
-	"github.com/tcarreira/roaw2020/strava_client/swagger"
+	stravaclient "github.com/tcarreira/roaw2020/strava_client"
+	"golang.org/x/oauth2"
+)
+
+// User roles: admins manage the app (seasons, challenge rules, everyone's syncs), members only their own data
+const (
+	UserRoleAdmin  = "admin"
+	UserRoleMember = "member"
 )
 
 // User is used by pop to map your users database table to your go code.
 type User struct {
-	ID           uuid.UUID    `json:"id" db:"id"`
-	Name         string       `json:"name" db:"name"`
-	Email        nulls.String `json:"email" db:"email"`
-	Provider     string       `json:"provider" db:"provider"`
-	ProviderID   string       `json:"provider_id" db:"provider_id"`
-	AccessToken  string       `json:"access_token" db:"access_token"`
-	RefreshToken string       `json:"refresh_token" db:"refresh_token"`
-	AvatarURL    string       `json:"avatar_url" db:"avatar_url"`
-	CreatedAt    time.Time    `json:"created_at" db:"created_at"`
-	UpdatedAt    time.Time    `json:"updated_at" db:"updated_at"`
+	ID                     uuid.UUID       `json:"id" db:"id"`
+	Name                   string          `json:"name" db:"name"`
+	Email                  nulls.String    `json:"email" db:"email"`
+	Provider               string          `json:"provider" db:"provider"`
+	ProviderID             string          `json:"provider_id" db:"provider_id"`
+	AccessToken            EncryptedString `json:"-" xml:"-" db:"access_token"`                            // never rendered
+	RefreshToken           EncryptedString `json:"-" xml:"-" db:"refresh_token"`                           // never rendered
+	TokenExpiresAt         nulls.Time      `json:"token_expires_at" db:"token_expires_at"`                 // null: unknown, refreshed on next use
+	NeedsReauth            bool            `json:"needs_reauth" db:"needs_reauth"`                         // the refresh token was revoked: must log in again
+	Role                   string          `json:"role" db:"role"`                                         // UserRoleAdmin or UserRoleMember
+	HiddenFromLeaderboards bool            `json:"hidden_from_leaderboards" db:"hidden_from_leaderboards"` // not ranked (dashboard and API), but still synced
+	AvatarURL              string          `json:"avatar_url" db:"avatar_url"`
+	LastSyncedAt           nulls.Time      `json:"last_synced_at" db:"last_synced_at"`
+	SyncCursor             nulls.Time      `json:"sync_cursor" db:"sync_cursor"`
+	CreatedAt              time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt              time.Time       `json:"updated_at" db:"updated_at"`
 }
 
 // String is not required by pop and may be deleted
@@ -44,6 +60,43 @@ func (u Users) String() string {
 	return string(ju)
 }
 
+// PublicUser is what may be shown of a User to other users, in any format: never its secrets (tokens) nor its email
+type PublicUser struct {
+	ID           uuid.UUID  `json:"id" xml:"id"`
+	Name         string     `json:"name" xml:"name"`
+	Provider     string     `json:"provider" xml:"provider"`
+	ProviderID   string     `json:"provider_id" xml:"provider_id"`
+	AvatarURL    string     `json:"avatar_url" xml:"avatar_url"`
+	LastSyncedAt nulls.Time `json:"last_synced_at" xml:"last_synced_at"`
+	NeedsReauth  bool       `json:"needs_reauth" xml:"needs_reauth"`
+	CreatedAt    time.Time  `json:"created_at" xml:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at" xml:"updated_at"`
+}
+
+// Public returns the user's PublicUser projection
+func (u User) Public() PublicUser {
+	return PublicUser{
+		ID:           u.ID,
+		Name:         u.Name,
+		Provider:     u.Provider,
+		ProviderID:   u.ProviderID,
+		AvatarURL:    u.AvatarURL,
+		LastSyncedAt: u.LastSyncedAt,
+		NeedsReauth:  u.NeedsReauth,
+		CreatedAt:    u.CreatedAt,
+		UpdatedAt:    u.UpdatedAt,
+	}
+}
+
+// Public returns the users' PublicUser projections
+func (u Users) Public() []PublicUser {
+	public := make([]PublicUser, 0, len(u))
+	for _, user := range u {
+		public = append(public, user.Public())
+	}
+	return public
+}
+
 // Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
 // This method is not required and may be deleted.
 func (u *User) Validate(tx *pop.Connection) (*validate.Errors, error) {
@@ -51,9 +104,77 @@ func (u *User) Validate(tx *pop.Connection) (*validate.Errors, error) {
 		&validators.StringIsPresent{Field: u.Name, Name: "Name"},
 		&validators.StringIsPresent{Field: u.Provider, Name: "Provider"},
 		&validators.StringIsPresent{Field: u.ProviderID, Name: "ProviderID"},
+		&validators.StringInclusion{Field: u.Role, Name: "Role", List: []string{UserRoleAdmin, UserRoleMember}},
 	), nil
 }
 
+// BeforeCreate makes new users members, unless a role is set
+func (u *User) BeforeCreate(tx *pop.Connection) error {
+	if u.Role == "" {
+		u.Role = UserRoleMember
+	}
+	return nil
+}
+
+// IsAdmin tells if the user is an admin
+func (u *User) IsAdmin() bool {
+	return u.Role == UserRoleAdmin
+}
+
+// CanManage tells if the user may act on other's data (eg: sync it): their own, or anyone's for admins
+func (u *User) CanManage(other *User) bool {
+	return u.ID == other.ID || u.IsAdmin()
+}
+
+// SetRole changes the user's role. The last admin can not stop being one
+func (u *User) SetRole(tx *pop.Connection, role string) error {
+	if u.IsAdmin() && role != UserRoleAdmin {
+		if err := u.ensureAnotherAdmin(tx); err != nil {
+			return err
+		}
+	}
+	u.Role = role
+	verrs, err := u.Validate(tx)
+	if err != nil {
+		return err
+	}
+	if verrs.HasAny() {
+		return verrs
+	}
+	return tx.UpdateColumns(u, "role", "updated_at")
+}
+
+// ensureAnotherAdmin returns ErrLastAdmin when there is no other admin
+func (u *User) ensureAnotherAdmin(tx *pop.Connection) error {
+	others, err := tx.Where("role = ? AND id <> ?", UserRoleAdmin, u.ID).Count(&User{})
+	if err != nil {
+		return err
+	}
+	if others == 0 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// SetHiddenFromLeaderboards hides (or shows again) the user from the leaderboards
+func (u *User) SetHiddenFromLeaderboards(tx *pop.Connection, hidden bool) error {
+	u.HiddenFromLeaderboards = hidden
+	return tx.UpdateColumns(u, "hidden_from_leaderboards", "updated_at")
+}
+
+// IsBootstrapAdmin tells if the user is an admin from ROAW_ADMINS (comma separated Strava athlete ids), promoted on login
+func (u *User) IsBootstrapAdmin() bool {
+	if u.Provider != "strava" {
+		return false
+	}
+	for _, providerID := range strings.Split(envy.Get("ROAW_ADMINS", ""), ",") {
+		if providerID = strings.TrimSpace(providerID); providerID != "" && providerID == u.ProviderID {
+			return true
+		}
+	}
+	return false
+}
+
 // ValidateCreate gets run every time you call "pop.ValidateAndCreate" method.
 // This method is not required and may be deleted.
 func (u *User) ValidateCreate(tx *pop.Connection) (*validate.Errors, error) {
@@ -66,8 +187,32 @@ func (u *User) ValidateUpdate(tx *pop.Connection) (*validate.Errors, error) {
 	return validate.NewErrors(), nil
 }
 
-// RefreshAccessToken will refresh user's accessToken and refreshToken auth
+// tokenExpiryMargin is how long before its expiry an access token is refreshed
+// (a sync may wait for Strava's rate limit window before using it)
+const tokenExpiryMargin = 30 * time.Minute
+
+// ErrLastAdmin is returned when the last admin would stop being one
+var ErrLastAdmin = errors.New("there must be at least one admin")
+
+// ErrNeedsReauth is returned when the user's refresh token was revoked (eg: the app was deauthorized on Strava).
+// The user must log in again
+var ErrNeedsReauth = errors.New("the user must log in again")
+
+// AccessTokenExpired tells if the access token is expired (or about to), or its expiry is unknown
+func (u *User) AccessTokenExpired() bool {
+	return u.AccessToken == "" || !u.TokenExpiresAt.Valid || time.Now().Add(tokenExpiryMargin).After(u.TokenExpiresAt.Time)
+}
+
+// RefreshAccessToken will refresh user's accessToken and refreshToken auth, when the access token is near expiry.
+// A revoked refresh token flags the user as NeedsReauth (and returns ErrNeedsReauth) until the next login
 func (u *User) RefreshAccessToken(tx *pop.Connection) error {
+	if u.NeedsReauth {
+		return fmt.Errorf("The accessToken for user '%s' could not be refreshed. %w", u.Name, ErrNeedsReauth)
+	}
+	if !u.AccessTokenExpired() {
+		return nil
+	}
+
 	// get Strava Auth provider
 	provider, ok := goth.GetProviders()[u.Provider]
 	if !ok {
@@ -75,44 +220,167 @@ func (u *User) RefreshAccessToken(tx *pop.Connection) error {
 	}
 
 	// refresh auth tokens
-	newTokens, err := provider.RefreshToken(u.RefreshToken)
+	newTokens, err := provider.RefreshToken(string(u.RefreshToken))
+	if revokedRefreshToken(err) {
+		u.NeedsReauth = true
+		if err := tx.UpdateColumns(u, "needs_reauth", "updated_at"); err != nil {
+			return err
+		}
+		return fmt.Errorf("The accessToken for user '%s' could not be refreshed. %w", u.Name, ErrNeedsReauth)
+	}
 	if err != nil {
 		return fmt.Errorf("The accessToken for user '%s' could not be refreshed. %w", u.Name, err)
 	}
 
-	if u.AccessToken != newTokens.AccessToken {
-		u.AccessToken = newTokens.AccessToken
-		u.RefreshToken = newTokens.RefreshToken
-		err = tx.Save(u)
+	u.AccessToken = EncryptedString(newTokens.AccessToken)
+	if newTokens.RefreshToken != "" {
+		u.RefreshToken = EncryptedString(newTokens.RefreshToken)
+	}
+	u.TokenExpiresAt = nulls.Time{}
+	if !newTokens.Expiry.IsZero() {
+		u.TokenExpiresAt = nulls.NewTime(newTokens.Expiry)
+	}
+	return tx.UpdateColumns(u, "access_token", "refresh_token", "token_expires_at", "updated_at")
+}
+
+// revokedRefreshToken tells if the provider refused to refresh the token (400 or 401 from the token endpoint),
+// instead of failing to reach it
+func revokedRefreshToken(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) || retrieveErr.Response == nil {
+		return false
+	}
+	return retrieveErr.Response.StatusCode == http.StatusBadRequest || retrieveErr.Response.StatusCode == http.StatusUnauthorized
+}
+
+// syncCursorMargin is subtracted from the sync cursor, so activities started on the same second are not skipped
+const syncCursorMargin = time.Second
+
+// SyncLatestActivities will fetch (all pages of) provider's activities started after the user's sync cursor
+// (within the SyncWindow)
+func (u *User) SyncLatestActivities(tx *pop.Connection) (SyncReport, error) {
+	after, before, err := SyncWindow(tx)
+	if err != nil {
+		return SyncReport{}, err
+	}
+	if u.SyncCursor.Valid && u.SyncCursor.Time.Add(-syncCursorMargin).After(after) {
+		after = u.SyncCursor.Time.Add(-syncCursorMargin)
+	}
+
+	return u.SyncActivities(tx, func(stravaAccessToken string) ([]stravaclient.Activity, error) {
+		return stravaclient.FetchActivitiesBetween(stravaAccessToken, after, before)
+	})
+}
+
+// SyncAllActivities will fetch all provider's activities (within the SyncWindow)
+// and soft delete the stored ones which are no longer on the provider (deleted or made private)
+func (u *User) SyncAllActivities(tx *pop.Connection) (SyncReport, error) {
+	start, end, err := SyncWindow(tx)
+	if err != nil {
+		return SyncReport{}, err
+	}
+
+	report, err := u.SyncActivities(tx, func(stravaAccessToken string) ([]stravaclient.Activity, error) {
+		return stravaclient.FetchActivitiesBetween(stravaAccessToken, start, end)
+	})
+	if err != nil {
+		return report, err
+	}
+
+	report.Removed, err = u.ReconcileActivities(tx, report.Fetched, start, end)
+	return report, err
+}
+
+// SyncReport describes what a sync did
+type SyncReport struct {
+	Fetched []string   // provider ids of the fetched activities
+	Removed Activities // activities soft deleted, as they are no longer on the provider
+}
+
+// String is a human readable summary of the sync (eg: for a SyncJob)
+func (r SyncReport) String() string {
+	summary := fmt.Sprintf("%d activities fetched", len(r.Fetched))
+	if len(r.Removed) == 0 {
+		return summary
 	}
 
-	return err
+	removed := make([]string, len(r.Removed))
+	for i, activity := range r.Removed {
+		removed[i] = fmt.Sprintf("%s (%s, %s)", activity.Name, activity.ProviderID, activity.Datetime.Format("2006-01-02"))
+	}
+	return fmt.Sprintf("%s, %d removed: %s", summary, len(r.Removed), strings.Join(removed, ", "))
 }
 
-// SyncActivities will fetch provider's activities and store them on database
-func (u *User) SyncActivities(tx *pop.Connection, syncFunction func(stravaAccessToken string) ([]swagger.SummaryActivity, error)) error {
+// SyncActivities will fetch provider's activities and store them on database.
+// On success, it moves the user's sync cursor to the latest activity start date seen.
+func (u *User) SyncActivities(tx *pop.Connection, syncFunction func(stravaAccessToken string) ([]stravaclient.Activity, error)) (SyncReport, error) {
+	report := SyncReport{}
 	if err := u.RefreshAccessToken(tx); err != nil {
-		return err
+		return report, err
 	}
 
-	stravaActivities, err := syncFunction(u.AccessToken)
+	stravaActivities, err := syncFunction(string(u.AccessToken))
 	if err != nil {
-		return fmt.Errorf("Could not fetch latestActivities for user %s. %w", u.Name, err)
+		return report, fmt.Errorf("Could not fetch latestActivities for user %s. %w", u.Name, err)
 	}
 
 	var errorStrings []string
+	cursor := u.SyncCursor
 	for _, stravaActivity := range stravaActivities {
 		activity := ParseStravaActivity(stravaActivity, *u)
+		report.Fetched = append(report.Fetched, activity.ProviderID)
 
 		if err := activity.CreateOrUpdate(tx); err != nil {
 			errorStrings = append(errorStrings, activity.ProviderID)
+			continue
+		}
+
+		if !cursor.Valid || stravaActivity.StartDate.After(cursor.Time) {
+			cursor = nulls.NewTime(stravaActivity.StartDate)
 		}
 	}
 
 	if len(errorStrings) > 0 {
-		return fmt.Errorf("Error processing activities: %s", strings.Join(errorStrings, ", "))
+		// keep the cursor, so failed activities are fetched again on the next sync
+		return report, fmt.Errorf("Error processing activities: %s", strings.Join(errorStrings, ", "))
 	}
-	return nil
+
+	u.SyncCursor = cursor
+	u.LastSyncedAt = nulls.NewTime(time.Now())
+	return report, tx.UpdateColumns(u, "last_synced_at", "sync_cursor", "updated_at")
+}
+
+// reconcileMargin narrows the reconciled window: activities are fetched by their UTC start date,
+// but stored with their local start date (which may be up to 14h apart)
+const reconcileMargin = 14 * time.Hour
+
+// ReconcileActivities soft deletes the user's stored activities (started within [start, end))
+// which are not among the fetched provider ids, returning them
+func (u *User) ReconcileActivities(tx *pop.Connection, fetchedProviderIDs []string, start, end time.Time) (Activities, error) {
+	stored := Activities{}
+	q := tx.Where("user_id = ? AND provider = ? AND deleted_at IS NULL", u.ID, u.Provider)
+	q = q.Where("datetime >= ? AND datetime < ?", start.Add(reconcileMargin), end.Add(-reconcileMargin))
+	if err := q.All(&stored); err != nil {
+		return nil, err
+	}
+
+	fetched := map[string]bool{}
+	for _, providerID := range fetchedProviderIDs {
+		fetched[providerID] = true
+	}
+
+	removed := Activities{}
+	for i := range stored {
+		if fetched[stored[i].ProviderID] {
+			continue
+		}
+		if err := stored[i].SoftDelete(tx); err != nil {
+			return removed, err
+		}
+		removed = append(removed, stored[i])
+	}
+
+	return removed, nil
 }
 
 // UserStats contains public User data with activities stats
@@ -126,12 +394,18 @@ type UserStats struct {
 	MostMovingDuration  int
 }
 
-// GetStats will return a UserStats for this User
-func (u *User) GetStats(tx *pop.Connection) (allActivitiesStats UserStats, validActivitiesStats UserStats, err error) {
+// GetStats will return a UserStats for this User (for the season's activities)
+func (u *User) GetStats(tx *pop.Connection, season *Season) (allActivitiesStats UserStats, validActivitiesStats UserStats, err error) {
+
+	rule, err := season.ChallengeRule(tx)
+	if err != nil {
+		return UserStats{}, UserStats{}, err
+	}
 
 	activities := Activities{}
 
-	q := tx.Q().Where("users.id = ?", u.ID)
+	q := tx.Q().Where("users.id = ?", u.ID).Where("activities.deleted_at IS NULL")
+	q = q.Where("activities.datetime >= ? AND activities.datetime < ?", season.StartsOn, season.EndsOn)
 	q = q.Join("users", "activities.user_id = users.id")
 	if err := q.All(&activities); err != nil {
 		return UserStats{}, UserStats{}, err
@@ -153,8 +427,7 @@ func (u *User) GetStats(tx *pop.Connection) (allActivitiesStats UserStats, valid
 			allActivitiesStats.MostMovingDuration = activity.MovingTime
 		}
 
-		// XXX: hardcoded 15min
-		if activity.Type == "Run" && activity.ElapsedTime > (60*15) {
+		if rule.Allows(activity) {
 			validActivitiesStats.Count++
 			validActivitiesStats.Distance += activity.Distance
 			validActivitiesStats.ElapsedDuration += activity.ElapsedTime
@@ -174,3 +447,68 @@ func (u *User) GetStats(tx *pop.Connection) (allActivitiesStats UserStats, valid
 
 	return
 }
+
+// DeleteWithActivities will remove the user and all its activities (and group memberships, weekly stats, API tokens,
+// sync jobs and Strava's webhook events, which may hold activity titles). Groups are never left without an admin (see leaveGroups)
+func (u *User) DeleteWithActivities(tx *pop.Connection) error {
+	if err := tx.RawQuery("DELETE FROM activities WHERE user_id = ?", u.ID).Exec(); err != nil {
+		return err
+	}
+	if err := tx.RawQuery("DELETE FROM api_tokens WHERE user_id = ?", u.ID).Exec(); err != nil {
+		return err
+	}
+	if err := tx.RawQuery("DELETE FROM sync_jobs WHERE user_id = ?", u.ID).Exec(); err != nil {
+		return err
+	}
+	if err := tx.RawQuery("DELETE FROM weekly_user_stats WHERE user_id = ?", u.ID).Exec(); err != nil {
+		return err
+	}
+	if err := tx.RawQuery("DELETE FROM webhook_events WHERE owner_id = ?", u.ProviderID).Exec(); err != nil {
+		return err
+	}
+	if err := leaveGroups(tx, u.ID); err != nil {
+		return err
+	}
+	return tx.Destroy(u)
+}
+
+// DeleteAccount deletes the user with all their data (see DeleteWithActivities), on their request (actor: the user),
+// an admin's (actor: the admin) or Strava's (actor: nil, the athlete deauthorized the app).
+// Unless Strava already knows, the app's access to the athlete's data is revoked first, with deauthorize
+// (failing to revoke it does not keep the user's data). The deletion is recorded on the audit log.
+// The last admin can not be deleted (ErrLastAdmin), but when Strava says so
+func (u *User) DeleteAccount(tx *pop.Connection, actor *User, deauthorize func(stravaAccessToken string) error) error {
+	if u.IsAdmin() && actor != nil {
+		if err := u.ensureAnotherAdmin(tx); err != nil {
+			return err
+		}
+	}
+
+	activities, err := tx.Where("user_id = ? AND deleted_at IS NULL", u.ID).Count(&Activity{})
+	if err != nil {
+		return err
+	}
+
+	details := []string{fmt.Sprintf("%d activities deleted", activities)}
+	switch {
+	case actor == nil:
+		details = append(details, "deauthorized on Strava")
+	case u.NeedsReauth:
+		details = append(details, "Strava access already revoked")
+	default:
+		err := u.RefreshAccessToken(tx)
+		if err == nil {
+			err = deauthorize(string(u.AccessToken))
+		}
+		if err != nil {
+			details = append(details, fmt.Sprintf("Strava access could not be revoked: %v", err))
+		} else {
+			details = append(details, "Strava access revoked")
+		}
+	}
+
+	if err := u.DeleteWithActivities(tx); err != nil {
+		return err
+	}
+	return recordAudit(tx, AuditUserDeleted, u, actor, strings.Join(details, ", "))
+}
@@ -1,5 +1,206 @@
 package models
 
+import (
+	"errors"
+	"time"
+
+	"github.com/gobuffalo/envy"
+	"github.com/gobuffalo/nulls"
+	"github.com/markbates/goth"
+	stravaclient "github.com/tcarreira/roaw2020/strava_client"
+	"github.com/tcarreira/roaw2020/strava_client/swagger"
+)
+
 func (ms *ModelSuite) Test_User() {
 	// ms.Fail("This test needs to be implemented!")
 }
+
+func (ms *ModelSuite) Test_User_Roles() {
+	envy.Set("ROAW_ADMINS", "1, 42")
+	defer envy.Set("ROAW_ADMINS", "")
+
+	user := &User{Name: "Athlete", Provider: "strava", ProviderID: "42"}
+	ms.NoError(DB.Create(user))
+	ms.Equal(UserRoleMember, user.Role)
+	ms.False(user.IsAdmin())
+	ms.True(user.IsBootstrapAdmin())
+
+	other := &User{Name: "Other", Provider: "strava", ProviderID: "43"}
+	ms.NoError(DB.Create(other))
+	ms.False(other.IsBootstrapAdmin())
+	ms.False(user.CanManage(other))
+	ms.True(user.CanManage(user))
+
+	user.Role = UserRoleAdmin
+	ms.True(user.CanManage(other))
+	ms.False(other.CanManage(user))
+
+	user.Role = "owner"
+	verrs, err := user.Validate(DB)
+	ms.NoError(err)
+	ms.True(verrs.HasAny())
+}
+
+func (ms *ModelSuite) Test_User_RefreshAccessToken() {
+	provider := goth.GetProviders()["strava"].(*fakeStravaProvider)
+	user := ms.createUser()
+
+	// unknown expiry: refreshed, and the expiry kept
+	refreshes := provider.refreshes
+	ms.NoError(user.RefreshAccessToken(DB))
+	ms.Equal(refreshes+1, provider.refreshes)
+	ms.NoError(DB.Reload(user))
+	ms.True(user.TokenExpiresAt.Valid)
+	ms.True(user.TokenExpiresAt.Time.After(time.Now().Add(time.Hour)))
+
+	// not expired: not refreshed
+	ms.NoError(user.RefreshAccessToken(DB))
+	ms.Equal(refreshes+1, provider.refreshes)
+
+	// near expiry: refreshed
+	user.TokenExpiresAt = nulls.NewTime(time.Now().Add(time.Minute))
+	ms.NoError(user.RefreshAccessToken(DB))
+	ms.Equal(refreshes+2, provider.refreshes)
+
+	// revoked: flagged, and not refreshed again until the next login
+	user.TokenExpiresAt = nulls.Time{}
+	user.RefreshToken = "revoked"
+	ms.True(errors.Is(user.RefreshAccessToken(DB), ErrNeedsReauth))
+	ms.NoError(DB.Reload(user))
+	ms.True(user.NeedsReauth)
+
+	ms.True(errors.Is(user.RefreshAccessToken(DB), ErrNeedsReauth))
+	ms.Equal(refreshes+3, provider.refreshes)
+}
+
+func (ms *ModelSuite) Test_User_SyncActivities_MovesCursor() {
+	user := ms.createUser()
+	runType := swagger.ActivityType("Run")
+	start := time.Date(2020, 6, 1, 8, 0, 0, 0, time.UTC)
+
+	fetched := []stravaclient.Activity{
+		{SummaryActivity: swagger.SummaryActivity{Id: 2, Name: "Second", Type_: &runType, StartDate: start.Add(time.Hour)}},
+		{SummaryActivity: swagger.SummaryActivity{Id: 1, Name: "First", Type_: &runType, StartDate: start}},
+	}
+	_, err := user.SyncActivities(DB, func(string) ([]stravaclient.Activity, error) { return fetched, nil })
+	ms.NoError(err)
+
+	ms.NoError(DB.Reload(user))
+	ms.True(user.SyncCursor.Valid)
+	ms.Equal(start.Add(time.Hour).Unix(), user.SyncCursor.Time.Unix())
+	ms.True(user.LastSyncedAt.Valid)
+
+	// nothing new: the cursor stays
+	_, err = user.SyncActivities(DB, func(string) ([]stravaclient.Activity, error) { return nil, nil })
+	ms.NoError(err)
+	ms.NoError(DB.Reload(user))
+	ms.Equal(start.Add(time.Hour).Unix(), user.SyncCursor.Time.Unix())
+}
+
+func (ms *ModelSuite) Test_User_ReconcileActivities() {
+	user := ms.createUser()
+	start, end := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for providerID, datetime := range map[string]time.Time{
+		"1": time.Date(2020, 3, 1, 8, 0, 0, 0, time.UTC), // still on Strava
+		"2": time.Date(2020, 4, 1, 8, 0, 0, 0, time.UTC), // deleted on Strava
+		"3": time.Date(2019, 4, 1, 8, 0, 0, 0, time.UTC), // outside the window
+	} {
+		activity := &Activity{UserID: user.ID, Provider: user.Provider, ProviderID: providerID, Name: "Run " + providerID, Type: "Run", Datetime: datetime}
+		ms.NoError(DB.Create(activity))
+	}
+
+	removed, err := user.ReconcileActivities(DB, []string{"1"}, start, end)
+	ms.NoError(err)
+	ms.Len(removed, 1)
+	ms.Equal("2", removed[0].ProviderID)
+
+	count, err := ms.DB.Where("user_id = ? AND deleted_at IS NULL", user.ID).Count(&Activity{})
+	ms.NoError(err)
+	ms.Equal(2, count)
+
+	report := SyncReport{Fetched: []string{"1"}, Removed: removed}
+	ms.Equal("1 activities fetched, 1 removed: Run 2 (2, 2020-04-01)", report.String())
+
+	// it shows up again: restored
+	activity := &Activity{UserID: user.ID, Provider: user.Provider, ProviderID: "2", Name: "Run 2", Type: "Run", Datetime: removed[0].Datetime}
+	ms.NoError(activity.CreateOrUpdate(DB))
+	ms.NoError(DB.Find(activity, removed[0].ID))
+	ms.False(activity.DeletedAt.Valid)
+}
+
+func (ms *ModelSuite) Test_User_DeleteAccount_DeauthorizeFails() {
+	user := ms.createUser()
+	admin := &User{Name: "Admin", Provider: "strava", ProviderID: "1", Role: UserRoleAdmin}
+	ms.NoError(DB.Create(admin))
+
+	// removed from Strava before: not counted
+	ms.NoError(DB.Create(&Activity{UserID: user.ID, Provider: user.Provider, ProviderID: "1", Name: "Run", Type: "Run", Datetime: time.Now(), DeletedAt: nulls.NewTime(time.Now())}))
+
+	// the user's data is deleted anyway
+	ms.NoError(user.DeleteAccount(DB, admin, func(string) error { return errors.New("strava is down") }))
+	exists, err := ms.DB.Where("id = ?", user.ID).Exists(&User{})
+	ms.NoError(err)
+	ms.False(exists)
+
+	audit := &AuditLog{}
+	ms.NoError(ms.DB.Where("user_id = ?", user.ID).First(audit))
+	ms.Equal(AuditUserDeleted, audit.Action)
+	ms.Equal(admin.ID, audit.ActorID.UUID)
+	ms.Equal("0 activities deleted, Strava access could not be revoked: strava is down", audit.Details)
+}
+
+func (ms *ModelSuite) Test_User_DeleteAccount_LastAdmin() {
+	admin := &User{Name: "Admin", Provider: "strava", ProviderID: "1", Role: UserRoleAdmin}
+	ms.NoError(DB.Create(admin))
+
+	ms.True(errors.Is(admin.DeleteAccount(DB, admin, func(string) error { return nil }), ErrLastAdmin))
+
+	// unless the athlete deauthorized the app on Strava
+	ms.NoError(admin.DeleteAccount(DB, nil, nil))
+	exists, err := ms.DB.Where("id = ?", admin.ID).Exists(&User{})
+	ms.NoError(err)
+	ms.False(exists)
+}
+
+func (ms *ModelSuite) Test_User_DeleteWithActivities_GroupsAndWebhookEvents() {
+	user := ms.createUser()
+	friend := &User{Name: "Friend", Provider: "strava", ProviderID: "43"}
+	ms.NoError(DB.Create(friend))
+	late := &User{Name: "Late", Provider: "strava", ProviderID: "44"}
+	ms.NoError(DB.Create(late))
+
+	team := &Group{Name: "Team"}
+	_, err := CreateGroup(DB, team, user)
+	ms.NoError(err)
+	ms.NoError(DB.Create(&GroupMembership{GroupID: team.ID, UserID: friend.ID, Role: GroupRoleMember, CreatedAt: time.Now().Add(time.Hour)}))
+	ms.NoError(DB.Create(&GroupMembership{GroupID: team.ID, UserID: late.ID, Role: GroupRoleMember, CreatedAt: time.Now().Add(2 * time.Hour)}))
+	alone := &Group{Name: "Alone"}
+	_, err = CreateGroup(DB, alone, user)
+	ms.NoError(err)
+
+	ms.NoError(DB.Create(&WebhookEvent{ObjectType: "activity", ObjectID: "7", AspectType: "update", OwnerID: user.ProviderID, Updates: `{"title":"Secret"}`}))
+	ms.NoError(DB.Create(&WebhookEvent{ObjectType: "activity", ObjectID: "8", AspectType: "update", OwnerID: friend.ProviderID, Updates: `{}`}))
+
+	ms.NoError(user.DeleteWithActivities(DB))
+
+	// the oldest member is the new admin
+	isAdmin, err := team.IsAdmin(DB, friend.ID)
+	ms.NoError(err)
+	ms.True(isAdmin)
+	isAdmin, err = team.IsAdmin(DB, late.ID)
+	ms.NoError(err)
+	ms.False(isAdmin)
+
+	// nobody left
+	exists, err := DB.Where("id = ?", alone.ID).Exists(&Group{})
+	ms.NoError(err)
+	ms.False(exists)
+
+	events, err := DB.Where("owner_id = ?", user.ProviderID).Count(&WebhookEvent{})
+	ms.NoError(err)
+	ms.Equal(0, events)
+	events, err = DB.Count(&WebhookEvent{})
+	ms.NoError(err)
+	ms.Equal(1, events)
+}
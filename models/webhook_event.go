@@ -0,0 +1,207 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gobuffalo/validate/v3"
+	"github.com/gobuffalo/validate/v3/validators"
+	"github.com/gofrs/uuid"
+	stravaclient "github.com/tcarreira/roaw2020/strava_client"
+)
+
+// WebhookEvent is used by pop to map your webhook_events database table to your go code.
+// Every event pushed by Strava is stored, so it can be replayed later.
+type WebhookEvent struct {
+	ID             uuid.UUID    `json:"id" db:"id"`
+	ObjectType     string       `json:"object_type" db:"object_type"`
+	ObjectID       string       `json:"object_id" db:"object_id"`
+	AspectType     string       `json:"aspect_type" db:"aspect_type"`
+	OwnerID        string       `json:"owner_id" db:"owner_id"`
+	SubscriptionID string       `json:"subscription_id" db:"subscription_id"`
+	EventTime      time.Time    `json:"event_time" db:"event_time"`
+	Updates        string       `json:"updates" db:"updates"`
+	ProcessedAt    nulls.Time   `json:"processed_at" db:"processed_at"`
+	Error          nulls.String `json:"error" db:"error"`
+	CreatedAt      time.Time    `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time    `json:"updated_at" db:"updated_at"`
+}
+
+// String is not required by pop and may be deleted
+func (w WebhookEvent) String() string {
+	jw, _ := json.Marshal(w)
+	return string(jw)
+}
+
+// WebhookEvents is not required by pop and may be deleted
+type WebhookEvents []WebhookEvent
+
+// String is not required by pop and may be deleted
+func (w WebhookEvents) String() string {
+	jw, _ := json.Marshal(w)
+	return string(jw)
+}
+
+// Validate gets run every time you call a "pop.Validate*" (pop.ValidateAndSave, pop.ValidateAndCreate, pop.ValidateAndUpdate) method.
+// This method is not required and may be deleted.
+func (w *WebhookEvent) Validate(tx *pop.Connection) (*validate.Errors, error) {
+	return validate.Validate(
+		&validators.StringIsPresent{Field: w.ObjectType, Name: "ObjectType"},
+		&validators.StringIsPresent{Field: w.ObjectID, Name: "ObjectID"},
+		&validators.StringIsPresent{Field: w.AspectType, Name: "AspectType"},
+		&validators.StringIsPresent{Field: w.OwnerID, Name: "OwnerID"},
+	), nil
+}
+
+// stravaWebhookPayload is the body Strava POSTs for every event
+// https://developers.strava.com/docs/webhooks/
+type stravaWebhookPayload struct {
+	ObjectType     string            `json:"object_type"`
+	ObjectID       int64             `json:"object_id"`
+	AspectType     string            `json:"aspect_type"`
+	OwnerID        int64             `json:"owner_id"`
+	SubscriptionID int64             `json:"subscription_id"`
+	EventTime      int64             `json:"event_time"`
+	Updates        map[string]string `json:"updates"`
+}
+
+// ParseStravaWebhookEvent converts Strava's webhook request body to a WebhookEvent
+func ParseStravaWebhookEvent(body []byte) (*WebhookEvent, error) {
+	payload := stravaWebhookPayload{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("Invalid webhook event. %w", err)
+	}
+
+	updates, err := json.Marshal(payload.Updates)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WebhookEvent{
+		ObjectType:     payload.ObjectType,
+		ObjectID:       strconv.FormatInt(payload.ObjectID, 10),
+		AspectType:     payload.AspectType,
+		OwnerID:        strconv.FormatInt(payload.OwnerID, 10),
+		SubscriptionID: strconv.FormatInt(payload.SubscriptionID, 10),
+		EventTime:      time.Unix(payload.EventTime, 0).UTC(),
+		Updates:        string(updates),
+	}, nil
+}
+
+// updatesMap returns the event's "updates" field (eg: {"title": "Messy", "authorized": "false"})
+func (w *WebhookEvent) updatesMap() map[string]string {
+	updates := map[string]string{}
+	json.Unmarshal([]byte(w.Updates), &updates)
+	return updates
+}
+
+// IsDeauthorization returns true when the athlete revoked access to this application
+func (w *WebhookEvent) IsDeauthorization() bool {
+	return w.ObjectType == "athlete" && w.updatesMap()["authorized"] == "false"
+}
+
+// Handle processes the event and records the outcome on the event log (ProcessedAt/Error).
+// The event is applied on a savepoint (see Process): when it fails, only the error is recorded.
+// It can be called again for an already stored event (replay). Outside a transaction, one is opened.
+func (w *WebhookEvent) Handle(tx *pop.Connection, fetchFunction func(stravaAccessToken string, activityID int64) (stravaclient.Activity, error)) error {
+	if tx.TX == nil {
+		return tx.Transaction(func(tx *pop.Connection) error {
+			return w.Handle(tx, fetchFunction)
+		})
+	}
+
+	w.ProcessedAt = nulls.NewTime(time.Now())
+	w.Error = nulls.String{}
+	if err := w.handle(tx, fetchFunction); err != nil {
+		w.Error = nulls.NewString(err.Error())
+	}
+
+	return tx.Save(w)
+}
+
+// handle finds the event's athlete (nothing to do when not one of ours) and applies the event on a savepoint.
+// The athlete's access token is refreshed before: the tokens Strava rotates are kept even if applying fails
+func (w *WebhookEvent) handle(tx *pop.Connection, fetchFunction func(stravaAccessToken string, activityID int64) (stravaclient.Activity, error)) error {
+	user := &User{}
+	err := tx.Where("provider = ? and provider_id = ?", "strava", w.OwnerID).First(user)
+	if errors.Is(err, sql.ErrNoRows) {
+		// not one of our athletes. Nothing to do
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if w.ObjectType == "activity" && w.AspectType != "delete" {
+		if err := user.RefreshAccessToken(tx); err != nil {
+			return err
+		}
+	}
+
+	return savepoint(tx, "webhook_event", func() error {
+		return w.Process(tx, user, fetchFunction)
+	})
+}
+
+// Process applies the event for its athlete (user): fetches (create/update) or removes (delete) the affected activity,
+// or removes the athlete's data when the application was deauthorized. The user's access token must be fresh
+func (w *WebhookEvent) Process(tx *pop.Connection, user *User, fetchFunction func(stravaAccessToken string, activityID int64) (stravaclient.Activity, error)) error {
+	switch w.ObjectType {
+	case "athlete":
+		if w.IsDeauthorization() {
+			return user.DeleteAccount(tx, nil, nil)
+		}
+		return nil
+
+	case "activity":
+		switch w.AspectType {
+		case "create", "update":
+			return w.fetchActivity(tx, user, fetchFunction)
+		case "delete":
+			return w.deleteActivity(tx, user)
+		}
+	}
+
+	return fmt.Errorf("Unknown webhook event %s/%s", w.ObjectType, w.AspectType)
+}
+
+func (w *WebhookEvent) fetchActivity(tx *pop.Connection, user *User, fetchFunction func(stravaAccessToken string, activityID int64) (stravaclient.Activity, error)) error {
+	activityID, err := strconv.ParseInt(w.ObjectID, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	stravaActivity, err := fetchFunction(string(user.AccessToken), activityID)
+	if errors.Is(err, stravaclient.ErrActivityNotFound) {
+		// eg: the activity was made private
+		return w.deleteActivity(tx, user)
+	}
+	if err != nil {
+		return fmt.Errorf("Could not fetch activity %s for user %s. %w", w.ObjectID, user.Name, err)
+	}
+
+	return ParseStravaActivity(stravaActivity, *user).CreateOrUpdate(tx)
+}
+
+// deleteActivity soft deletes the event's activity (refreshing its week's stats), if it was not already
+func (w *WebhookEvent) deleteActivity(tx *pop.Connection, user *User) error {
+	activities := Activities{}
+	err := tx.Where("user_id = ? AND provider = ? AND provider_id = ? AND deleted_at IS NULL", user.ID, user.Provider, w.ObjectID).
+		All(&activities)
+	if err != nil {
+		return err
+	}
+
+	for i := range activities {
+		if err := activities[i].SoftDelete(tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"errors"
+
+	"github.com/gobuffalo/pop/v5"
+
+	stravaclient "github.com/tcarreira/roaw2020/strava_client"
+)
+
+func (ms *ModelSuite) Test_Savepoint_RolledBackOnError() {
+	kept := &User{Name: "Kept", Provider: "strava", ProviderID: "1"}
+	discarded := &User{Name: "Discarded", Provider: "strava", ProviderID: "2"}
+
+	ms.NoError(DB.Transaction(func(tx *pop.Connection) error {
+		ms.NoError(tx.Create(kept))
+		err := savepoint(tx, "test", func() error {
+			ms.NoError(tx.Create(discarded))
+			return errors.New("failed")
+		})
+		ms.EqualError(err, "failed")
+		return nil
+	}))
+
+	count, err := DB.Where("provider_id in (?, ?)", "1", "2").Count(&User{})
+	ms.NoError(err)
+	ms.Equal(1, count)
+}
+
+func (ms *ModelSuite) Test_WebhookEvent_Handle_RecordsError() {
+	user := ms.createUser()
+	event := &WebhookEvent{ObjectType: "activity", ObjectID: "7", AspectType: "create", OwnerID: user.ProviderID, SubscriptionID: "1"}
+	ms.NoError(DB.Create(event))
+
+	ms.NoError(event.Handle(DB, func(string, int64) (stravaclient.Activity, error) {
+		return stravaclient.Activity{}, errors.New("strava is down")
+	}))
+
+	ms.NoError(DB.Reload(event))
+	ms.True(event.ProcessedAt.Valid)
+	ms.Contains(event.Error.String, "strava is down")
+
+	// the tokens Strava rotated were kept
+	ms.NoError(DB.Reload(user))
+	ms.True(user.TokenExpiresAt.Valid)
+}
+
+func (ms *ModelSuite) Test_WebhookEvent_Handle_UnknownAthlete() {
+	event := &WebhookEvent{ObjectType: "activity", ObjectID: "7", AspectType: "create", OwnerID: "404", SubscriptionID: "1"}
+	ms.NoError(DB.Create(event))
+
+	ms.NoError(event.Handle(DB, func(string, int64) (stravaclient.Activity, error) {
+		return stravaclient.Activity{}, errors.New("not fetched")
+	}))
+	ms.True(event.ProcessedAt.Valid)
+	ms.False(event.Error.Valid)
+}
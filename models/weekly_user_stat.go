@@ -0,0 +1,197 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gofrs/uuid"
+)
+
+// WeeklyUserStat is used by pop to map your weekly_user_stats database table to your go code.
+// It aggregates the activities of a user on a season's week, valid for the season's ChallengeRule,
+// so the dashboard does not aggregate every activity on each request.
+// It is refreshed whenever an activity is written (see RefreshWeeklyUserStats) and may be rebuilt (see RebuildWeeklyUserStats).
+type WeeklyUserStat struct {
+	ID             uuid.UUID `json:"id" db:"id"`
+	UserID         uuid.UUID `json:"user_id" db:"user_id"`
+	SeasonID       uuid.UUID `json:"season_id" db:"season_id"` // see Season.StatsID
+	Week           int       `json:"week" db:"week"`           // see Season.Weeks
+	Distance       int       `json:"distance" db:"distance"`
+	ActivityCount  int       `json:"activity_count" db:"activity_count"`
+	MovingTime     int       `json:"moving_time" db:"moving_time"`
+	ElapsedTime    int       `json:"elapsed_time" db:"elapsed_time"`
+	ElevationGain  float64   `json:"elevation_gain" db:"elevation_gain"`
+	MaxDistance    int       `json:"max_distance" db:"max_distance"`
+	MaxElapsedTime int       `json:"max_elapsed_time" db:"max_elapsed_time"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// String is not required by pop and may be deleted
+func (w WeeklyUserStat) String() string {
+	jw, _ := json.Marshal(w)
+	return string(jw)
+}
+
+// WeeklyUserStats is not required by pop and may be deleted
+type WeeklyUserStats []WeeklyUserStat
+
+// String is not required by pop and may be deleted
+func (w WeeklyUserStats) String() string {
+	jw, _ := json.Marshal(w)
+	return string(jw)
+}
+
+// add aggregates the activity into the week's stats
+func (w *WeeklyUserStat) add(a Activity) {
+	w.Distance += a.Distance
+	w.ActivityCount++
+	w.MovingTime += a.MovingTime
+	w.ElapsedTime += a.ElapsedTime
+	w.ElevationGain += a.TotalElevationGain
+	if a.Distance > w.MaxDistance {
+		w.MaxDistance = a.Distance
+	}
+	if a.ElapsedTime > w.MaxElapsedTime {
+		w.MaxElapsedTime = a.ElapsedTime
+	}
+}
+
+// AggregateWeeklyUserStats aggregates the activities by user and week: only the season's activities valid for the rule.
+// The stats are not stored (see RefreshWeeklyUserStats)
+func AggregateWeeklyUserStats(season *Season, rule *ChallengeRule, activities Activities) WeeklyUserStats {
+	weeks := season.Weeks()
+	stats := WeeklyUserStats{}
+	index := map[string]int{}
+
+	for _, activity := range activities {
+		if !season.Contains(activity) || !rule.Allows(activity) {
+			continue
+		}
+
+		week := weeks.Of(activity.Datetime)
+		key := fmt.Sprintf("%s/%d", activity.UserID, week)
+		i, ok := index[key]
+		if !ok {
+			i = len(stats)
+			index[key] = i
+			stats = append(stats, WeeklyUserStat{UserID: activity.UserID, SeasonID: season.StatsID(), Week: week})
+		}
+		stats[i].add(activity)
+	}
+	return stats
+}
+
+// refreshWeek recomputes the user's stats of a season's week (there is no row for a week without valid activities)
+func refreshWeek(tx *pop.Connection, season *Season, rule *ChallengeRule, userID uuid.UUID, week int) error {
+	err := tx.RawQuery("DELETE FROM weekly_user_stats WHERE season_id = ? AND user_id = ? AND week = ?",
+		season.StatsID(), userID, week).Exec()
+	if err != nil {
+		return err
+	}
+
+	// a day of margin on both sides: activities are bucketed by their wall clock date
+	start := season.Weeks().StartsOn(week)
+	activities := Activities{}
+	err = tx.Where("user_id = ? AND deleted_at IS NULL", userID).
+		Where("datetime >= ? AND datetime < ?", start.AddDate(0, 0, -1), start.AddDate(0, 0, 8)).
+		All(&activities)
+	if err != nil {
+		return err
+	}
+
+	for _, stat := range AggregateWeeklyUserStats(season, rule, activities) {
+		if stat.Week == week {
+			return tx.Create(&stat)
+		}
+	}
+	return nil
+}
+
+// RefreshWeeklyUserStats recomputes the user's weeks (of every season) of the given datetimes:
+// an activity's datetime after it is written, and its previous datetime when it moved
+func RefreshWeeklyUserStats(tx *pop.Connection, userID uuid.UUID, datetimes ...time.Time) error {
+	seasons, err := AllSeasons(tx)
+	if err != nil {
+		return err
+	}
+
+	for i := range seasons {
+		season := &seasons[i]
+		weeks := season.Weeks()
+
+		var rule *ChallengeRule
+		refreshed := map[int]bool{}
+		for _, datetime := range datetimes {
+			week := weeks.Of(datetime)
+			if week < 1 || week > weeks.Count() || refreshed[week] {
+				continue
+			}
+
+			if rule == nil {
+				if rule, err = season.ChallengeRule(tx); err != nil {
+					return err
+				}
+			}
+			if err := refreshWeek(tx, season, rule, userID, week); err != nil {
+				return err
+			}
+			refreshed[week] = true
+		}
+	}
+	return nil
+}
+
+// RebuildWeeklyUserStats recomputes every season's WeeklyUserStats from scratch
+// (eg: after changing a season, a challenge rule or ROAW_WEEK_START). It returns the number of stored weeks
+func RebuildWeeklyUserStats(tx *pop.Connection) (int, error) {
+	if err := tx.RawQuery("DELETE FROM weekly_user_stats").Exec(); err != nil {
+		return 0, err
+	}
+
+	seasons, err := AllSeasons(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := range seasons {
+		season := &seasons[i]
+		rule, err := season.ChallengeRule(tx)
+		if err != nil {
+			return count, err
+		}
+
+		activities := Activities{}
+		err = tx.Where("deleted_at IS NULL AND datetime >= ? AND datetime < ?", season.StartsOn, season.EndsOn).All(&activities)
+		if err != nil {
+			return count, err
+		}
+
+		for _, stat := range AggregateWeeklyUserStats(season, rule, activities) {
+			if err := tx.Create(&stat); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	return count, nil
+}
+
+// BackfillWeeklyUserStats rebuilds the WeeklyUserStats when none is stored but there are activities
+// (eg: on the first start after upgrading to the weekly_user_stats table).
+// It returns the number of stored weeks (0 when there was nothing to do)
+func BackfillWeeklyUserStats(tx *pop.Connection) (int, error) {
+	stored, err := tx.Count(&WeeklyUserStat{})
+	if err != nil || stored > 0 {
+		return 0, err
+	}
+	activities, err := tx.Where("deleted_at IS NULL").Count(&Activity{})
+	if err != nil || activities == 0 {
+		return 0, err
+	}
+
+	return RebuildWeeklyUserStats(tx)
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"time"
+)
+
+// weeklyUserStats returns the stored stats of the user's season, by week
+func (ms *ModelSuite) weeklyUserStats(user *User, season *Season) map[int]WeeklyUserStat {
+	stats := WeeklyUserStats{}
+	ms.NoError(ms.DB.Where("user_id = ? AND season_id = ?", user.ID, season.StatsID()).All(&stats))
+
+	weeks := map[int]WeeklyUserStat{}
+	for _, stat := range stats {
+		weeks[stat.Week] = stat
+	}
+	return weeks
+}
+
+func (ms *ModelSuite) Test_WeeklyUserStats_Refresh() {
+	user := ms.createUser()
+	season := &Season{
+		Name:     "2020",
+		StartsOn: time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC),
+		EndsOn:   time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}
+	ms.NoError(DB.Create(season))
+
+	activity := func(providerID string, datetime time.Time, distance int) *Activity {
+		return &Activity{
+			UserID: user.ID, Provider: user.Provider, ProviderID: providerID, Name: "Run " + providerID, Type: "Run",
+			Datetime: datetime, Distance: distance, MovingTime: 1500, ElapsedTime: 1800, TotalElevationGain: 10.5,
+		}
+	}
+	// week 2 starts on Monday, 2020-01-06
+	ms.NoError(activity("1", time.Date(2020, time.January, 6, 8, 0, 0, 0, time.UTC), 5000).CreateOrUpdate(DB))
+	ms.NoError(activity("2", time.Date(2020, time.January, 12, 23, 0, 0, 0, time.UTC), 8000).CreateOrUpdate(DB))
+	ms.NoError(activity("3", time.Date(2019, time.December, 31, 8, 0, 0, 0, time.UTC), 9000).CreateOrUpdate(DB)) // other season
+	ms.NoError((&Activity{UserID: user.ID, Provider: user.Provider, ProviderID: "4", Name: "Ride", Type: "Ride",
+		Datetime: time.Date(2020, time.January, 7, 8, 0, 0, 0, time.UTC), Distance: 40000, ElapsedTime: 3600}).CreateOrUpdate(DB)) // not a run
+
+	weeks := ms.weeklyUserStats(user, season)
+	ms.Len(weeks, 1)
+	ms.Equal(13000, weeks[2].Distance)
+	ms.Equal(2, weeks[2].ActivityCount)
+	ms.Equal(3000, weeks[2].MovingTime)
+	ms.Equal(3600, weeks[2].ElapsedTime)
+	ms.Equal(21.0, weeks[2].ElevationGain)
+	ms.Equal(8000, weeks[2].MaxDistance)
+
+	// moved to the next week
+	ms.NoError(activity("2", time.Date(2020, time.January, 13, 8, 0, 0, 0, time.UTC), 8000).CreateOrUpdate(DB))
+	weeks = ms.weeklyUserStats(user, season)
+	ms.Equal(5000, weeks[2].Distance)
+	ms.Equal(8000, weeks[3].Distance)
+
+	// deleted
+	moved := &Activity{}
+	ms.NoError(DB.Where("provider_id = ?", "2").First(moved))
+	ms.NoError(moved.SoftDelete(DB))
+	weeks = ms.weeklyUserStats(user, season)
+	ms.Len(weeks, 1)
+	ms.Equal(1, weeks[2].ActivityCount)
+
+	// a rebuild gets the same stats
+	count, err := RebuildWeeklyUserStats(DB)
+	ms.NoError(err)
+	ms.Equal(1, count)
+	rebuilt := ms.weeklyUserStats(user, season)
+	ms.Equal(weeks[2].Distance, rebuilt[2].Distance)
+	ms.Equal(weeks[2].ActivityCount, rebuilt[2].ActivityCount)
+}
+
+func (ms *ModelSuite) Test_WeeklyUserStats_Backfill() {
+	user := ms.createUser()
+
+	// no activities: nothing to do
+	count, err := BackfillWeeklyUserStats(DB)
+	ms.NoError(err)
+	ms.Equal(0, count)
+
+	// activities stored before the weekly stats existed
+	ms.NoError(DB.Create(&Activity{UserID: user.ID, Provider: user.Provider, ProviderID: "1", Name: "Run", Type: "Run",
+		Datetime: DefaultSeason().StartsOn.AddDate(0, 0, 10), Distance: 5000, MovingTime: 1500, ElapsedTime: 1800}))
+	count, err = BackfillWeeklyUserStats(DB)
+	ms.NoError(err)
+	ms.Equal(1, count)
+
+	// already filled
+	count, err = BackfillWeeklyUserStats(DB)
+	ms.NoError(err)
+	ms.Equal(0, count)
+	stored, err := DB.Count(&WeeklyUserStat{})
+	ms.NoError(err)
+	ms.Equal(1, stored)
+}
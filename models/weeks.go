@@ -0,0 +1,93 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/envy"
+)
+
+const day = 24 * time.Hour
+
+// WeekStart returns the first day of the weeks: ROAW_WEEK_START (monday or sunday, default: monday)
+func WeekStart() time.Weekday {
+	if strings.EqualFold(envy.Get("ROAW_WEEK_START", ""), "sunday") {
+		return time.Sunday
+	}
+	return time.Monday
+}
+
+// date returns the wall clock date of t (as UTC midnight), ignoring its location.
+// Activities' datetimes are Strava's StartDateLocal: the wall clock of the athlete's timezone
+func date(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// Weeks buckets the days of a season into weeks, starting on a configurable weekday.
+// Week 1 is the week of the season's first day (it may start before the season, as its last week may end after).
+// Days are wall clock dates, so every activity is bucketed in its own (athlete's) timezone
+type Weeks struct {
+	start     time.Time // first day of week 1
+	startsOn  time.Time // first day of the season
+	endsOn    time.Time // day after the season
+	weekStart time.Weekday
+}
+
+// NewWeeks returns the weeks of the season [startsOn, endsOn), starting on weekStart
+func NewWeeks(startsOn, endsOn time.Time, weekStart time.Weekday) Weeks {
+	startsOn, endsOn = date(startsOn), date(endsOn)
+	offset := (int(startsOn.Weekday()) - int(weekStart) + 7) % 7
+	return Weeks{
+		start:     startsOn.AddDate(0, 0, -offset),
+		startsOn:  startsOn,
+		endsOn:    endsOn,
+		weekStart: weekStart,
+	}
+}
+
+// Count returns the number of weeks of the season
+func (w Weeks) Count() int {
+	if !w.endsOn.After(w.startsOn) {
+		return 0
+	}
+	return w.Of(w.endsOn.Add(-day))
+}
+
+// Of returns the week of t: 1 to Count() during the season, 0 before and Count()+1 after it
+func (w Weeks) Of(t time.Time) int {
+	d := date(t)
+	if d.Before(w.startsOn) {
+		return 0
+	}
+	if !d.Before(w.endsOn) {
+		return w.Count() + 1
+	}
+	return int(d.Sub(w.start)/day)/7 + 1
+}
+
+// StartsOn returns the first day of a week
+func (w Weeks) StartsOn(week int) time.Time {
+	return w.start.AddDate(0, 0, 7*(week-1))
+}
+
+// WeekLabel describes a week of a season
+type WeekLabel struct {
+	Week     int       `json:"week"`
+	StartsOn time.Time `json:"starts_on"`
+	Label    string    `json:"label"`
+}
+
+// Labels returns every week of the season (eg: to label the charts' x axis)
+func (w Weeks) Labels() []WeekLabel {
+	labels := []WeekLabel{}
+	for week := 1; week <= w.Count(); week++ {
+		startsOn := w.StartsOn(week)
+		labels = append(labels, WeekLabel{
+			Week:     week,
+			StartsOn: startsOn,
+			Label:    fmt.Sprintf("W%d %s", week, startsOn.Format("Jan 2")),
+		})
+	}
+	return labels
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+)
+
+func (ms *ModelSuite) Test_Weeks() {
+	day := func(date string) time.Time {
+		d, _ := time.Parse("2006-01-02", date)
+		return d
+	}
+	// 2021-01-01 is a Friday
+	season := func(weekStart time.Weekday) Weeks {
+		return NewWeeks(day("2021-01-01"), day("2022-01-01"), weekStart)
+	}
+
+	for weekStart, expected := range map[time.Weekday]map[string]int{
+		time.Monday: {
+			"2020-12-31": 0,  // before the season
+			"2021-01-01": 1,  // partial first week (Friday to Sunday)
+			"2021-01-03": 1,  // Sunday
+			"2021-01-04": 2,  // first Monday
+			"2021-06-20": 25, // Sunday
+			"2021-06-21": 26, // Monday
+			"2021-12-31": 53, // partial last week
+			"2022-01-01": 54, // after the season
+		},
+		time.Sunday: {
+			"2021-01-01": 1,
+			"2021-01-02": 1, // Saturday
+			"2021-01-03": 2, // first Sunday
+			"2021-06-20": 26,
+			"2021-06-21": 26,
+			"2021-12-31": 53,
+		},
+	} {
+		weeks := season(weekStart)
+		ms.Equal(53, weeks.Count(), weekStart)
+		for date, week := range expected {
+			ms.Equal(week, weeks.Of(day(date)), "%s %s", weekStart, date)
+		}
+	}
+
+	// late on Sunday, in the athlete's timezone (activities' datetimes are their wall clock)
+	lisbonSunday := time.Date(2021, time.January, 3, 23, 30, 0, 0, time.FixedZone("WET", 0))
+	tokyoSunday := time.Date(2021, time.January, 3, 23, 30, 0, 0, time.FixedZone("JST", 9*3600))
+	weeks := season(time.Monday)
+	ms.Equal(1, weeks.Of(lisbonSunday))
+	ms.Equal(1, weeks.Of(tokyoSunday))
+
+	labels := weeks.Labels()
+	ms.Len(labels, 53)
+	ms.Equal("W1 Dec 28", labels[0].Label)
+	ms.Equal(WeekLabel{Week: 2, StartsOn: day("2021-01-04"), Label: "W2 Jan 4"}, labels[1])
+}
@@ -0,0 +1,44 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Parse_Next(t *testing.T) {
+	from := time.Date(2020, 6, 15, 10, 17, 30, 0, time.UTC) // Monday
+
+	tests := []struct {
+		spec string
+		next time.Time
+	}{
+		{"@every 1h", from.Add(time.Hour)},
+		{"@hourly", time.Date(2020, 6, 15, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2020, 6, 16, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2020, 6, 21, 0, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2020, 6, 15, 10, 30, 0, 0, time.UTC)},
+		{"5 6-22/4 * * *", time.Date(2020, 6, 15, 14, 5, 0, 0, time.UTC)},
+		{"0 3 * * 6,7", time.Date(2020, 6, 20, 3, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * 1", time.Date(2020, 6, 22, 0, 0, 0, 0, time.UTC)}, // day-of-month OR day-of-week
+	}
+
+	for _, tt := range tests {
+		schedule, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q) unexpected error: %v", tt.spec, err)
+			continue
+		}
+		if next := schedule.Next(from); !next.Equal(tt.next) {
+			t.Errorf("Parse(%q).Next() = %v, want %v", tt.spec, next, tt.next)
+		}
+	}
+}
+
+func Test_Parse_Invalid(t *testing.T) {
+	for _, spec := range []string{"", "@every 10s", "@every soon", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) expected an error", spec)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+// Package secrets encrypts values at rest (eg: the users' Strava tokens) with envelope encryption:
+// each value is encrypted (AES-256-GCM) with its own random data key, which is encrypted with a key encryption key.
+//
+// Key encryption keys are read from ROAW_ENCRYPTION_KEYS: "<id>:<base64 32 bytes key>", comma separated.
+// The first key encrypts, every key decrypts. To rotate, prepend a new key, re-encrypt the stored values
+// (buffalo task secrets:reencrypt) and then remove the old key.
+//
+// Without keys, values are kept in plaintext. Keys are required in production (GO_ENV=production, see Check).
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/gobuffalo/envy"
+)
+
+// prefix starts every encrypted value: "enc:v1:<key id>:<encrypted data key>:<encrypted value>"
+const prefix = "enc:v1:"
+
+// KeySize is the size of the keys (AES-256)
+const KeySize = 32
+
+// ErrUnknownKey is returned when a value was encrypted with a key which is not on the Keyring (anymore)
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// ErrNoKeys is returned in production without ROAW_ENCRYPTION_KEYS: values are never kept in plaintext there
+var ErrNoKeys = errors.New("ROAW_ENCRYPTION_KEYS is required in production")
+
+var encoding = base64.RawURLEncoding
+
+// Keyring holds the key encryption keys, by id
+type Keyring struct {
+	primary string // encrypts (empty: values are kept in plaintext)
+	keys    map[string][]byte
+	err     error // of ParseKeyring, returned on every use
+}
+
+// ParseKeyring parses the keys: "<id>:<base64 key>", comma separated (the first one is the primary key)
+func ParseKeyring(spec string) (*Keyring, error) {
+	k := &Keyring{keys: map[string][]byte{}}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid encryption key %q (expected <id>:<base64 key>)", entry)
+		}
+		id := parts[0]
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil || len(key) != KeySize {
+			return nil, fmt.Errorf("invalid encryption key %q (expected %d bytes, base64 encoded)", id, KeySize)
+		}
+		if _, ok := k.keys[id]; ok {
+			return nil, fmt.Errorf("duplicated encryption key %q", id)
+		}
+
+		k.keys[id] = key
+		if k.primary == "" {
+			k.primary = id
+		}
+	}
+	return k, nil
+}
+
+// GenerateKey returns a new random key, as expected by ParseKeyring ("<id>:<base64 key>")
+func GenerateKey(id string) (string, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return id + ":" + base64.StdEncoding.EncodeToString(key), nil
+}
+
+// Enabled tells if values are encrypted (there is a primary key)
+func (k *Keyring) Enabled() bool {
+	return k.primary != ""
+}
+
+// seal encrypts plaintext with key (AES-GCM), prefixed by its nonce
+func seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open decrypts the output of seal
+func open(key, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("encrypted value too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt encrypts the value with a new data key, encrypted with the primary key.
+// Empty values (and every value, without keys) are not encrypted
+func (k *Keyring) Encrypt(value string) (string, error) {
+	if k.err != nil {
+		return "", k.err
+	}
+	if value == "" || !k.Enabled() {
+		return value, nil
+	}
+
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	encryptedKey, err := seal(k.keys[k.primary], dataKey)
+	if err != nil {
+		return "", err
+	}
+	encryptedValue, err := seal(dataKey, []byte(value))
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + k.primary + ":" + encoding.EncodeToString(encryptedKey) + ":" + encoding.EncodeToString(encryptedValue), nil
+}
+
+// Decrypt decrypts a value encrypted with any key of the Keyring. Values not encrypted (eg: stored before keys were set) are returned as they are
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if k.err != nil {
+		return "", k.err
+	}
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("malformed encrypted value")
+	}
+	key, ok := k.keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownKey, parts[0])
+	}
+
+	encryptedKey, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	encryptedValue, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := open(key, encryptedKey)
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt the data key: %w", err)
+	}
+	plaintext, err := open(dataKey, encryptedValue)
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt the value: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// NeedsReencryption tells if a stored value is not encrypted with the primary key (plaintext, or an older key)
+func (k *Keyring) NeedsReencryption(value string) bool {
+	if value == "" || !k.Enabled() {
+		return false
+	}
+	return !strings.HasPrefix(value, prefix+k.primary+":")
+}
+
+// IsEncrypted tells if a stored value is encrypted (see Keyring.Encrypt)
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+var (
+	keyringMu sync.RWMutex
+	keyring   *Keyring
+)
+
+// Default returns the Keyring in use: the one set with Use, or ROAW_ENCRYPTION_KEYS.
+// An invalid ROAW_ENCRYPTION_KEYS (or none, in production) makes every Encrypt and Decrypt fail
+func Default() *Keyring {
+	keyringMu.RLock()
+	k := keyring
+	keyringMu.RUnlock()
+	if k != nil {
+		return k
+	}
+
+	k = fromEnv()
+	Use(k)
+	return k
+}
+
+// fromEnv returns the Keyring of ROAW_ENCRYPTION_KEYS
+func fromEnv() *Keyring {
+	k, err := ParseKeyring(envy.Get("ROAW_ENCRYPTION_KEYS", ""))
+	switch {
+	case err != nil:
+		return &Keyring{err: fmt.Errorf("ROAW_ENCRYPTION_KEYS: %w", err)}
+	case !k.Enabled() && envy.Get("GO_ENV", "development") == "production":
+		return &Keyring{err: ErrNoKeys}
+	}
+	return k
+}
+
+// Check returns the error of the Default Keyring (eg: an invalid ROAW_ENCRYPTION_KEYS), to fail on startup instead of on use
+func Check() error {
+	return Default().err
+}
+
+// Use replaces the Keyring (eg: on tests)
+func Use(k *Keyring) {
+	keyringMu.Lock()
+	defer keyringMu.Unlock()
+	keyring = k
+}
+
+// Encrypt encrypts the value with the Default Keyring
+func Encrypt(value string) (string, error) {
+	return Default().Encrypt(value)
+}
+
+// Decrypt decrypts the value with the Default Keyring
+func Decrypt(value string) (string, error) {
+	return Default().Decrypt(value)
+}
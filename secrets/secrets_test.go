@@ -0,0 +1,125 @@
+package secrets
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gobuffalo/envy"
+)
+
+func mustKeyring(t *testing.T, ids ...string) (*Keyring, []string) {
+	t.Helper()
+	keys := []string{}
+	for _, id := range ids {
+		key, err := GenerateKey(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	k, err := ParseKeyring(strings.Join(keys, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k, keys
+}
+
+func TestKeyring(t *testing.T) {
+	k, keys := mustKeyring(t, "2020-06")
+
+	encrypted, err := k.Encrypt("access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(encrypted) || strings.Contains(encrypted, "access") {
+		t.Errorf("got %q, expected an encrypted value", encrypted)
+	}
+	if again, _ := k.Encrypt("access"); again == encrypted {
+		t.Errorf("every encryption must use a new data key")
+	}
+	if decrypted, err := k.Decrypt(encrypted); err != nil || decrypted != "access" {
+		t.Errorf("got %q (%v), expected access", decrypted, err)
+	}
+	if k.NeedsReencryption(encrypted) {
+		t.Errorf("a value encrypted with the primary key does not need re-encryption")
+	}
+
+	// plaintext (stored before the keys were set)
+	if decrypted, err := k.Decrypt("plain"); err != nil || decrypted != "plain" {
+		t.Errorf("got %q (%v), expected plain", decrypted, err)
+	}
+	if !k.NeedsReencryption("plain") {
+		t.Errorf("a plaintext value needs encryption")
+	}
+
+	// rotated: a new primary key, the old one still decrypts
+	newKey, err := GenerateKey("2020-07")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := ParseKeyring(newKey + "," + keys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted, err := rotated.Decrypt(encrypted); err != nil || decrypted != "access" {
+		t.Errorf("got %q (%v), expected access", decrypted, err)
+	}
+	if !rotated.NeedsReencryption(encrypted) {
+		t.Errorf("a value encrypted with an old key needs re-encryption")
+	}
+
+	// the old key removed
+	other, _ := mustKeyring(t, "other")
+	if _, err := other.Decrypt(encrypted); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("got %v, expected ErrUnknownKey", err)
+	}
+
+	// tampered
+	if _, err := k.Decrypt(encrypted[:len(encrypted)-2] + "AA"); err == nil {
+		t.Errorf("a tampered value must not decrypt")
+	}
+}
+
+func TestKeyring_WithoutKeys(t *testing.T) {
+	k, err := ParseKeyring("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, err := k.Encrypt("access"); err != nil || value != "access" {
+		t.Errorf("got %q (%v), expected the plaintext", value, err)
+	}
+	if k.NeedsReencryption("access") {
+		t.Errorf("nothing to re-encrypt without keys")
+	}
+}
+
+func TestKeyring_RequiredInProduction(t *testing.T) {
+	defer envy.Set("GO_ENV", envy.Get("GO_ENV", "development"))
+	defer envy.Set("ROAW_ENCRYPTION_KEYS", envy.Get("ROAW_ENCRYPTION_KEYS", ""))
+	envy.Set("ROAW_ENCRYPTION_KEYS", "")
+	envy.Set("GO_ENV", "production")
+
+	if _, err := fromEnv().Encrypt("access"); !errors.Is(err, ErrNoKeys) {
+		t.Errorf("got %v, expected ErrNoKeys", err)
+	}
+
+	_, keys := mustKeyring(t, "a")
+	envy.Set("ROAW_ENCRYPTION_KEYS", keys[0])
+	if value, err := fromEnv().Encrypt("access"); err != nil || !IsEncrypted(value) {
+		t.Errorf("got %q (%v), expected an encrypted value", value, err)
+	}
+}
+
+func TestParseKeyring_Invalid(t *testing.T) {
+	for _, spec := range []string{"nokey", "id:not-base64!", "id:c2hvcnQ=", ":c2hvcnQ="} {
+		if _, err := ParseKeyring(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+
+	_, keys := mustKeyring(t, "a")
+	if _, err := ParseKeyring(keys[0] + "," + keys[0]); err == nil {
+		t.Errorf("expected an error for duplicated keys")
+	}
+}
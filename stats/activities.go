@@ -0,0 +1,145 @@
+package stats
+
+import (
+	"math"
+	"sort"
+
+	"github.com/gofrs/uuid"
+	"github.com/tcarreira/roaw2020/models"
+)
+
+// byActivity returns true when the filter selects activities by what the weekly stats do not keep (type or date range)
+func (f Filter) byActivity() bool {
+	return !f.From.IsZero() || !f.To.IsZero() || len(f.Types) > 0
+}
+
+// of returns the metric's value on a week's stats
+func (m Metric) of(w models.WeeklyUserStat) float64 {
+	switch m {
+	case TotalDistance:
+		return float64(w.Distance)
+	case ActivityCount:
+		return float64(w.ActivityCount)
+	case TotalElapsedTime:
+		return float64(w.ElapsedTime)
+	case TotalMovingTime:
+		return float64(w.MovingTime)
+	case TotalElevation:
+		return w.ElevationGain
+	case MostDistance:
+		return float64(w.MaxDistance)
+	case MostElapsedTime:
+		return float64(w.MaxElapsedTime)
+	}
+	return 0
+}
+
+// add aggregates value into total
+func (m Metric) add(total, value float64) float64 {
+	if m.aggregate == aggregateMax {
+		return math.Max(total, value)
+	}
+	return total + value
+}
+
+// userStats are the weekly stats of a user
+type userStats struct {
+	UserID uuid.UUID
+	User   string
+	Weeks  models.WeeklyUserStats
+}
+
+// activityStats aggregates the weekly stats of the filter's users (ordered by name, even without activities)
+// from their activities, as models.RefreshWeeklyUserStats does, but only of the filter's types and date range
+func (r *Repository) activityStats(filter Filter) ([]userStats, error) {
+	where, args := filter.users()
+	users := []struct {
+		ID   uuid.UUID `db:"id"`
+		Name string    `db:"name"`
+	}{}
+	if err := r.tx.RawQuery("SELECT u.id AS id, u.name AS name FROM users u"+where+" ORDER BY u.name ASC, u.id ASC", args...).All(&users); err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return []userStats{}, nil
+	}
+
+	rule, err := filter.Season.ChallengeRule(r.tx)
+	if err != nil {
+		return nil, err
+	}
+
+	from, to := filter.Season.StartsOn, filter.Season.EndsOn
+	if filter.From.After(from) {
+		from = filter.From
+	}
+	if !filter.To.IsZero() && filter.To.Before(to) {
+		to = filter.To
+	}
+
+	userIDs := make([]interface{}, len(users))
+	for i, user := range users {
+		userIDs[i] = user.ID
+	}
+	q := r.tx.Where("deleted_at IS NULL AND datetime >= ? AND datetime < ?", from, to).Where("user_id IN (?)", userIDs...)
+	if len(filter.Types) > 0 {
+		types := make([]interface{}, len(filter.Types))
+		for i, activityType := range filter.Types {
+			types[i] = activityType
+		}
+		q = q.Where("type IN (?)", types...)
+	}
+	activities := models.Activities{}
+	if err := q.All(&activities); err != nil {
+		return nil, err
+	}
+
+	weeks := map[uuid.UUID]models.WeeklyUserStats{}
+	for _, stat := range models.AggregateWeeklyUserStats(filter.Season, rule, activities) {
+		weeks[stat.UserID] = append(weeks[stat.UserID], stat)
+	}
+
+	data := make([]userStats, len(users))
+	for i, user := range users {
+		data[i] = userStats{UserID: user.ID, User: user.Name, Weeks: weeks[user.ID]}
+	}
+	return data, nil
+}
+
+// activityTotals is Totals, aggregating the activities (see activityStats)
+func (r *Repository) activityTotals(metric Metric, filter Filter) ([]UserTotal, error) {
+	users, err := r.activityStats(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]UserTotal, len(users))
+	for i, user := range users {
+		total := 0.0
+		for _, week := range user.Weeks {
+			total = metric.add(total, metric.of(week))
+		}
+		data[i] = UserTotal{UserID: user.UserID.String(), User: user.User, Value: int(math.Round(total))}
+	}
+
+	// users are ordered by name: ties stay that way
+	sort.SliceStable(data, func(i, j int) bool { return data[i].Value > data[j].Value })
+	return data, nil
+}
+
+// activityWeekly is Weekly, aggregating the activities (see activityStats)
+func (r *Repository) activityWeekly(metric Metric, filter Filter) ([]UserWeeks, error) {
+	users, err := r.activityStats(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]UserWeeks, len(users))
+	for i, user := range users {
+		data[i] = UserWeeks{UserID: user.UserID.String(), User: user.User, Weeks: map[int]int{}}
+		for _, week := range user.Weeks {
+			data[i].Weeks[week.Week] = int(math.Round(metric.of(week)))
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,70 @@
+package stats
+
+// Point is a week's value on a chart
+type Point struct {
+	Week  int `json:"x"`
+	Value int `json:"y"`
+}
+
+// WeeklySeries are the chart points of every user (by name), from week 1 until the user's last week with activities
+type WeeklySeries map[string][]Point
+
+// Series returns the chart series of the weekly totals (filling the weeks without activities with 0).
+// Series start on week 1, as the charts' labels (see Weeks.Labels)
+func Series(data []UserWeeks) WeeklySeries {
+	series := WeeklySeries{}
+	for _, userWeeks := range data {
+		lastWeek := 1
+		for week := range userWeeks.Weeks {
+			if week > lastWeek {
+				lastWeek = week
+			}
+		}
+
+		points := series[userWeeks.User]
+		for week := 1; week <= lastWeek; week++ {
+			if week-1 < len(points) {
+				points[week-1].Value += userWeeks.Weeks[week] // users with the same name
+				continue
+			}
+			points = append(points, Point{Week: week, Value: userWeeks.Weeks[week]})
+		}
+		series[userWeeks.User] = points
+	}
+	return series
+}
+
+// Cumulative returns the running totals of the series. Everyone gets the last week point
+func (s WeeklySeries) Cumulative() WeeklySeries {
+	latestWeek := 0
+	for _, points := range s {
+		if last := points[len(points)-1].Week; last > latestWeek {
+			latestWeek = last
+		}
+	}
+
+	cumulativeSeries := WeeklySeries{}
+	for user, points := range s {
+		cumulative := 0
+		cumulativePoints := make([]Point, 0, len(points)+1)
+		for _, point := range points {
+			cumulative += point.Value
+			cumulativePoints = append(cumulativePoints, Point{Week: point.Week, Value: cumulative})
+		}
+		if points[len(points)-1].Week < latestWeek {
+			cumulativePoints = append(cumulativePoints, Point{Week: latestWeek, Value: cumulative})
+		}
+		cumulativeSeries[user] = cumulativePoints
+	}
+	return cumulativeSeries
+}
+
+// Scale divides every value (eg: by 1000, from meters to Km)
+func (s WeeklySeries) Scale(divisor int) WeeklySeries {
+	for _, points := range s {
+		for i := range points {
+			points[i].Value /= divisor
+		}
+	}
+	return s
+}
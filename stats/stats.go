@@ -0,0 +1,181 @@
+// Package stats ranks the users and their weekly series for the dashboard,
+// from the weekly aggregates of their activities (see models.WeeklyUserStat).
+// Filtering by activity type or date range aggregates the activities themselves instead (see activities.go).
+// Queries are parameterized and portable (Postgres and SQLite): only standard SQL is used.
+package stats
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/nulls"
+	"github.com/gobuffalo/pop/v5"
+	"github.com/gofrs/uuid"
+	"github.com/tcarreira/roaw2020/models"
+)
+
+type aggregate int
+
+const (
+	aggregateSum aggregate = iota
+	aggregateMax
+)
+
+// Metric is an aggregation of a weekly_user_stats' column
+type Metric struct {
+	aggregate aggregate
+	column    string
+}
+
+// Metrics available for Totals and Weekly
+var (
+	TotalDistance    = Metric{aggregateSum, "distance"}
+	ActivityCount    = Metric{aggregateSum, "activity_count"}
+	TotalElapsedTime = Metric{aggregateSum, "elapsed_time"}
+	TotalMovingTime  = Metric{aggregateSum, "moving_time"}
+	TotalElevation   = Metric{aggregateSum, "elevation_gain"}
+	MostDistance     = Metric{aggregateMax, "max_distance"}
+	MostElapsedTime  = Metric{aggregateMax, "max_elapsed_time"}
+)
+
+// sqlAggregate returns the (integer) aggregation of the metric over the weekly stats "w"
+func (m Metric) sqlAggregate() string {
+	function := "SUM"
+	if m.aggregate == aggregateMax {
+		function = "MAX"
+	}
+	return "CAST(ROUND(" + function + "(COALESCE(w." + m.column + ", 0))) AS BIGINT)"
+}
+
+// Filter selects the season, the activities and the users to aggregate. Zero values (but Season) do not filter.
+// Users hidden from the leaderboards are only aggregated when selected by UserIDs
+type Filter struct {
+	Season  *models.Season // the season's weekly stats (valid activities for its challenge rule)
+	From    time.Time      // activities since (inclusive)
+	To      time.Time      // activities until (exclusive)
+	Types   []string       // activity types
+	GroupID uuid.UUID      // the group's members
+	UserIDs []uuid.UUID    // these users
+}
+
+// placeholders returns "?, ?, ?" (n times)
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// from returns the FROM clause (and its args) of the users LEFT JOIN their season's weekly stats.
+// Every user in the filter is returned, even without activities
+func (f Filter) from() (string, []interface{}) {
+	where, args := f.users()
+	return "FROM users u LEFT JOIN weekly_user_stats w ON w.user_id = u.id AND w.season_id = ?" + where,
+		append([]interface{}{f.Season.StatsID()}, args...)
+}
+
+// users returns the WHERE clause (and its args) of the filter's users "u"
+func (f Filter) users() (string, []interface{}) {
+	where := ""
+	args := []interface{}{}
+
+	conditions := []string{}
+	if f.GroupID != uuid.Nil {
+		conditions = append(conditions, "u.id IN (SELECT m.user_id FROM group_memberships m WHERE m.group_id = ?)")
+		args = append(args, f.GroupID)
+	}
+	if len(f.UserIDs) > 0 {
+		conditions = append(conditions, "u.id IN ("+placeholders(len(f.UserIDs))+")")
+		for _, userID := range f.UserIDs {
+			args = append(args, userID)
+		}
+	} else {
+		conditions = append(conditions, "u.hidden_from_leaderboards = ?")
+		args = append(args, false)
+	}
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	return where, args
+}
+
+// UserTotal is a metric's total of a user
+type UserTotal struct {
+	UserID string `json:"user_id" db:"user_id"`
+	User   string `json:"user" db:"user"`
+	Value  int    `json:"value" db:"value"`
+}
+
+// UserWeeks are the weekly totals of a metric of a user (weeks without activities are missing)
+type UserWeeks struct {
+	UserID string
+	User   string
+	Weeks  map[int]int
+}
+
+// Repository runs the stats queries
+type Repository struct {
+	tx *pop.Connection
+}
+
+// New returns a Repository using the connection (or transaction)
+func New(tx *pop.Connection) *Repository {
+	return &Repository{tx: tx}
+}
+
+func totalsQuery(metric Metric, filter Filter) (string, []interface{}) {
+	from, args := filter.from()
+	return "SELECT u.id AS user_id, u.name AS user, " + metric.sqlAggregate() + " AS value " +
+		from + " " +
+		"GROUP BY u.id, u.name " +
+		"ORDER BY value DESC, u.name ASC", args
+}
+
+// Totals returns the metric's total of every user, ranked (biggest first)
+func (r *Repository) Totals(metric Metric, filter Filter) ([]UserTotal, error) {
+	if filter.byActivity() {
+		return r.activityTotals(metric, filter)
+	}
+	query, args := totalsQuery(metric, filter)
+
+	data := []UserTotal{}
+	err := r.tx.RawQuery(query, args...).All(&data)
+	return data, err
+}
+
+func weeklyQuery(metric Metric, filter Filter) (string, []interface{}) {
+	from, args := filter.from()
+	return "SELECT u.id AS user_id, u.name AS user, w.week AS week, " + metric.sqlAggregate() + " AS value " +
+		from + " " +
+		"GROUP BY u.id, u.name, w.week " +
+		"ORDER BY u.name ASC, u.id ASC, w.week ASC", args
+}
+
+// Weekly returns the metric's weekly totals of every user (ordered by name), weeks numbered by models.Weeks
+func (r *Repository) Weekly(metric Metric, filter Filter) ([]UserWeeks, error) {
+	if filter.byActivity() {
+		return r.activityWeekly(metric, filter)
+	}
+	query, args := weeklyQuery(metric, filter)
+
+	rows := []struct {
+		UserID string    `db:"user_id"`
+		User   string    `db:"user"`
+		Week   nulls.Int `db:"week"`
+		Value  int       `db:"value"`
+	}{}
+	if err := r.tx.RawQuery(query, args...).All(&rows); err != nil {
+		return nil, err
+	}
+
+	data := []UserWeeks{}
+	for i, row := range rows {
+		if i == 0 || row.UserID != rows[i-1].UserID { // ordered by user first
+			data = append(data, UserWeeks{UserID: row.UserID, User: row.User, Weeks: map[int]int{}})
+		}
+		if !row.Week.Valid {
+			continue // user without activities
+		}
+		data[len(data)-1].Weeks[row.Week.Int] = row.Value
+	}
+
+	return data, nil
+}
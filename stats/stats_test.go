@@ -0,0 +1,84 @@
+package stats
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/tcarreira/roaw2020/models"
+)
+
+func TestFilter_from(t *testing.T) {
+	season := &models.Season{ID: uuid.Must(uuid.NewV4())}
+	from, args := Filter{Season: season}.from()
+	if from != "FROM users u LEFT JOIN weekly_user_stats w ON w.user_id = u.id AND w.season_id = ? WHERE u.hidden_from_leaderboards = ?" {
+		t.Errorf("unexpected season filter: %s", from)
+	}
+	if !reflect.DeepEqual(args, []interface{}{season.ID, false}) {
+		t.Errorf("unexpected args: %v", args)
+	}
+
+	groupID, userID := uuid.Must(uuid.NewV4()), uuid.Must(uuid.NewV4())
+	from, args = Filter{Season: season, GroupID: groupID, UserIDs: []uuid.UUID{userID}}.from()
+	expected := " WHERE u.id IN (SELECT m.user_id FROM group_memberships m WHERE m.group_id = ?) AND u.id IN (?)"
+	if !strings.HasSuffix(from, expected) {
+		t.Errorf("%q not in %q", expected, from)
+	}
+
+	expectedArgs := []interface{}{season.ID, groupID, userID}
+	if !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("got args %v, expected %v", args, expectedArgs)
+	}
+	if strings.Count(from, "?") != len(args) {
+		t.Errorf("%d placeholders for %d args", strings.Count(from, "?"), len(args))
+	}
+
+	// the default season (not stored) has its own stats
+	_, args = Filter{Season: models.DefaultSeason()}.from()
+	if args[0] == uuid.Nil {
+		t.Errorf("the default season must have a stats id: %v", args)
+	}
+}
+
+func TestMetric_sqlAggregate(t *testing.T) {
+	for metric, expected := range map[Metric]string{
+		TotalDistance:  "CAST(ROUND(SUM(COALESCE(w.distance, 0))) AS BIGINT)",
+		ActivityCount:  "CAST(ROUND(SUM(COALESCE(w.activity_count, 0))) AS BIGINT)",
+		MostDistance:   "CAST(ROUND(MAX(COALESCE(w.max_distance, 0))) AS BIGINT)",
+		TotalElevation: "CAST(ROUND(SUM(COALESCE(w.elevation_gain, 0))) AS BIGINT)",
+	} {
+		if got := metric.sqlAggregate(); got != expected {
+			t.Errorf("got %s, expected %s", got, expected)
+		}
+	}
+}
+
+func TestSeries(t *testing.T) {
+	series := Series([]UserWeeks{
+		{UserID: "1", User: "Runner", Weeks: map[int]int{1: 5000, 3: 2000}},
+		{UserID: "2", User: "Lazy", Weeks: map[int]int{}},
+		{UserID: "3", User: "Early", Weeks: map[int]int{0: 1000, 2: 1000}}, // week 0 is before the season
+	})
+	expected := WeeklySeries{
+		"Runner": {{1, 5000}, {2, 0}, {3, 2000}},
+		"Lazy":   {{1, 0}},
+		"Early":  {{1, 0}, {2, 1000}},
+	}
+	if !reflect.DeepEqual(series, expected) {
+		t.Errorf("got %v, expected %v", series, expected)
+	}
+
+	cumulative := series.Cumulative().Scale(1000)
+	expected = WeeklySeries{
+		"Runner": {{1, 5}, {2, 5}, {3, 7}},
+		"Lazy":   {{1, 0}, {3, 0}},
+		"Early":  {{1, 0}, {2, 1}, {3, 1}},
+	}
+	if !reflect.DeepEqual(cumulative, expected) {
+		t.Errorf("got %v, expected %v", cumulative, expected)
+	}
+	if series["Runner"][0].Value != 5000 {
+		t.Errorf("Cumulative must not change the series: %v", series)
+	}
+}
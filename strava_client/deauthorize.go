@@ -0,0 +1,42 @@
+package stravaclient
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gobuffalo/envy"
+)
+
+// deauthorizeClient fails fast: the callers hold a transaction (eg: deleting the account) while deauthorizing.
+// Being an OAuth endpoint, it does not go through the (throttling) DefaultTransport
+var deauthorizeClient = &http.Client{Timeout: 10 * time.Second}
+
+// Deauthorize revokes the application's access to the athlete's data (on behalf of stravaAccessToken),
+// as if the athlete removed it from Strava's settings. See https://developers.strava.com/docs/authentication/#deauthorization
+func Deauthorize(stravaAccessToken string) error {
+	form := url.Values{"access_token": {stravaAccessToken}}
+	endpoint := envy.Get("STRAVA_OAUTH_URL", "https://www.strava.com/oauth") + "/deauthorize"
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := deauthorizeClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("could not deauthorize on Strava: %s %s", resp.Status, body)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package stravaclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gobuffalo/envy"
+)
+
+func Test_Deauthorize(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Method != http.MethodPost || r.URL.Path != "/deauthorize" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if r.FormValue("access_token") == "revoked" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message": "Authorization Error"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "token"}`))
+	}))
+	defer server.Close()
+	envy.Set("STRAVA_OAUTH_URL", server.URL)
+	defer envy.Set("STRAVA_OAUTH_URL", "https://www.strava.com/oauth")
+
+	if err := Deauthorize("token"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := Deauthorize("revoked"); err == nil {
+		t.Errorf("expected an error for a revoked token")
+	}
+	if requests != 2 {
+		t.Errorf("got %d requests, expected 2", requests)
+	}
+}
+
+func Test_Deauthorize_Timeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+	envy.Set("STRAVA_OAUTH_URL", server.URL)
+	defer envy.Set("STRAVA_OAUTH_URL", "https://www.strava.com/oauth")
+
+	timeout := deauthorizeClient.Timeout
+	deauthorizeClient.Timeout = 50 * time.Millisecond
+	defer func() { deauthorizeClient.Timeout = timeout }()
+
+	if err := Deauthorize("token"); err == nil {
+		t.Errorf("expected a timeout error")
+	}
+}
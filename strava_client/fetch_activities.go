@@ -1,7 +1,11 @@
 package stravaclient
 
 import (
-	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
@@ -10,36 +14,45 @@ import (
 	"github.com/tcarreira/roaw2020/strava_client/swagger"
 )
 
+// ErrActivityNotFound is returned when Strava does not (or no longer) expose an activity
+var ErrActivityNotFound = errors.New("activity not found")
+
+// Activity is an activity as returned by Strava's API: the generated swagger.SummaryActivity,
+// with the fields its model misses (heart rate) or can not decode (the coordinates are arrays of 2 numbers)
+type Activity struct {
+	swagger.SummaryActivity
+	StartLatlng      []float32 `json:"start_latlng,omitempty"`
+	EndLatlng        []float32 `json:"end_latlng,omitempty"`
+	HasHeartrate     bool      `json:"has_heartrate,omitempty"`
+	AverageHeartrate float32   `json:"average_heartrate,omitempty"`
+	MaxHeartrate     float32   `json:"max_heartrate,omitempty"`
+	ElevHigh         *float32  `json:"elev_high,omitempty"` // nil when Strava does not send it (0 is the sea level)
+	ElevLow          *float32  `json:"elev_low,omitempty"`
+}
+
 // StravaAPI contains a Strava API Client with the necessary context
 type StravaAPI struct {
-	client *swagger.APIClient
-	ctx    context.Context
-	opts   *swagger.ActivitiesApiGetLoggedInAthleteActivitiesOpts
+	cfg   *swagger.Configuration
+	token string
+	opts  *swagger.ActivitiesApiGetLoggedInAthleteActivitiesOpts
 }
 
-func getThisYear() int {
-
-	osEnv := envy.Get("ROAW_YEAR", "")
-	thisYear, err := strconv.Atoi(osEnv)
-	if err != nil {
-		// Fail silently
-		return time.Now().Year()
-	}
-
-	return thisYear
+// newConfiguration returns the swagger configuration, pointing to STRAVA_API_URL when set
+// (useful for a local fake Strava server) and using the shared rate limit aware transport
+func newConfiguration() *swagger.Configuration {
+	cfg := swagger.NewConfiguration()
+	cfg.BasePath = envy.Get("STRAVA_API_URL", cfg.BasePath)
+	cfg.HTTPClient = &http.Client{Transport: DefaultTransport}
+	return cfg
 }
 
 // NewStravaAPI returns a StravaAPI ready to make API calls (on behalf of stravaAccessToken)
-// with default opts (like after/before dates)
+// with default opts (like page size)
 func NewStravaAPI(stravaAccessToken string) *StravaAPI {
-	thisYear := getThisYear()
-
 	s := &StravaAPI{
-		client: swagger.NewAPIClient(swagger.NewConfiguration()),
-		ctx:    context.WithValue(context.Background(), swagger.ContextAccessToken, stravaAccessToken),
+		cfg:   newConfiguration(),
+		token: stravaAccessToken,
 		opts: &swagger.ActivitiesApiGetLoggedInAthleteActivitiesOpts{
-			After:   optional.NewInt32(int32(time.Date(thisYear, 1, 1, 0, 0, 0, 0, time.UTC).Unix())),
-			Before:  optional.NewInt32(int32(time.Date(thisYear+1, 1, 1, 0, 0, 0, 0, time.UTC).Unix())),
 			Page:    optional.NewInt32(1),
 			PerPage: optional.NewInt32(int32(5)),
 		},
@@ -47,30 +60,63 @@ func NewStravaAPI(stravaAccessToken string) *StravaAPI {
 	return s
 }
 
+// get GETs path (with query) on behalf of the athlete, decoding the JSON response into v. It returns the response status.
+// The generated swagger client is not used for activities, as it can not decode them (see Activity)
+func (s *StravaAPI) get(path string, query url.Values, v interface{}) (int, error) {
+	req, err := http.NewRequest(http.MethodGet, s.cfg.BasePath+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	req.Header.Set("User-Agent", s.cfg.UserAgent)
+
+	resp, err := s.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return resp.StatusCode, fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(v)
+}
+
 // fetchActivitiesSinglePage will fetch a single page
-func (s *StravaAPI) fetchActivitiesSinglePage(page int) ([]swagger.SummaryActivity, error) {
+func (s *StravaAPI) fetchActivitiesSinglePage(page int) ([]Activity, error) {
 	s.opts.Page = optional.NewInt32(int32(page))
-	activities, _, err := s.client.ActivitiesApi.GetLoggedInAthleteActivities(s.ctx, s.opts)
 
+	query := url.Values{}
+	query.Set("page", strconv.Itoa(int(s.opts.Page.Value())))
+	query.Set("per_page", strconv.Itoa(int(s.opts.PerPage.Value())))
+	if s.opts.After.IsSet() {
+		query.Set("after", strconv.Itoa(int(s.opts.After.Value())))
+	}
+	if s.opts.Before.IsSet() {
+		query.Set("before", strconv.Itoa(int(s.opts.Before.Value())))
+	}
+
+	activities := []Activity{}
+	_, err := s.get("/athlete/activities", query, &activities)
 	return activities, err
 }
 
-// FetchAllActivities will fetch and return all activities (within the after/before defined in StravaAPI.opts)
-func FetchAllActivities(stravaAccessToken string) ([]swagger.SummaryActivity, error) {
-	stravaAPI := NewStravaAPI(stravaAccessToken)
-	stravaAPI.opts.PerPage = optional.NewInt32(200) // set to max limit if we are going to fetch all anyway (https://developers.strava.com/docs/#Pagination)
+// fetchAllPages will fetch and return all activities (within the after/before defined in StravaAPI.opts), page by page
+func (s *StravaAPI) fetchAllPages() ([]Activity, error) {
+	s.opts.PerPage = optional.NewInt32(200) // set to max limit if we are going to fetch all anyway (https://developers.strava.com/docs/#Pagination)
 
-	var allActivities []swagger.SummaryActivity
+	var allActivities []Activity
 	for i := 1; ; i++ {
-		activities, err := stravaAPI.fetchActivitiesSinglePage(i)
+		activities, err := s.fetchActivitiesSinglePage(i)
 
 		if err != nil {
-			return []swagger.SummaryActivity{}, err
+			return []Activity{}, err
 		}
 
 		allActivities = append(allActivities, activities...)
 
-		if int32(len(activities)) != stravaAPI.opts.PerPage.Value() {
+		if int32(len(activities)) != s.opts.PerPage.Value() {
 			// repeat the cicle until returns less than PerPage
 			break
 		}
@@ -80,11 +126,24 @@ func FetchAllActivities(stravaAccessToken string) ([]swagger.SummaryActivity, er
 	return allActivities, nil
 }
 
-// FetchLatestActivities will fetch and return the latest N activities (N defined in StravaAPI.opts)
-func FetchLatestActivities(stravaAccessToken string) ([]swagger.SummaryActivity, error) {
+// FetchActivitiesBetween will fetch and return every activity started within the given window, going through all pages
+func FetchActivitiesBetween(stravaAccessToken string, after, before time.Time) ([]Activity, error) {
 	stravaAPI := NewStravaAPI(stravaAccessToken)
+	stravaAPI.opts.After = optional.NewInt32(int32(after.Unix()))
+	stravaAPI.opts.Before = optional.NewInt32(int32(before.Unix()))
 
-	activities, err := stravaAPI.fetchActivitiesSinglePage(1)
+	return stravaAPI.fetchAllPages()
+}
 
-	return activities, err
+// FetchActivity will fetch and return a single activity (by Strava's activity id)
+func FetchActivity(stravaAccessToken string, activityID int64) (Activity, error) {
+	stravaAPI := NewStravaAPI(stravaAccessToken)
+
+	// a DetailedActivity is a superset of a SummaryActivity (same json fields)
+	activity := Activity{}
+	status, err := stravaAPI.get("/activities/"+strconv.FormatInt(activityID, 10), url.Values{}, &activity)
+	if status == http.StatusNotFound {
+		return Activity{}, ErrActivityNotFound
+	}
+	return activity, err
 }
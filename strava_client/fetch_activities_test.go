@@ -0,0 +1,137 @@
+package stravaclient
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gobuffalo/envy"
+	"github.com/tcarreira/roaw2020/strava_client/swagger"
+)
+
+// fakeStrava starts a local server answering like Strava's API would
+func fakeStrava(t *testing.T, handler http.HandlerFunc) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	envy.Set("STRAVA_API_URL", server.URL)
+	t.Cleanup(func() { envy.Set("STRAVA_API_URL", "https://www.strava.com/api/v3") })
+}
+
+func Test_FetchActivity(t *testing.T) {
+	fakeStrava(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/activities/123" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("unexpected Authorization header %q", r.Header.Get("Authorization"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": 123, "name": "Morning Run", "type": "Run", "distance": 5000.5, "calories": 300,
+			"start_latlng": [38.7, -9.1], "end_latlng": [38.8, -9.2], "has_heartrate": true, "average_heartrate": 150.5}`))
+	})
+
+	activity, err := FetchActivity("token", 123)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if activity.Id != 123 || activity.Name != "Morning Run" || activity.Distance != 5000.5 || string(*activity.Type_) != "Run" {
+		t.Errorf("unexpected activity %+v", activity)
+	}
+	if len(activity.StartLatlng) != 2 || activity.StartLatlng[0] != 38.7 || !activity.HasHeartrate || activity.AverageHeartrate != 150.5 {
+		t.Errorf("unexpected activity details %+v", activity)
+	}
+}
+
+func Test_FetchActivity_NotFound(t *testing.T) {
+	fakeStrava(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message": "Record Not Found"}`))
+	})
+
+	_, err := FetchActivity("token", 123)
+	if !errors.Is(err, ErrActivityNotFound) {
+		t.Errorf("expected ErrActivityNotFound, got %v", err)
+	}
+}
+
+// paginatedActivities answers GET /athlete/activities like Strava does (after/before/page/per_page),
+// counting the requests
+func paginatedActivities(activities []swagger.SummaryActivity, requests *int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		q := r.URL.Query()
+		after, _ := strconv.ParseInt(q.Get("after"), 10, 64)
+		before, _ := strconv.ParseInt(q.Get("before"), 10, 64)
+		page, _ := strconv.Atoi(q.Get("page"))
+		perPage, _ := strconv.Atoi(q.Get("per_page"))
+
+		filtered := []swagger.SummaryActivity{}
+		for _, activity := range activities {
+			if activity.StartDate.Unix() > after && activity.StartDate.Unix() < before {
+				filtered = append(filtered, activity)
+			}
+		}
+
+		from, to := (page-1)*perPage, page*perPage
+		if from > len(filtered) {
+			from = len(filtered)
+		}
+		if to > len(filtered) {
+			to = len(filtered)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(filtered[from:to])
+	}
+}
+
+func Test_FetchActivitiesBetween_DoesNotSkipActivities(t *testing.T) {
+	end := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// 450 activities (more than 2 pages), one every 3 hours, oldest first
+	activities := make([]swagger.SummaryActivity, 450)
+	for i := range activities {
+		activities[i] = swagger.SummaryActivity{Id: int64(i), StartDate: time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(i) * 3 * time.Hour)}
+	}
+
+	requests := 0
+	fakeStrava(t, paginatedActivities(activities, &requests))
+
+	tests := []struct {
+		name     string
+		after    time.Time
+		first    int
+		requests int
+	}{
+		{"season start", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), 0, 3},
+		{"cursor on an activity (minus margin)", activities[100].StartDate.Add(-time.Second), 100, 2},
+		{"cursor between activities", activities[399].StartDate.Add(time.Minute), 400, 1},
+		{"cursor before the season", time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC), 0, 3},
+	}
+
+	for _, tt := range tests {
+		requests = 0
+		fetched, err := FetchActivitiesBetween("token", tt.after, end)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		if len(fetched) != len(activities)-tt.first {
+			t.Errorf("%s: fetched %d activities, want %d", tt.name, len(fetched), len(activities)-tt.first)
+		}
+		for i, activity := range fetched {
+			if activity.Id != int64(tt.first+i) {
+				t.Fatalf("%s: activity %d skipped (got %d)", tt.name, tt.first+i, activity.Id)
+			}
+		}
+		if requests != tt.requests {
+			t.Errorf("%s: made %d requests, want %d", tt.name, requests, tt.requests)
+		}
+	}
+}
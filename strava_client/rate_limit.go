@@ -0,0 +1,257 @@
+package stravaclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned (instead of calling Strava) when the rate limit budget is exhausted
+// and the request cannot wait for it to reset. The request should be deferred.
+var ErrRateLimited = errors.New("strava rate limit exceeded")
+
+// shortWindow is Strava's short term rate limit window (resets every quarter of an hour)
+const shortWindow = 15 * time.Minute
+
+// defaultMaxWait is the default RateLimitTransport.MaxWait: callers may hold a database transaction,
+// so longer waits are left to them (eg: a sync job is retried later)
+const defaultMaxWait = 10 * time.Second
+
+// DefaultTransport is the rate limit aware transport shared by every StravaAPI
+var DefaultTransport = NewRateLimitTransport(http.DefaultTransport)
+
+// RateLimitUsage is a snapshot of Strava's rate limit budget, as last seen on the
+// X-RateLimit-Limit/X-RateLimit-Usage headers (15-minute and daily).
+type RateLimitUsage struct {
+	ShortLimit int       `json:"short_limit"`
+	ShortUsage int       `json:"short_usage"`
+	DailyLimit int       `json:"daily_limit"`
+	DailyUsage int       `json:"daily_usage"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	Throttled  int       `json:"throttled"` // requests delayed waiting for the 15-minute window
+	Deferred   int       `json:"deferred"`  // requests refused with ErrRateLimited
+	Retried    int       `json:"retried"`   // retries after a 429 or 5xx response
+}
+
+// ShortPercent returns the 15-minute usage percentage (0 when unknown)
+func (u RateLimitUsage) ShortPercent() int {
+	if u.ShortLimit == 0 {
+		return 0
+	}
+	return 100 * u.ShortUsage / u.ShortLimit
+}
+
+// DailyPercent returns the daily usage percentage (0 when unknown)
+func (u RateLimitUsage) DailyPercent() int {
+	if u.DailyLimit == 0 {
+		return 0
+	}
+	return 100 * u.DailyUsage / u.DailyLimit
+}
+
+// ShortResetAt returns when the current 15-minute window resets
+func (u RateLimitUsage) ShortResetAt() time.Time {
+	return u.UpdatedAt.UTC().Truncate(shortWindow).Add(shortWindow)
+}
+
+// DailyResetAt returns when the current daily window resets (midnight UTC)
+func (u RateLimitUsage) DailyResetAt() time.Time {
+	y, m, d := u.UpdatedAt.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
+
+// Wait returns how long until there is budget for another request (0 when there is), at the given time
+func (u RateLimitUsage) Wait(now time.Time) time.Duration {
+	u = u.current(now)
+	switch {
+	case u.DailyLimit > 0 && u.DailyUsage >= u.DailyLimit:
+		return u.DailyResetAt().Sub(now)
+	case u.ShortLimit > 0 && u.ShortUsage >= u.ShortLimit:
+		return u.ShortResetAt().Sub(now)
+	}
+	return 0
+}
+
+// current returns the usage at the given time: windows which already reset are zeroed,
+// and the usage is moved to the current window (counting from there)
+func (u RateLimitUsage) current(now time.Time) RateLimitUsage {
+	if u.UpdatedAt.IsZero() || now.Before(u.ShortResetAt()) {
+		return u
+	}
+	u.ShortUsage = 0
+	if !now.Before(u.DailyResetAt()) {
+		u.DailyUsage = 0
+	}
+	u.UpdatedAt = now
+	return u
+}
+
+// RateLimitTransport is an http.RoundTripper aware of Strava's rate limits.
+// It tracks the budgets from the response headers, waits for the 15-minute window to reset
+// (up to MaxWait) before exceeding it, refuses requests when the daily budget is exhausted,
+// and retries 429/5xx responses with backoff.
+type RateLimitTransport struct {
+	Base       http.RoundTripper
+	MaxRetries int           // retries on 429/5xx responses
+	Backoff    time.Duration // wait before the first retry (doubled on every retry)
+	MaxWait    time.Duration // longest wait for the 15-minute window to reset (otherwise ErrRateLimited)
+
+	mu    sync.Mutex
+	usage RateLimitUsage
+	now   func() time.Time
+	sleep func(context.Context, time.Duration) error
+}
+
+// NewRateLimitTransport returns a RateLimitTransport wrapping base with default settings
+func NewRateLimitTransport(base http.RoundTripper) *RateLimitTransport {
+	return &RateLimitTransport{
+		Base:       base,
+		MaxRetries: 3,
+		Backoff:    time.Second,
+		MaxWait:    defaultMaxWait,
+		now:        time.Now,
+		sleep:      sleepContext,
+	}
+}
+
+// Usage returns the current rate limit usage
+func (t *RateLimitTransport) Usage() RateLimitUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.usage.current(t.now())
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *RateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for retry := 0; ; retry++ {
+		if err := t.reserve(req.Context()); err != nil {
+			return nil, err
+		}
+
+		attempt := req
+		if retry > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attempt = req.Clone(req.Context())
+			attempt.Body = body
+		}
+
+		resp, err := t.Base.RoundTrip(attempt)
+		if err != nil {
+			return nil, err
+		}
+		t.update(resp)
+
+		if !isRetryable(resp) || retry >= t.MaxRetries || (req.Body != nil && req.GetBody == nil) {
+			return resp, nil
+		}
+
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+
+		t.mu.Lock()
+		t.usage.Retried++
+		t.mu.Unlock()
+
+		// a 429 will also wait for the window to reset on reserve()
+		if err := t.sleep(req.Context(), t.Backoff<<uint(retry)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// reserve waits until there is budget for one more request (and counts it)
+func (t *RateLimitTransport) reserve(ctx context.Context) error {
+	for {
+		t.mu.Lock()
+		now := t.now()
+		usage := t.usage.current(now)
+
+		if usage.DailyLimit > 0 && usage.DailyUsage >= usage.DailyLimit {
+			t.usage.Deferred++
+			t.mu.Unlock()
+			return ErrRateLimited
+		}
+
+		if usage.ShortLimit == 0 || usage.ShortUsage < usage.ShortLimit {
+			t.usage = usage
+			t.usage.ShortUsage++
+			t.usage.DailyUsage++
+			t.mu.Unlock()
+			return nil
+		}
+
+		wait := usage.ShortResetAt().Sub(now)
+		if wait > t.MaxWait {
+			t.usage.Deferred++
+			t.mu.Unlock()
+			return ErrRateLimited
+		}
+		t.usage.Throttled++
+		t.mu.Unlock()
+
+		if err := t.sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// update reads the budget from Strava's headers (eg: "X-RateLimit-Limit: 100,1000" and "X-RateLimit-Usage: 7,250")
+func (t *RateLimitTransport) update(resp *http.Response) {
+	limits, okLimits := parseRateLimitHeader(resp.Header.Get("X-RateLimit-Limit"))
+	usages, okUsages := parseRateLimitHeader(resp.Header.Get("X-RateLimit-Usage"))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if okLimits && okUsages {
+		t.usage.ShortLimit, t.usage.DailyLimit = limits[0], limits[1]
+		t.usage.ShortUsage, t.usage.DailyUsage = usages[0], usages[1]
+		t.usage.UpdatedAt = t.now()
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests && t.usage.ShortLimit > 0 && t.usage.ShortUsage < t.usage.ShortLimit {
+		// Strava says we are over the limit, even if we did not count it
+		t.usage.ShortUsage = t.usage.ShortLimit
+		t.usage.UpdatedAt = t.now()
+	}
+}
+
+func parseRateLimitHeader(header string) ([2]int, bool) {
+	values := [2]int{}
+	parts := strings.Split(header, ",")
+	if len(parts) != 2 {
+		return values, false
+	}
+	for i, part := range parts {
+		v, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return values, false
+		}
+		values[i] = v
+	}
+	return values, true
+}
+
+func isRetryable(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
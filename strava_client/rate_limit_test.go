@@ -0,0 +1,205 @@
+package stravaclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testTransport returns a RateLimitTransport with a fake clock (sleeping only moves the clock)
+func testTransport(now time.Time) (*RateLimitTransport, *[]time.Duration) {
+	sleeps := []time.Duration{}
+	transport := NewRateLimitTransport(http.DefaultTransport)
+	transport.now = func() time.Time { return now }
+	transport.sleep = func(ctx context.Context, d time.Duration) error {
+		sleeps = append(sleeps, d)
+		now = now.Add(d)
+		return nil
+	}
+	return transport, &sleeps
+}
+
+// rateLimitServer starts a local server emitting Strava's rate limit headers, counting the requests
+func rateLimitServer(t *testing.T, limit, usage string, statuses ...int) (string, *int) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", limit)
+		w.Header().Set("X-RateLimit-Usage", usage)
+		if requests < len(statuses) {
+			w.WriteHeader(statuses[requests])
+		}
+		requests++
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, &requests
+}
+
+func get(transport *RateLimitTransport, url string) (*http.Response, error) {
+	resp, err := (&http.Client{Transport: transport}).Get(url)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return resp, err
+}
+
+func Test_RateLimitTransport_TracksUsage(t *testing.T) {
+	transport, sleeps := testTransport(time.Date(2020, 6, 1, 10, 5, 0, 0, time.UTC))
+	url, _ := rateLimitServer(t, "100,1000", "7,250")
+
+	if _, err := get(transport, url); err != nil {
+		t.Fatal(err)
+	}
+
+	usage := transport.Usage()
+	if usage.ShortLimit != 100 || usage.ShortUsage != 7 || usage.DailyLimit != 1000 || usage.DailyUsage != 250 {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+	if usage.ShortPercent() != 7 || usage.DailyPercent() != 25 {
+		t.Errorf("unexpected percentages %d%% / %d%%", usage.ShortPercent(), usage.DailyPercent())
+	}
+	if !usage.ShortResetAt().Equal(time.Date(2020, 6, 1, 10, 15, 0, 0, time.UTC)) {
+		t.Errorf("unexpected 15-minute reset %v", usage.ShortResetAt())
+	}
+	if len(*sleeps) != 0 {
+		t.Errorf("should not wait, waited %v", *sleeps)
+	}
+}
+
+func Test_RateLimitTransport_WaitsForShortWindow(t *testing.T) {
+	transport, sleeps := testTransport(time.Date(2020, 6, 1, 10, 5, 0, 0, time.UTC))
+	transport.MaxWait = shortWindow
+	url, requests := rateLimitServer(t, "100,1000", "100,250")
+
+	if _, err := get(transport, url); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get(transport, url); err != nil {
+		t.Fatal(err)
+	}
+
+	if *requests != 2 {
+		t.Errorf("expected 2 requests, got %d", *requests)
+	}
+	if len(*sleeps) != 1 || (*sleeps)[0] != 10*time.Minute {
+		t.Errorf("expected to wait 10 minutes for the window to reset, waited %v", *sleeps)
+	}
+	if transport.Usage().Throttled != 1 {
+		t.Errorf("expected 1 throttled request, got %+v", transport.Usage())
+	}
+}
+
+func Test_RateLimitTransport_DefersLongWaits(t *testing.T) {
+	transport, sleeps := testTransport(time.Date(2020, 6, 1, 10, 5, 0, 0, time.UTC))
+	url, requests := rateLimitServer(t, "100,1000", "100,250")
+
+	if _, err := get(transport, url); err != nil {
+		t.Fatal(err)
+	}
+	// by default, the caller waits (eg: a sync job retried later), not the transport
+	if _, err := get(transport, url); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected ErrRateLimited, got %v", err)
+	}
+	if wait := transport.Usage().Wait(transport.now()); wait != 10*time.Minute {
+		t.Errorf("expected to wait 10 minutes for the window to reset, got %v", wait)
+	}
+
+	if *requests != 1 || len(*sleeps) != 0 {
+		t.Errorf("expected 1 request without waiting, got %d requests and waits %v", *requests, *sleeps)
+	}
+}
+
+func Test_RateLimitTransport_CountsOnNewWindow(t *testing.T) {
+	transport, _ := testTransport(time.Date(2020, 6, 1, 10, 20, 0, 0, time.UTC))
+	transport.usage = RateLimitUsage{ShortLimit: 2, ShortUsage: 2, DailyLimit: 1000, DailyUsage: 10, UpdatedAt: time.Date(2020, 6, 1, 10, 5, 0, 0, time.UTC)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})) // no rate limit headers
+	t.Cleanup(server.Close)
+
+	// the previous window is over: its usage is not counted, the new one's is
+	for i := 0; i < 2; i++ {
+		if _, err := get(transport, server.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := get(transport, server.URL); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected ErrRateLimited once the new window is used up, got %v", err)
+	}
+
+	usage := transport.Usage()
+	if usage.ShortUsage != 2 || usage.DailyUsage != 12 || !usage.ShortResetAt().Equal(time.Date(2020, 6, 1, 10, 30, 0, 0, time.UTC)) {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+}
+
+func Test_RateLimitTransport_DefersWhenDailyExhausted(t *testing.T) {
+	transport, _ := testTransport(time.Date(2020, 6, 1, 10, 5, 0, 0, time.UTC))
+	url, requests := rateLimitServer(t, "100,1000", "10,1000")
+
+	if _, err := get(transport, url); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get(transport, url); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected ErrRateLimited, got %v", err)
+	}
+
+	if *requests != 1 {
+		t.Errorf("expected Strava to be called once, got %d", *requests)
+	}
+	if transport.Usage().Deferred != 1 {
+		t.Errorf("expected 1 deferred request, got %+v", transport.Usage())
+	}
+}
+
+func Test_RateLimitTransport_RetriesServerErrors(t *testing.T) {
+	transport, sleeps := testTransport(time.Date(2020, 6, 1, 10, 5, 0, 0, time.UTC))
+	url, requests := rateLimitServer(t, "100,1000", "10,250", http.StatusServiceUnavailable, http.StatusBadGateway)
+
+	resp, err := get(transport, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK || *requests != 3 {
+		t.Errorf("expected success on the 3rd request, got %d after %d requests", resp.StatusCode, *requests)
+	}
+	if len(*sleeps) != 2 || (*sleeps)[0] != time.Second || (*sleeps)[1] != 2*time.Second {
+		t.Errorf("expected exponential backoff, waited %v", *sleeps)
+	}
+}
+
+func Test_RateLimitTransport_RetriesTooManyRequests(t *testing.T) {
+	transport, sleeps := testTransport(time.Date(2020, 6, 1, 10, 5, 0, 0, time.UTC))
+	transport.MaxWait = shortWindow
+	url, requests := rateLimitServer(t, "100,1000", "50,250", http.StatusTooManyRequests)
+
+	resp, err := get(transport, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK || *requests != 2 {
+		t.Errorf("expected success on the 2nd request, got %d after %d requests", resp.StatusCode, *requests)
+	}
+	// backoff, then wait for the 15-minute window to reset
+	if len(*sleeps) != 2 || (*sleeps)[0] != time.Second {
+		t.Errorf("unexpected waits %v", *sleeps)
+	}
+}
+
+func Test_RateLimitTransport_GivesUp(t *testing.T) {
+	transport, _ := testTransport(time.Date(2020, 6, 1, 10, 5, 0, 0, time.UTC))
+	transport.MaxRetries = 2
+	url, requests := rateLimitServer(t, "100,1000", "10,250",
+		http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+
+	resp, err := get(transport, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusInternalServerError || *requests != 3 {
+		t.Errorf("expected to give up after 3 requests, got %d after %d requests", resp.StatusCode, *requests)
+	}
+}
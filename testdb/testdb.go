@@ -0,0 +1,50 @@
+// Package testdb prepares the database of the test suites.
+// It is only meant for tests: Reset drops every table.
+package testdb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gobuffalo/pop/v5"
+)
+
+// Reset migrates a SQLite database (built with `-tags sqlite`) from scratch with the (fizz) migrations in path,
+// so the suites need no database service (nor a schema loaded by `buffalo test`). Other databases are left as they are.
+// The tables left by a previous run are dropped first: the suites' TruncateAll also empties schema_migration,
+// so those tables would be created again
+func Reset(db *pop.Connection, path string) error {
+	if db.Dialect.Name() != "sqlite3" {
+		return nil
+	}
+	if err := dropTables(db); err != nil {
+		return err
+	}
+
+	migrator, err := pop.NewFileMigrator(path, db)
+	if err != nil {
+		return err
+	}
+	migrator.SchemaPath = "" // do not dump migrations/schema.sql
+	return migrator.Up()
+}
+
+// dropTables drops every (SQLite) table, with the foreign keys off, on the same connection
+func dropTables(db *pop.Connection) error {
+	names := []struct {
+		Name string `db:"name"`
+	}{}
+	if err := db.RawQuery("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'").All(&names); err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	stmts := []string{"PRAGMA foreign_keys = OFF"}
+	for _, n := range names {
+		stmts = append(stmts, fmt.Sprintf("DROP TABLE %s", db.Dialect.Quote(n.Name)))
+	}
+	stmts = append(stmts, "PRAGMA foreign_keys = ON")
+	return db.RawQuery(strings.Join(stmts, "; ")).Exec()
+}